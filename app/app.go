@@ -0,0 +1,84 @@
+// app is the registry of the applications that can be configured in udcp.apps
+//
+// Applications register a Factory from an init function, the factory decodes
+// the options of the application's entry in the configuration into the
+// application's own typed configuration e.g.
+//
+//	udcp:
+//	  apps:
+//	  - name: influx
+//	    addr: "127.0.0.1:9009"
+//	    database: ussdproxy
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+)
+
+// Factory creates an application from the options in its udcp.apps entry
+type Factory func(options map[string]interface{}) (ussdproxy.UdcpApplication, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes an application available by name to the configuration.
+// Register panics if it is called twice with the same name
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("app: Register factory is nil for " + name)
+	}
+	if _, exists := factories[name]; exists {
+		panic("app: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates the application registered with the given name
+func New(name string, options map[string]interface{}) (ussdproxy.UdcpApplication, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown app '%s', registered apps are %v", name, Registered())
+	}
+	application, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create app '%s': %v", name, err)
+	}
+	return application, nil
+}
+
+// Registered the sorted names of the registered applications
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DecodeOptions decodes the options of a udcp.apps entry into the application's
+// configuration struct using its mapstructure tags. Unknown options are an error
+func DecodeOptions(options map[string]interface{}, config interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           config,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(options)
+}
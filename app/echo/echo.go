@@ -2,25 +2,21 @@ package echo
 
 import (
 	"fmt"
-	"log"
 
+	"github.com/nndi-oss/ussdproxy/app"
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
 )
 
 // EchoApplication provides a basic "echo" service
 type EchoApplication struct {
-	ussdproxy.UdcpApplication
-
-	echoBufferOffset int64
-	state            ussdproxy.ApplicationState
-	session          ussdproxy.Session
+	state   ussdproxy.ApplicationState
+	session ussdproxy.Session
 }
 
 // NewEchoApplication creates a new EchoApplication
 func NewEchoApplication() *EchoApplication {
 	return &EchoApplication{
-		state:            ussdproxy.ApplicationReady,
-		echoBufferOffset: 0,
+		state: ussdproxy.ApplicationReady,
 	}
 }
 
@@ -67,7 +63,6 @@ func (app *EchoApplication) OnError(request ussdproxy.UdcpRequest, session ussdp
 // OnData returns the request/response handler for the Echo Application
 func (app *EchoApplication) OnData(request ussdproxy.UdcpRequest, session ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
 	if request.HasMoreToSend() {
-		app.echoBufferOffset = 0
 		fmt.Println("echo.OnData: Waiting for Client to send more data")
 		return ussdproxy.NewReceiveReadyResponse(), nil
 	}
@@ -101,42 +96,34 @@ func (app *EchoApplication) OnReleaseDialogue(request ussdproxy.UdcpRequest, ses
 	return ussdproxy.NewReleaseDialogueResponse(ussdproxy.ReleaseCodeUserAbortMask), nil
 }
 
+// echoRecvBuffer sends the next chunk of the send buffer, the rest stays in the
+// buffer of the session until the client is ready to receive it
 func (app *EchoApplication) echoRecvBuffer(request ussdproxy.UdcpRequest, session ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
 	buf := session.SendBuffer()
 	if buf.IsEmpty() {
 		fmt.Println("echo.OnReceiveReady(): Send buffer was empty")
 		return ussdproxy.NewReceiveReadyResponse(), nil
 	}
-	var seekOffset int64
-	var responseData []byte
-	moreToSend := false
-	dataLen := int64(buf.Length())
-	if dataLen <= ussdproxy.MaxDataLength {
-		// small enough buffer with less than 127 bytes
-		seekOffset = 0
-		responseData = make([]byte, dataLen)
-	} else {
-		moreToSend = true
-		// There's more than 127 bytes left in the buffer
-		responseData = make([]byte, ussdproxy.MaxDataLength)
-		seekOffset = app.echoBufferOffset
-		// If we have more data in the sendBuffer than we can send
-		// we have to start keeping track of offsets of our position in the send buffer
-		fmt.Printf("BufferSize:%d bytes Offset:%d seekOffset:%d\n", dataLen, app.echoBufferOffset, seekOffset)
-	}
-
-	if (dataLen - seekOffset) < ussdproxy.MaxDataLength {
-		moreToSend = false
-		responseData = make([]byte, dataLen-seekOffset)
-	}
-	_, err := buf.ReadAt(responseData, seekOffset)
+	data, err := buf.Read()
 	if err != nil {
-		log.Fatalf("Failed to read data from the SessionBuffer with (BufferSize:%d bytes Offset:%d seekOffset:%d). Got Error: %s \n", dataLen, app.echoBufferOffset, seekOffset, err)
 		return ussdproxy.NewErrorResponse(ussdproxy.ErrorCodeProtoErrorMask), nil
 	}
-	if moreToSend && app.echoBufferOffset < dataLen {
-		app.echoBufferOffset += ussdproxy.MaxDataLength
+	moreToSend := len(data) > ussdproxy.MaxDataLength
+	responseData := data
+	if moreToSend {
+		responseData = data[:ussdproxy.MaxDataLength]
+	}
+	if err := buf.Set(data[len(responseData):]); err != nil {
+		return ussdproxy.NewErrorResponse(ussdproxy.ErrorCodeProtoErrorMask), nil
 	}
-	fmt.Printf("Flushing out: %s \n", string(responseData))
 	return ussdproxy.NewDataResponse(request, responseData, moreToSend), nil
 }
+
+func init() {
+	app.Register("echo", func(options map[string]interface{}) (ussdproxy.UdcpApplication, error) {
+		if err := app.DecodeOptions(options, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return NewEchoApplication(), nil
+	})
+}
@@ -1,15 +1,18 @@
 package influx
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	lineprotocol "github.com/influxdata/line-protocol"
+	"github.com/nndi-oss/ussdproxy/app"
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/secret"
 )
 
 const (
@@ -18,11 +21,40 @@ const (
 	FormatJSON
 )
 
+// Config is the configuration of the influx entry in udcp.apps
+type Config struct {
+	Addr     string `mapstructure:"addr"`     // Address of the InfluxDB line protocol listener
+	Database string `mapstructure:"database"` // Measurement to write data points to
+	Username string `mapstructure:"username"` // May reference a secret e.g. env:NAME or file:/path
+	Password string `mapstructure:"password"` // May reference a secret e.g. env:NAME or file:/path
+}
+
+// DefaultConfig the configuration used for options that are not set
+func DefaultConfig() Config {
+	return Config{
+		Addr:     "127.0.0.1:9009",
+		Database: "ussdproxy",
+	}
+}
+
+func init() {
+	app.Register("influx", func(options map[string]interface{}) (ussdproxy.UdcpApplication, error) {
+		cfg := DefaultConfig()
+		if err := app.DecodeOptions(options, &cfg); err != nil {
+			return nil, err
+		}
+		if err := secret.ResolveAll(&cfg.Username, &cfg.Password); err != nil {
+			return nil, err
+		}
+		return NewInfluxApp(cfg.Addr, cfg.Database, cfg.Username, cfg.Password), nil
+	})
+}
+
 // InfluxDbApp provides an application that sends metrics to influxdb
 type InfluxDbApp struct {
-	ussdproxy.UdcpApplication
-
+	connMu      sync.Mutex // guards currentConn and state
 	currentConn net.Conn
+	state       ussdproxy.ApplicationState
 	InputFormat uint8
 	Addr        string
 	Username    string
@@ -31,19 +63,17 @@ type InfluxDbApp struct {
 	session     ussdproxy.Session
 }
 
+// NewInfluxApp creates the application, the connection to InfluxDB is only
+// established when the server initializes the application
 func NewInfluxApp(addr, database, user, password string) *InfluxDbApp {
-	conn1, err := net.Dial("tcp", addr) // test the conneciotn
-	if err != nil {
-		panic(fmt.Errorf("failed to connect to %s got: %v ", addr, err))
-	}
-	defer conn1.Close()
-
 	return &InfluxDbApp{
 		Database:    database,
 		Username:    user,
 		Password:    password,
 		Addr:        addr,
 		InputFormat: FormatPipeDelimited,
+		// the connection is established by the first write if the app is not initialized
+		state: ussdproxy.ApplicationReady,
 	}
 }
 
@@ -98,12 +128,16 @@ func (app *InfluxDbApp) onDataWriteToInflux(data []byte) error {
 		tags["app"] = "ussdproxy"
 	}
 
-	conn, err := net.Dial("tcp", app.Addr)
-	if err != nil {
-		log.Fatalf("failed to connect %v", err)
-		return err
+	app.connMu.Lock()
+	defer app.connMu.Unlock()
+	if app.currentConn == nil {
+		conn, err := net.Dial("tcp", app.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s got: %v", app.Addr, err)
+		}
+		app.currentConn = conn
 	}
-	serializer := lineprotocol.NewEncoder(conn)
+	serializer := lineprotocol.NewEncoder(app.currentConn)
 	serializer.SetMaxLineBytes(1024)
 	serializer.SetFieldTypeSupport(lineprotocol.UintSupport)
 	serializer.FailOnFieldErr(true)
@@ -116,12 +150,11 @@ func (app *InfluxDbApp) onDataWriteToInflux(data []byte) error {
 
 	_, err = serializer.Encode(event)
 	if err != nil {
+		// drop the connection so that the next write reconnects
+		app.currentConn.Close()
+		app.currentConn = nil
 		return fmt.Errorf("failed to send data got: %v", err)
 	}
-	err = conn.Close()
-	if err != nil {
-		return fmt.Errorf("failed to close connection got %v", err)
-	}
 	return nil
 }
 
@@ -145,6 +178,59 @@ func (app *InfluxDbApp) Register() {
 	// noop
 }
 
+// CurrentState the state of the application, it is Ready until it is stopped or shut down
+func (app *InfluxDbApp) CurrentState(sessionID string) ussdproxy.ApplicationState {
+	app.connMu.Lock()
+	defer app.connMu.Unlock()
+	return app.state
+}
+
+// Init connects to InfluxDB
+func (app *InfluxDbApp) Init() error {
+	app.connMu.Lock()
+	defer app.connMu.Unlock()
+	if app.currentConn == nil {
+		conn, err := net.Dial("tcp", app.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s got: %v", app.Addr, err)
+		}
+		app.currentConn = conn
+	}
+	app.state = ussdproxy.ApplicationReady
+	return nil
+}
+
+// Ready checks that InfluxDB is reachable
+func (app *InfluxDbApp) Ready() error {
+	conn, err := net.DialTimeout("tcp", app.Addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s got: %v", app.Addr, err)
+	}
+	return conn.Close()
+}
+
+// Stop closes the connection to InfluxDB
+func (app *InfluxDbApp) Stop() error {
+	return app.close(ussdproxy.ApplicationStopped)
+}
+
+// Shutdown closes the connection to InfluxDB if one is open
+func (app *InfluxDbApp) Shutdown(ctx context.Context) error {
+	return app.close(ussdproxy.ApplicationShutdown)
+}
+
+func (app *InfluxDbApp) close(state ussdproxy.ApplicationState) error {
+	app.connMu.Lock()
+	defer app.connMu.Unlock()
+	app.state = state
+	if app.currentConn == nil {
+		return nil
+	}
+	err := app.currentConn.Close()
+	app.currentConn = nil
+	return err
+}
+
 // OnError returns the request/response handler for the Echo Application
 func (app *InfluxDbApp) OnError(request ussdproxy.UdcpRequest, session ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
 	fmt.Printf("Received ErrorPdu, %s", request.Data())
@@ -1,19 +1,61 @@
 package mqtt
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nndi-oss/ussdproxy/app"
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/secret"
 )
 
+// Config is the configuration of the mqtt entry in udcp.apps
+type Config struct {
+	Broker   string `mapstructure:"broker"`   // e.g. tcp://localhost:1883
+	Topic    string `mapstructure:"topic"`    // Topic the data received from clients is published to
+	QoS      byte   `mapstructure:"qos"`      // 0, 1 or 2
+	Username string `mapstructure:"username"` // May reference a secret e.g. env:NAME or file:/path
+	Password string `mapstructure:"password"` // May reference a secret e.g. env:NAME or file:/path
+}
+
+// DefaultConfig the configuration used for options that are not set
+func DefaultConfig() Config {
+	return Config{
+		Broker: "tcp://localhost:1883",
+		Topic:  "ussdproxy",
+		QoS:    0,
+	}
+}
+
+func init() {
+	app.Register("mqtt", func(options map[string]interface{}) (ussdproxy.UdcpApplication, error) {
+		cfg := DefaultConfig()
+		if err := app.DecodeOptions(options, &cfg); err != nil {
+			return nil, err
+		}
+		if err := secret.ResolveAll(&cfg.Username, &cfg.Password); err != nil {
+			return nil, err
+		}
+		if cfg.QoS > 2 {
+			return nil, fmt.Errorf("invalid qos %d, expected 0, 1 or 2", cfg.QoS)
+		}
+		application := NewMQTTApplication(cfg.Broker, cfg.Username, cfg.Password, cfg.Topic)
+		application.QoS = cfg.QoS
+		return application, nil
+	})
+}
+
 // MQTTApplication provides an application that sends metrics to mqtt
 type MQTTApplication struct {
-	ussdproxy.UdcpApplication
-
+	clientMu sync.Mutex // guards Client and state
 	Client   MQTT.Client
+	state    ussdproxy.ApplicationState
 	Addr     string
 	Topic    string
+	QoS      byte
 	Username string
 	Password string
 	Database string
@@ -26,6 +68,8 @@ func NewMQTTApplication(addr, user, password, topic string) *MQTTApplication {
 		Password: password,
 		Addr:     addr,
 		Topic:    topic,
+		// the client connects on the first publish if the app is not initialized
+		state: ussdproxy.ApplicationReady,
 	}
 }
 
@@ -37,9 +81,12 @@ func (app *MQTTApplication) UseSession(session ussdproxy.Session) {
 	app.session = session
 }
 
-func (app *MQTTApplication) onDataWriteToMQTT(data []byte) error {
+// connect replaces the client with one connected to the broker, the caller holds clientMu
+func (app *MQTTApplication) connect() error {
 	opts := MQTT.NewClientOptions().AddBroker(app.Addr)
 	opts.SetClientID(app.Name())
+	opts.SetUsername(app.Username)
+	opts.SetPassword(app.Password)
 
 	// Noop handler for messages
 	opts.SetDefaultPublishHandler(func(client MQTT.Client, msg MQTT.Message) {
@@ -47,25 +94,65 @@ func (app *MQTTApplication) onDataWriteToMQTT(data []byte) error {
 		fmt.Printf("MSG: %s\n", msg.Payload())
 	})
 
-	if app.Client == nil {
-		app.Client = MQTT.NewClient(opts)
-	} else {
-		if !app.Client.IsConnected() {
-			app.Client = MQTT.NewClient(opts)
-		}
-	}
-
+	app.Client = MQTT.NewClient(opts)
 	if token := app.Client.Connect(); token.Wait() && token.Error() != nil {
 		return fmt.Errorf("failed to connect to the server, got %v", token.Error())
 	}
+	return nil
+}
+
+func (app *MQTTApplication) onDataWriteToMQTT(data []byte) error {
+	app.clientMu.Lock()
+	if app.Client == nil || !app.Client.IsConnected() {
+		if err := app.connect(); err != nil {
+			app.clientMu.Unlock()
+			return err
+		}
+	}
+	client := app.Client
+	app.clientMu.Unlock()
 
-	if token := app.Client.Publish(app.Topic, 0, false, string(data)); token.Wait() && token.Error() != nil {
+	if token := client.Publish(app.Topic, app.QoS, false, string(data)); token.Wait() && token.Error() != nil {
 		return fmt.Errorf("failed to publish to the server, got %v", token.Error())
 	}
 
 	return nil
 }
 
+// CurrentState the state of the application, it is Ready until it is stopped or shut down
+func (app *MQTTApplication) CurrentState(sessionID string) ussdproxy.ApplicationState {
+	app.clientMu.Lock()
+	defer app.clientMu.Unlock()
+	return app.state
+}
+
+// Init connects to the MQTT broker
+func (app *MQTTApplication) Init() error {
+	app.clientMu.Lock()
+	defer app.clientMu.Unlock()
+	if err := app.connect(); err != nil {
+		return err
+	}
+	app.state = ussdproxy.ApplicationReady
+	return nil
+}
+
+// Ready checks that the connection to the MQTT broker is up
+func (app *MQTTApplication) Ready() error {
+	app.clientMu.Lock()
+	defer app.clientMu.Unlock()
+	if app.Client == nil || !app.Client.IsConnected() {
+		return fmt.Errorf("not connected to %s", app.Addr)
+	}
+	return nil
+}
+
+// Stop disconnects from the MQTT broker
+func (app *MQTTApplication) Stop() error {
+	app.disconnect(ussdproxy.ApplicationStopped, 250)
+	return nil
+}
+
 // Name the name of the application
 func (app *MQTTApplication) Name() string {
 	return "MQTTDB Forwarder"
@@ -86,6 +173,30 @@ func (app *MQTTApplication) Register() {
 	// noop
 }
 
+// Shutdown disconnects from the MQTT broker, waiting for in-flight publishes
+// until the context is done
+func (app *MQTTApplication) Shutdown(ctx context.Context) error {
+	quiesce := uint(250)
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining > 0 {
+			quiesce = uint(remaining.Milliseconds())
+		}
+	}
+	app.disconnect(ussdproxy.ApplicationShutdown, quiesce)
+	return nil
+}
+
+// disconnect waits quiesce milliseconds for in-flight publishes and disconnects
+func (app *MQTTApplication) disconnect(state ussdproxy.ApplicationState, quiesce uint) {
+	app.clientMu.Lock()
+	defer app.clientMu.Unlock()
+	app.state = state
+	if app.Client == nil || !app.Client.IsConnected() {
+		return
+	}
+	app.Client.Disconnect(quiesce)
+}
+
 // OnError returns the request/response handler for the Echo Application
 func (app *MQTTApplication) OnError(request ussdproxy.UdcpRequest, session ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
 	fmt.Printf("Received ErrorPdu, %s", request.Data())
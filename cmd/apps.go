@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"github.com/nndi-oss/ussdproxy/app"
+	_ "github.com/nndi-oss/ussdproxy/app/echo"
+	_ "github.com/nndi-oss/ussdproxy/app/influx"
+	_ "github.com/nndi-oss/ussdproxy/app/mqtt"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	ussdproxyconfig "github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// addServerFlags adds the flags to override where a standalone app server listens
+func addServerFlags(cmd *cobra.Command) {
+	cmd.Flags().String("host", "", "Host to listen on, overrides server.host")
+	cmd.Flags().Int("port", 0, "Port to listen on, overrides server.port")
+}
+
+// serverConfig returns a copy of the configuration with the server flags that were set applied
+func serverConfig(cmd *cobra.Command) *ussdproxyconfig.UssdProxyConfig {
+	cfg := *config
+	if cmd.Flags().Changed("host") {
+		cfg.Server.Host, _ = cmd.Flags().GetString("host")
+	}
+	if cmd.Flags().Changed("port") {
+		cfg.Server.Port, _ = cmd.Flags().GetInt("port")
+	}
+	return &cfg
+}
+
+// configuredApplication creates the named application with the options of its
+// udcp.apps entry, the given option flags override the options if they were set
+func configuredApplication(cmd *cobra.Command, name string, optionFlags ...string) (ussdproxy.UdcpApplication, error) {
+	options := make(map[string]interface{})
+	for _, appConfig := range config.Udcp.Apps {
+		if appConfig.Name == name {
+			for key, value := range appConfig.Options {
+				options[key] = value
+			}
+		}
+	}
+	for _, flag := range optionFlags {
+		if cmd.Flags().Changed(flag) {
+			options[flag] = cmd.Flags().Lookup(flag).Value.String()
+		}
+	}
+	return app.New(name, options)
+}
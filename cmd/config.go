@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	ussdproxyconfig "github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with the ussdproxy configuration",
+	Long:  `Work with the ussdproxy configuration`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates the configuration file",
+	Long:  `Validates the configuration file, reporting every problem found with its path in the file. Exits with a non-zero status if the configuration is invalid`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ussdproxyconfig.Validate(*config); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		source := viper.ConfigFileUsed()
+		if source == "" {
+			source = "environment"
+		}
+		fmt.Printf("%s: configuration is valid\n", source)
+	},
+}
+
+var configEnvCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Lists the environment variables that override the configuration",
+	Long:  `Lists the environment variable that overrides each configuration key. Values may reference a secret in another environment variable (env:NAME) or a file (file:/path). ussd.providers and udcp.apps can only be set in the configuration file`,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, key := range ussdproxyconfig.EnvKeys() {
+			fmt.Printf("%-40s %s\n", ussdproxyconfig.EnvVar(key), key)
+		}
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configEnvCmd)
+}
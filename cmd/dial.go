@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/client"
+	"github.com/nndi-oss/ussdproxy/pkg/client/simulator"
+	"github.com/spf13/cobra"
+)
+
+const dialHelp = `Type a PDU to send it as it is, e.g. D;hello or X;, or one of the commands
+  app <id>           select the application of the dialogue
+  send <data>        send the data, in chunks if it is long
+  file <path>        send the content of the file
+  query <query>      send the query, e.g. query q:apps
+  command <command>  send the command, e.g. command c:app id:echo
+  receive            poll the server for data
+  dial               start a new session
+  help               show this help
+  quit               release the dialogue and exit`
+
+var (
+	dialProvider    string
+	dialServiceCode string
+	dialMsisdn      string
+	dialTimeout     time.Duration
+)
+
+func init() {
+	dialCmd.Flags().StringVar(&dialProvider, "provider", simulator.AfricasTalking, "Provider the callbacks are sent as, one of "+strings.Join(simulator.Providers(), ", "))
+	dialCmd.Flags().StringVar(&dialServiceCode, "service-code", "*1234#", "Service code to dial")
+	dialCmd.Flags().StringVar(&dialMsisdn, "msisdn", "", "Msisdn of the subscriber (default a random number)")
+	dialCmd.Flags().DurationVar(&dialTimeout, "timeout", 30*time.Second, "Time to wait for each reply of the server")
+}
+
+var dialCmd = &cobra.Command{
+	Use:   "dial <callback-url>",
+	Short: "Opens an interactive session with a running server",
+	Long: `Opens an interactive session with a running server, sending the input as
+the callbacks of the provider to the callback URL e.g.
+
+  ussdproxy dial --provider truroute http://localhost:3000/ussd/callback/truroute
+
+Every message is shown with the decoded reply of the server, which is polled
+with R; while it has more to send.
+
+` + dialHelp,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		transport, err := simulator.New(simulator.Config{
+			Provider: dialProvider,
+			URL:      args[0],
+			Msisdn:   dialMsisdn,
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		d := &dialSession{
+			transport: &tracingTransport{Transport: transport, out: cmd.OutOrStdout()},
+			out:       cmd.OutOrStdout(),
+		}
+		fmt.Fprintf(d.out, "Dialling %s as %s from %s, type help for the commands\n", dialServiceCode, dialProvider, transport.Msisdn())
+		d.dial()
+		d.run(cmd.InOrStdin())
+	},
+}
+
+// dialSession is the interactive session of the dial command
+type dialSession struct {
+	transport *tracingTransport
+	client    *client.Client // nil until a dial succeeds
+	out       io.Writer
+}
+
+// run reads and executes the lines of input until quit or the end of the input
+func (d *dialSession) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for fmt.Fprint(d.out, "ussd> "); scanner.Scan(); fmt.Fprint(d.out, "ussd> ") {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			break
+		}
+		if err := d.execute(line); err != nil {
+			fmt.Fprintln(d.out, "error:", err)
+		}
+	}
+	if d.client != nil {
+		d.client.Close()
+	}
+	fmt.Fprintln(d.out)
+}
+
+func (d *dialSession) execute(line string) error {
+	if line == "" {
+		return nil
+	}
+	command, argument := line, ""
+	if i := strings.Index(line, " "); i > 0 {
+		command, argument = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch command {
+	case "help":
+		fmt.Fprintln(d.out, dialHelp)
+		return nil
+	case "dial":
+		d.dial()
+		return nil
+	}
+	if d.client == nil {
+		return errors.New("there is no session, type dial to start one")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	var (
+		result []byte
+		err    error
+	)
+	if len(line) >= 2 && ussdproxy.RequestPduType(line[:2]) != ussdproxy.InvalidPduType {
+		// PDUs go through the client so it knows when the dialogue ends
+		command, argument = "pdu", line
+	}
+	switch command {
+	case "pdu":
+		result, err = d.client.SendPDU(ctx, argument)
+	case "app":
+		err = d.client.SelectApp(ctx, argument)
+	case "send":
+		result, err = d.client.Send(ctx, []byte(argument))
+	case "file":
+		data, readErr := ioutil.ReadFile(argument)
+		if readErr != nil {
+			return readErr
+		}
+		result, err = d.client.Send(ctx, data)
+	case "query":
+		result, err = d.client.Query(ctx, argument)
+	case "command":
+		result, err = d.client.Command(ctx, argument)
+	case "receive":
+		result, err = d.client.Receive(ctx)
+	default:
+		return fmt.Errorf("unknown command '%s', type help for the commands", command)
+	}
+	if result != nil {
+		fmt.Fprintf(d.out, "= %s\n", result)
+	}
+	switch {
+	case errors.Is(err, client.ErrReleased):
+		fmt.Fprintf(d.out, "the dialogue was released (%s), type dial to start a new session\n", d.client.ReleaseReason())
+		return nil
+	case errors.Is(err, client.ErrClosed):
+		return fmt.Errorf("%w, type dial to start a new session", err)
+	}
+	return err
+}
+
+// dial starts a new session, the client waits for the server to be ready to receive
+func (d *dialSession) dial() {
+	if d.client != nil {
+		d.client.Close()
+		d.client = nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	c, err := client.Dial(ctx, d.transport, dialServiceCode)
+	if err != nil {
+		fmt.Fprintln(d.out, "error:", err)
+		return
+	}
+	d.client = c
+}
+
+// tracingTransport shows the messages of the dialogue and the decoded replies
+type tracingTransport struct {
+	client.Transport
+	out io.Writer
+}
+
+func (t *tracingTransport) Dial(ctx context.Context, serviceCode string) (string, error) {
+	fmt.Fprintf(t.out, "-> %s\n", serviceCode)
+	return t.trace(t.Transport.Dial(ctx, serviceCode))
+}
+
+func (t *tracingTransport) Send(ctx context.Context, message string) (string, error) {
+	fmt.Fprintf(t.out, "-> %s\n", message)
+	return t.trace(t.Transport.Send(ctx, message))
+}
+
+// Close keeps the transport open, a new session is started with Dial
+func (t *tracingTransport) Close() error {
+	return nil
+}
+
+func (t *tracingTransport) trace(reply string, err error) (string, error) {
+	if err != nil {
+		if errors.Is(err, client.ErrDialogueEnded) {
+			return reply, fmt.Errorf("%w, type dial to start a new session", err)
+		}
+		return reply, err
+	}
+	fmt.Fprintf(t.out, "<- %-24s %s\n", reply, describePdu(reply))
+	return reply, nil
+}
+
+// describePdu describes the PDU the server replied with
+func describePdu(pdu string) string {
+	header, data, err := ussdproxy.Decode([]byte(pdu))
+	if err != nil {
+		return "(not a UDCP PDU)"
+	}
+	switch header.Type {
+	case ussdproxy.ReceiveReadyPduType:
+		return "(receive ready)"
+	case ussdproxy.DataLongPduType:
+		return fmt.Sprintf("(data, %d bytes)", len(data))
+	case ussdproxy.DataPduWithMtsType:
+		return fmt.Sprintf("(data, %d bytes, more to send)", len(data))
+	case ussdproxy.ReleaseDialogPduType:
+		return fmt.Sprintf("(released: %s)", header.ReleaseReason)
+	case ussdproxy.ErrorPduType:
+		return fmt.Sprintf("(error %02x: %s)", uint8(header.ErrorCode), header.ErrorCode)
+	default:
+		return fmt.Sprintf("(%s %d bytes)", header.Type.String(), len(data))
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nndi-oss/ussdproxy/app/echo"
+	"github.com/nndi-oss/ussdproxy/pkg/client/simulator"
+	"github.com/nndi-oss/ussdproxy/pkg/server"
+	"github.com/nndi-oss/ussdproxy/pkg/session/memory"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+// dialServer starts a server for the echo app and a dial session with it
+func dialServer(t *testing.T) (*dialSession, *bytes.Buffer) {
+	t.Helper()
+	provider, err := ussd.New(simulator.AfricasTalking, nil)
+	if err != nil {
+		t.Fatalf("failed to create the provider: %v", err)
+	}
+	ts := httptest.NewServer(server.NewHandler(provider, memory.New(), echo.NewEchoApplication()))
+	t.Cleanup(ts.Close)
+	transport, err := simulator.New(simulator.Config{Provider: simulator.AfricasTalking, URL: ts.URL, Msisdn: "265991234567"})
+	if err != nil {
+		t.Fatalf("failed to create the simulator: %v", err)
+	}
+	out := &bytes.Buffer{}
+	d := &dialSession{
+		transport: &tracingTransport{Transport: transport, out: out},
+		out:       out,
+	}
+	d.dial()
+	if d.client == nil {
+		t.Fatalf("failed to dial: %s", out)
+	}
+	return d, out
+}
+
+// TestDialHelp the queries and commands in the help are answered by the server
+func TestDialHelp(t *testing.T) {
+	d, out := dialServer(t)
+	for _, line := range strings.Split(dialHelp, "\n") {
+		i := strings.Index(line, "e.g. ")
+		if i < 0 || !strings.HasPrefix(strings.TrimSpace(line), "query") && !strings.HasPrefix(strings.TrimSpace(line), "command") {
+			continue
+		}
+		example := line[i+len("e.g. "):]
+		out.Reset()
+		if err := d.execute(example); err != nil {
+			t.Errorf("%s: %v\n%s", example, err, out)
+		}
+	}
+}
+
+func TestDialSession(t *testing.T) {
+	d, out := dialServer(t)
+	steps := []struct {
+		line     string
+		expected string
+	}{
+		{"app echo", "<- R;__NODATA__"},
+		{"query q:apps", "= echo"},
+		{"send hello", "= hello"},
+		{"D;raw", "= raw"},
+		{"X;", "the dialogue was released (user abort)"},
+		{"send hello", "error: the dialogue has ended, type dial to start a new session"},
+		{"dial", "-> *1234#"},
+		{"send again", "= again"},
+		{"unknown", "error: unknown command 'unknown'"},
+	}
+	for _, step := range steps {
+		out.Reset()
+		if err := d.execute(step.line); err != nil {
+			out.WriteString("error: " + err.Error())
+		}
+		if !strings.Contains(out.String(), step.expected) {
+			t.Errorf("%s: expected %q in the output, got\n%s", step.line, step.expected, out)
+		}
+	}
+}
+
+func TestDialWithoutSession(t *testing.T) {
+	d := &dialSession{out: &bytes.Buffer{}}
+	if err := d.execute("D;hello"); err == nil || !strings.Contains(err.Error(), "there is no session") {
+		t.Errorf("expected an error without a session, got %v", err)
+	}
+}
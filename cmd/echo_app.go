@@ -3,20 +3,25 @@ package cmd
 import (
 	"log"
 
-	"github.com/nndi-oss/ussdproxy/app/echo"
 	"github.com/nndi-oss/ussdproxy/pkg/server"
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	addServerFlags(echoAppCmd)
+}
+
 var echoAppCmd = &cobra.Command{
 	Use:   "echo",
 	Short: "Starts the echo server",
 	Long:  `Starts the echo server`,
 	Run: func(cmd *cobra.Command, args []string) {
-		addr := "localhost:3000"
-		echoAplication := echo.NewEchoApplication()
+		echoAplication, err := configuredApplication(cmd, "echo")
+		if err != nil {
+			log.Fatalf("Failed to create Echo Application. Error %s", err)
+		}
 
-		if err := server.ListenAndServe(addr, echoAplication); err != nil {
+		if err := server.ListenAndServe(serverConfig(cmd), echoAplication); err != nil {
 			log.Fatalf("Failed to start Echo Application. Error %s", err)
 		}
 	},
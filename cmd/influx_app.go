@@ -3,18 +3,28 @@ package cmd
 import (
 	"log"
 
-	"github.com/nndi-oss/ussdproxy/app/influx"
 	"github.com/nndi-oss/ussdproxy/pkg/server"
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	addServerFlags(influxAppCmd)
+	influxAppCmd.Flags().String("addr", "", "Address of the InfluxDB line protocol listener, overrides the influx app addr")
+	influxAppCmd.Flags().String("database", "", "Measurement to write data points to, overrides the influx app database")
+	influxAppCmd.Flags().String("username", "", "InfluxDB username, overrides the influx app username")
+	influxAppCmd.Flags().String("password", "", "InfluxDB password, overrides the influx app password")
+}
+
 var influxAppCmd = &cobra.Command{
 	Use:   "influx-proxy",
 	Short: "Starts the influx proxy server",
 	Long:  `Starts the influx proxy server`,
 	Run: func(cmd *cobra.Command, args []string) {
-		influxApplication := influx.NewInfluxApp("127.0.0.1:9009", "ussdproxy", "user", "password")
-		if err := server.ListenAndServe("localhost:3000", influxApplication); err != nil {
+		influxApplication, err := configuredApplication(cmd, "influx", "addr", "database", "username", "password")
+		if err != nil {
+			log.Fatalf("Failed to create Influx Application. Error %s", err)
+		}
+		if err := server.ListenAndServe(serverConfig(cmd), influxApplication); err != nil {
 			log.Fatalf("Failed to start Influx Application. Error %s", err)
 		}
 	},
@@ -3,18 +3,29 @@ package cmd
 import (
 	"log"
 
-	"github.com/nndi-oss/ussdproxy/app/mqtt"
 	"github.com/nndi-oss/ussdproxy/pkg/server"
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	addServerFlags(mqttAppCmd)
+	mqttAppCmd.Flags().String("broker", "", "MQTT broker e.g. tcp://localhost:1883, overrides the mqtt app broker")
+	mqttAppCmd.Flags().String("topic", "", "Topic to publish data to, overrides the mqtt app topic")
+	mqttAppCmd.Flags().Int("qos", 0, "QoS to publish with (0, 1 or 2), overrides the mqtt app qos")
+	mqttAppCmd.Flags().String("username", "", "MQTT username, overrides the mqtt app username")
+	mqttAppCmd.Flags().String("password", "", "MQTT password, overrides the mqtt app password")
+}
+
 var mqttAppCmd = &cobra.Command{
 	Use:   "mqtt-proxy",
 	Short: "Starts the mqtt proxy server",
 	Long:  `Starts the mqtt proxy server`,
 	Run: func(cmd *cobra.Command, args []string) {
-		mqttApplication := mqtt.NewMQTTApplication("tcp://localhost:1883", "user", "password", "some/topic")
-		if err := server.ListenAndServe("localhost:3000", mqttApplication); err != nil {
+		mqttApplication, err := configuredApplication(cmd, "mqtt", "broker", "topic", "qos", "username", "password")
+		if err != nil {
+			log.Fatalf("Failed to create MQTT Application. Error %s", err)
+		}
+		if err := server.ListenAndServe(serverConfig(cmd), mqttApplication); err != nil {
 			log.Fatalf("Failed to start MQTT Application. Error %s", err)
 		}
 	},
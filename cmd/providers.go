@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/spf13/cobra"
+)
+
+var providersCmd = &cobra.Command{
+	Use:   "providers",
+	Short: "Lists the USSD providers compiled in",
+	Long:  `Lists the USSD providers compiled in, which can be set as the provider of ussd or of an entry in ussd.providers`,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, name := range ussd.Registered() {
+			fmt.Println(name)
+		}
+	},
+}
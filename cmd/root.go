@@ -6,6 +6,7 @@ import (
 	"path"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/joho/godotenv"
 	"github.com/mitchellh/go-homedir"
 	ussdproxyconfig "github.com/nndi-oss/ussdproxy/pkg/config"
 	"github.com/spf13/cobra"
@@ -13,12 +14,14 @@ import (
 )
 
 var cfgFile string
+var envFile string
 var config = &ussdproxyconfig.UssdProxyConfig{}
 var logger hclog.Logger
 
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is /etc/ussdproxy.yml)")
+	rootCmd.PersistentFlags().StringVar(&envFile, "env-file", ".env", "file of environment variables to load if it exists")
 	rootCmd.PersistentFlags().Bool("vvv", true, "Verbose output")
 
 	rootCmd.AddCommand(serverCmd)
@@ -26,10 +29,29 @@ func init() {
 	rootCmd.AddCommand(influxAppCmd)
 	rootCmd.AddCommand(mqttAppCmd)
 	rootCmd.AddCommand(adminCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(providersCmd)
+	rootCmd.AddCommand(dialCmd)
 }
 
 func initConfig() {
 	var home string
+	// Variables already set in the environment take precedence over the env file
+	if err := godotenv.Load(envFile); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Can't read env file:", err)
+		os.Exit(1)
+	}
+	viper.SetEnvPrefix(ussdproxyconfig.EnvPrefix)
+	viper.SetEnvKeyReplacer(ussdproxyconfig.EnvKeyReplacer)
+	viper.AutomaticEnv()
+	// AutomaticEnv only applies to keys viper knows about, bind every key so
+	// settings missing from the configuration file can be set from the environment
+	for _, key := range ussdproxyconfig.EnvKeys() {
+		viper.BindEnv(key)
+	}
+	// the port is validated, a configuration without one listens on the default
+	viper.SetDefault("server.port", ussdproxyconfig.DefaultPort)
+
 	// Don't forget to read config either from cfgFile or from home directory!
 	if cfgFile != "" {
 		// Use config file from the flag.
@@ -48,11 +70,15 @@ func initConfig() {
 	}
 
 	if err := viper.ReadInConfig(); err != nil {
-		fmt.Println("Can't read config:", err)
-		os.Exit(1)
+		// Without a configuration file the configuration is read from the environment
+		if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound {
+			fmt.Println("Can't read config:", err)
+			os.Exit(1)
+		}
 	}
 
-	if err := viper.Unmarshal(config); err != nil {
+	var err error
+	if config, err = unmarshalConfig(); err != nil {
 		fmt.Println("Can't read config:", err)
 		os.Exit(1)
 	}
@@ -68,6 +94,19 @@ func initConfig() {
 	})
 }
 
+// unmarshalConfig the configuration from the configuration file and the
+// environment, with references to secrets resolved
+func unmarshalConfig() (*ussdproxyconfig.UssdProxyConfig, error) {
+	cfg := &ussdproxyconfig.UssdProxyConfig{}
+	if err := viper.Unmarshal(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "ussdproxy",
 	Short: "UssdProxy",
@@ -1,28 +1,155 @@
 package cmd
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-hclog"
+	ussdproxyconfig "github.com/nndi-oss/ussdproxy/pkg/config"
 	"github.com/nndi-oss/ussdproxy/pkg/server"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
+var shutdownTimeout time.Duration
+
+func init() {
+	serverCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time to wait for in-flight requests to complete on shutdown")
+}
+
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Starts the server",
 	Long:  `Starts the server`,
 	Run: func(cmd *cobra.Command, args []string) {
-		s := server.NewUssdProxyServer()
-		err := godotenv.Load()
+		if err := ussdproxyconfig.Validate(*config); err != nil {
+			log.Printf("Invalid configuration. Error %s", err)
+			os.Exit(1)
+		}
+		s, err := server.NewUssdProxyServer(config)
 		if err != nil {
-			log.Println("Failed to load .env")
+			log.Printf("Failed to create server. Error %s", err)
+			os.Exit(1)
+		}
+
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- s.ListenAndServe()
+		}()
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		configChanged := make(chan struct{}, 1)
+		if file := viper.ConfigFileUsed(); file != "" {
+			watcher, err := watchConfigFile(file, configChanged)
+			if err != nil {
+				logger.Warn("the configuration is only reloaded on SIGHUP", "error", err)
+			} else {
+				defer watcher.Close()
+			}
+		}
+
+	serve:
+		for {
+			select {
+			case err := <-serveErr:
+				log.Printf("Server stopped. Error %s", err)
+				os.Exit(1)
+			case sig := <-stop:
+				log.Printf("Received %s, shutting down", sig)
+				break serve
+			case <-hangup:
+				reloadConfig(s)
+			case <-configChanged:
+				reloadConfig(s)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shutdown cleanly. Error %s", err)
 			os.Exit(1)
-			return
 		}
-		addr := "localhost:3000"
-		s.ListenAndServe(addr)
-		os.Exit(1)
 	},
 }
+
+// watchConfigFile signals changed when the configuration file is written or
+// replaced. The configuration is only read by reloadConfig on the main
+// goroutine, for a signal from the watcher or SIGHUP, so reloads never race
+func watchConfigFile(file string, changed chan<- struct{}) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	file = filepath.Clean(file)
+	// the directory is watched as editors replace the file rather than write to it
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != file || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				select {
+				case changed <- struct{}{}:
+				default: // a reload is already pending
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("failed to watch the configuration file", "error", err)
+			}
+		}
+	}()
+	return watcher, nil
+}
+
+// reloadConfig reads the configuration file again and applies the settings that
+// can change while the server is running. An invalid configuration is ignored
+func reloadConfig(s *server.UssdProxyServer) {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			logger.Error("failed to reload configuration", "error", err)
+			return
+		}
+	}
+	next, err := unmarshalConfig()
+	if err != nil {
+		logger.Error("failed to reload configuration", "error", err)
+		return
+	}
+	if err := ussdproxyconfig.Validate(*next); err != nil {
+		logger.Error("ignoring invalid configuration", "error", err)
+		return
+	}
+
+	restartRequired, err := s.Reload(next)
+	if err != nil {
+		logger.Error("failed to reload configuration", "error", err)
+		return
+	}
+	if next.Logging.Level != "" {
+		logger.SetLevel(hclog.LevelFromString(next.Logging.Level))
+	}
+	config = next
+	for _, path := range restartRequired {
+		logger.Warn("setting changed but only takes effect after a restart", "setting", path)
+	}
+}
@@ -1,6 +1,11 @@
 package ussdproxy
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
 
 type ApplicationState uint8
 
@@ -12,6 +17,23 @@ const (
 	ApplicationShutdown
 )
 
+func (s ApplicationState) String() string {
+	switch s {
+	case ApplicationInitializing:
+		return "INITIALIZING"
+	case ApplicationInitialized:
+		return "INITIALIZED"
+	case ApplicationReady:
+		return "READY"
+	case ApplicationStopped:
+		return "STOPPED"
+	case ApplicationShutdown:
+		return "SHUTDOWN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 // UdcpApplication is an application that can be executed by the UdcpServer
 type UdcpApplication interface {
 	ApplicationID() string
@@ -27,6 +49,31 @@ type UdcpApplication interface {
 	UseSession(Session)
 }
 
+// ApplicationLifecycle is implemented by applications that must be initialized
+// before they can process requests, e.g. to connect to an external system.
+// The server calls Init when it starts, Ready to check that the application
+// can still process requests and Stop before restarting it or shutting down
+type ApplicationLifecycle interface {
+	Init() error
+	Ready() error
+	Stop() error
+}
+
+// ShutdownHook is implemented by applications that hold connections to external
+// systems which must be closed cleanly when the server shuts down
+type ShutdownHook interface {
+	Shutdown(ctx context.Context) error
+}
+
+// ApplicationSelector is implemented by applications which forward requests to
+// one of several applications. Clients select the application of their session
+// with an Application PDU e.g. A;application=echo. The selection is cleared
+// when the session is removed from the store
+type ApplicationSelector interface {
+	SelectApplication(session Session, applicationID string) error
+	ClearSelection(sessionID string)
+}
+
 // MultiplexingApplication The Core Application is an application that enables configuring the server,
 // choosing applications and controlling the session. The core application
 // is like a middleware that handles requests and then forwards them to the
@@ -34,6 +81,38 @@ type UdcpApplication interface {
 type MultiplexingApplication struct {
 	currentApp            UdcpApplication
 	availableApplications []UdcpApplication // currently registered/active application
+
+	selection *applicationSelection
+
+	mu      sync.Mutex // guards session
+	session Session    // session in use, see UseSession
+}
+
+// applicationSelection the ID of the application selected by each session until
+// the dialogue is released, shared by the views of WithDefaultApplication and
+// WithApplications
+type applicationSelection struct {
+	mu       sync.Mutex
+	selected map[string]string
+}
+
+func (s *applicationSelection) get(sessionID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.selected[sessionID]
+	return id, ok
+}
+
+func (s *applicationSelection) set(sessionID, applicationID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selected[sessionID] = applicationID
+}
+
+func (s *applicationSelection) clear(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.selected, sessionID)
 }
 
 func NewMultiplexingApplication(apps ...UdcpApplication) *MultiplexingApplication {
@@ -44,6 +123,36 @@ func NewMultiplexingApplication(apps ...UdcpApplication) *MultiplexingApplicatio
 	return &MultiplexingApplication{
 		availableApplications: apps,
 		currentApp:            apps[0],
+		selection:             &applicationSelection{selected: make(map[string]string)},
+	}
+}
+
+// WithDefaultApplication returns a MultiplexingApplication for the same
+// applications which forwards requests to the application at index instead.
+// Sessions keep the application they selected with either of them
+func (a *MultiplexingApplication) WithDefaultApplication(index int) *MultiplexingApplication {
+	if index < 0 || index >= len(a.availableApplications) {
+		panic("WithDefaultApplication: invalid argument provided for 'index'")
+	}
+	return &MultiplexingApplication{
+		availableApplications: a.availableApplications,
+		currentApp:            a.availableApplications[index],
+		selection:             a.selection,
+	}
+}
+
+// WithApplications returns a MultiplexingApplication for the given applications,
+// the first of which is the default application. Sessions keep the application
+// they selected if one with the same ID is among apps, so the applications can
+// be replaced without interrupting the dialogues
+func (a *MultiplexingApplication) WithApplications(apps ...UdcpApplication) *MultiplexingApplication {
+	if len(apps) < 1 {
+		panic("WithApplications: invalid argument provided for 'apps'")
+	}
+	return &MultiplexingApplication{
+		availableApplications: apps,
+		currentApp:            apps[0],
+		selection:             a.selection,
 	}
 }
 
@@ -63,36 +172,150 @@ func (a *MultiplexingApplication) Register() {
 
 }
 
-func (a *MultiplexingApplication) CurrentState(string) ApplicationState {
-	return ApplicationReady
+// CurrentState is the state of the application currently processing requests
+func (a *MultiplexingApplication) CurrentState(sessionID string) ApplicationState {
+	return a.application(sessionID).CurrentState(sessionID)
+}
+
+// application the application selected by the session, the default application otherwise
+func (a *MultiplexingApplication) application(sessionID string) UdcpApplication {
+	if id, ok := a.selection.get(sessionID); ok {
+		for _, app := range a.availableApplications {
+			if app.ApplicationID() == id {
+				return app
+			}
+		}
+	}
+	return a.currentApp
 }
 
 func (a *MultiplexingApplication) OnData(request UdcpRequest, session Session) (UdcpResponse, error) {
-	// TODO: select the current application
-	return ProcessUdcpRequest(request, a.currentApp)
+	return a.application(session.SessionID()).OnData(request, session)
 }
 
 func (a *MultiplexingApplication) OnReceiveReady(request UdcpRequest, session Session) (UdcpResponse, error) {
-	return a.currentApp.OnReceiveReady(request, session)
+	return a.application(session.SessionID()).OnReceiveReady(request, session)
 }
 
 func (a *MultiplexingApplication) OnError(request UdcpRequest, session Session) (UdcpResponse, error) {
-	return a.currentApp.OnError(request, session)
+	return a.application(session.SessionID()).OnError(request, session)
 }
 
 func (a *MultiplexingApplication) OnReleaseDialogue(request UdcpRequest, session Session) (UdcpResponse, error) {
-	return a.currentApp.OnReleaseDialogue(request, session)
+	app := a.application(session.SessionID())
+	a.selection.clear(session.SessionID())
+	return app.OnReleaseDialogue(request, session)
 }
 
 func (a *MultiplexingApplication) GetOrCreateSession() Session {
-	return a.currentApp.GetOrCreateSession()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.session
 }
 
+// UseSession sets the session returned by GetOrCreateSession, for callers of
+// ProcessUdcpRequest. Requests are forwarded to the application selected by the
+// session they are processed in
 func (a *MultiplexingApplication) UseSession(session Session) {
-	a.currentApp.UseSession(session)
+	a.mu.Lock()
+	a.session = session
+	a.mu.Unlock()
+	a.application(session.SessionID()).UseSession(session)
+}
+
+// SelectApplication forwards the requests of the session to the available
+// application with the ID until the dialogue is released
+func (a *MultiplexingApplication) SelectApplication(session Session, applicationID string) error {
+	for _, app := range a.availableApplications {
+		if app.ApplicationID() != applicationID {
+			continue
+		}
+		a.selection.set(session.SessionID(), applicationID)
+		return nil
+	}
+	return fmt.Errorf("application '%s' is not available", applicationID)
+}
+
+// ClearSelection forwards the requests of the session to the default application again
+func (a *MultiplexingApplication) ClearSelection(sessionID string) {
+	a.selection.clear(sessionID)
+}
+
+// Query answers the queries of the UDCP extensions for applications and
+// sessions, other queries are forwarded to the application of the session
+//
+//	q:apps                the IDs of the available applications e.g. echo,mqtt
+//	q:app [verbose:true]  the ID of the application of the session, with its name and author if verbose
+//	q:sessID              the ID of the session
+func (a *MultiplexingApplication) Query(op Operation, session Session) (string, error) {
+	switch op.Name {
+	case "apps":
+		ids := make([]string, len(a.availableApplications))
+		for i, app := range a.availableApplications {
+			ids[i] = app.ApplicationID()
+		}
+		return strings.Join(ids, ","), nil
+	case "app":
+		app := a.application(session.SessionID())
+		if op.Args["verbose"] == "true" {
+			return fmt.Sprintf("id:%s|name:%s|author:%s", app.ApplicationID(), app.Name(), app.Author()), nil
+		}
+		return app.ApplicationID(), nil
+	case "sessID":
+		return session.SessionID(), nil
+	}
+	if handler, ok := a.application(session.SessionID()).(OperationHandler); ok {
+		return handler.Query(op, session)
+	}
+	return "", ErrUnknownOperation
+}
+
+// Command executes the commands of the UDCP extensions for applications, other
+// commands are forwarded to the application of the session
+//
+//	c:app id:ID  selects the application of the session, like an Application PDU
+func (a *MultiplexingApplication) Command(op Operation, session Session) (string, error) {
+	if op.Name == "app" {
+		id, ok := op.Args["id"]
+		if !ok {
+			return "", fmt.Errorf("c:app requires the id argument")
+		}
+		return "", a.SelectApplication(session, id)
+	}
+	if handler, ok := a.application(session.SessionID()).(OperationHandler); ok {
+		return handler.Command(op, session)
+	}
+	return "", ErrUnknownOperation
 }
 
+// Shutdown calls the ShutdownHook of every available application that has one
+func (a *MultiplexingApplication) Shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, app := range a.availableApplications {
+		hook, ok := app.(ShutdownHook)
+		if !ok {
+			continue
+		}
+		if err := hook.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to shutdown %s: %v", app.ApplicationID(), err)
+		}
+	}
+	return firstErr
+}
+
+// ProcessUdcpRequest processes the request with the application in the session
+// in use by the application, see UseSession. Callers processing requests of
+// several sessions at once use ProcessSessionRequest instead
 func ProcessUdcpRequest(udcpReq UdcpRequest, application UdcpApplication) (UdcpResponse, error) {
+	return ProcessSessionRequest(udcpReq, application, application.GetOrCreateSession())
+}
+
+// ProcessSessionRequest processes the request with the application in the
+// session. The application is not asked for the session in use, so requests of
+// different sessions can be processed concurrently as long as the application
+// is safe for concurrent use. Requests of the same session must be processed
+// one at a time
+func ProcessSessionRequest(udcpReq UdcpRequest, application UdcpApplication, session Session) (UdcpResponse, error) {
 	/// Inorder to process a UssdRequest we must do the following things
 	/// 0. Parse the UssdRequest to a UdcpRequest
 	//// a. If the UdcpRequest is a DataPdu with MTS flag set return immediately otherwise continue
@@ -106,11 +329,28 @@ func ProcessUdcpRequest(udcpReq UdcpRequest, application UdcpApplication) (UdcpR
 	if udcpReq == nil {
 		return NewErrorResponse(ErrorCodeProtoErrorMask), ErrUnknownParse
 	}
-	// TODO: Get session ID from the UDCP Request
-	session := application.GetOrCreateSession()
 	if session == nil {
 		return NewErrorResponse(ErrorCodeProtoErrorMask), fmt.Errorf("session is nil or not configured")
 	}
+	// The application is starting, restarting or has been stopped
+	if state := application.CurrentState(session.SessionID()); state != ApplicationReady {
+		return NewErrorResponse(ErrorCodeAppNotReadyMask), nil
+	}
+	// The client selects the application which processes the data of the session
+	if udcpReq.Header().Type == ApplicationPduType {
+		selector, ok := application.(ApplicationSelector)
+		if !ok {
+			return NewErrorResponse(ErrorCodeProtoErrorMask), nil
+		}
+		if err := selector.SelectApplication(session, applicationID(udcpReq.Data())); err != nil {
+			return NewErrorResponseWithMessage(ErrorCodeAppNotReadyMask, err.Error()), nil
+		}
+		return NewReceiveReadyResponse(), nil
+	}
+	// The client queries or commands the server
+	if isOperationPdu(udcpReq.Header().Type) {
+		return processOperation(udcpReq, application, session)
+	}
 	// The UDCP provider has sent an error frame
 	if udcpReq.IsErrorPdu() {
 		fmt.Println("Received ErrorPdu. Initiating ReleaseDialogue")
@@ -162,3 +402,10 @@ func ProcessUdcpRequest(udcpReq UdcpRequest, application UdcpApplication) (UdcpR
 	// UDCP provider didn't specify the type of payload we're dealing with
 	return NewErrorResponse(ErrorCodeProtoErrorMask), nil
 }
+
+// applicationID the ID of the application in the data of an Application PDU,
+// either application=ID or the ID
+func applicationID(data []byte) string {
+	id := strings.TrimSpace(string(data))
+	return strings.TrimPrefix(id, "application=")
+}
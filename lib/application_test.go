@@ -1 +1,88 @@
 package ussdproxy_test
+
+import (
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+)
+
+// namedApp replies to data with its ID
+type namedApp struct {
+	id      string
+	session ussdproxy.Session
+}
+
+func (a *namedApp) ApplicationID() string { return a.id }
+func (a *namedApp) Name() string          { return a.id }
+func (a *namedApp) Author() string        { return "NNDI" }
+func (a *namedApp) Register()             {}
+
+func (a *namedApp) CurrentState(string) ussdproxy.ApplicationState {
+	return ussdproxy.ApplicationReady
+}
+
+func (a *namedApp) OnData(request ussdproxy.UdcpRequest, _ ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	return ussdproxy.NewDataResponse(request, []byte(a.id), false), nil
+}
+
+func (a *namedApp) OnReceiveReady(request ussdproxy.UdcpRequest, _ ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	return ussdproxy.NewDataResponse(request, []byte(a.id), false), nil
+}
+
+func (a *namedApp) OnError(ussdproxy.UdcpRequest, ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	return ussdproxy.NewProtocolErrorResponse(), nil
+}
+
+func (a *namedApp) OnReleaseDialogue(ussdproxy.UdcpRequest, ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	return ussdproxy.NewUserAbortReleaseDialogueResponse(), nil
+}
+
+func (a *namedApp) GetOrCreateSession() ussdproxy.Session { return a.session }
+func (a *namedApp) UseSession(s ussdproxy.Session)        { a.session = s }
+
+func TestSelectionIsSharedByDefaultApplicationViews(t *testing.T) {
+	mux := ussdproxy.NewMultiplexingApplication(&namedApp{id: "echo"}, &namedApp{id: "mqtt"}, &namedApp{id: "influx"})
+	sess := session.New("1234")
+
+	view := mux.WithDefaultApplication(1)
+	view.UseSession(sess)
+	if err := view.SelectApplication(sess, "influx"); err != nil {
+		t.Fatalf("SelectApplication: %v", err)
+	}
+
+	// every request builds its view again, e.g. routing by service code
+	next := mux.WithDefaultApplication(1)
+	next.UseSession(sess)
+	if got := next.GetOrCreateSession(); got == nil || got.SessionID() != "1234" {
+		t.Fatalf("expected session 1234 in use, got %v", got)
+	}
+	if id := applicationOf(t, next); id != "influx" {
+		t.Fatalf("expected the selected app influx on the next hop, got %s", id)
+	}
+
+	other := session.New("5678")
+	next.UseSession(other)
+	if id := applicationOf(t, next); id != "mqtt" {
+		t.Fatalf("expected the default app mqtt for another session, got %s", id)
+	}
+
+	next.UseSession(sess)
+	if _, err := next.OnReleaseDialogue(nil, sess); err != nil {
+		t.Fatalf("OnReleaseDialogue: %v", err)
+	}
+	mux.UseSession(sess)
+	if id := applicationOf(t, mux); id != "echo" {
+		t.Fatalf("expected the selection to be cleared on release, got %s", id)
+	}
+}
+
+// applicationOf the ID the application in use replies with
+func applicationOf(t *testing.T, app ussdproxy.UdcpApplication) string {
+	t.Helper()
+	response, err := app.OnData(nil, app.GetOrCreateSession())
+	if err != nil {
+		t.Fatalf("OnData: %v", err)
+	}
+	return string(response.Data())
+}
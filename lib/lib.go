@@ -23,13 +23,21 @@ type UdcpData interface {
 // UdcpRequest represents a request from a USSD interaction (from the client)
 type UdcpRequest interface {
 	UdcpData
-	//UssdRequest() UssdRequestInterface
+	// UssdRequest the request from the Network the UdcpRequest was parsed from, nil
+	// if the UdcpRequest was not received from the Network
+	UssdRequest() UssdRequestInterface
 }
 
 // UdcpResponse is the server's response for a particular UdcpRequest
 type UdcpResponse interface {
 	UdcpData
 	Request() UdcpRequest
+	// Disposition whether the session continues or ends after the response, or
+	// is handed over to the RedirectTarget
+	Disposition() Disposition
+	// RedirectTarget the service the session is handed over to e.g. a service
+	// code, when the disposition is DispositionRedirect
+	RedirectTarget() string
 	SetHeader(header *UdcpHeader) error
 	SetData(data []byte) error
 	Write(w io.Writer) error
@@ -0,0 +1,115 @@
+package ussdproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownOperation is returned by an OperationHandler for queries and
+// commands it does not support
+var ErrUnknownOperation = errors.New("unknown operation")
+
+// Operation is a query or command sent by a client in a Query or Command PDU,
+// the name followed by space separated key:value arguments e.g. c:app id:echo
+type Operation struct {
+	Query bool // a query (q:) rather than a command (c:)
+	Name  string
+	Args  map[string]string
+}
+
+func (op Operation) String() string {
+	if op.Query {
+		return "q:" + op.Name
+	}
+	return "c:" + op.Name
+}
+
+// OperationHandler is implemented by applications which execute the queries and
+// commands of clients. The result of a query is sent to the client in a Data
+// PDU, a command without a result is answered with a Receive Ready PDU. Errors
+// are sent to the client in a protocol Error PDU. Results must fit in a single
+// PDU of MaxDataLength
+type OperationHandler interface {
+	Query(op Operation, session Session) (string, error)
+	Command(op Operation, session Session) (string, error)
+}
+
+// ParseOperation parses the data of a Query or Command PDU e.g. q:app verbose:true
+func ParseOperation(data []byte) (Operation, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return Operation{}, fmt.Errorf("empty operation")
+	}
+	var op Operation
+	switch {
+	case strings.HasPrefix(fields[0], "q:"):
+		op.Query = true
+	case strings.HasPrefix(fields[0], "c:"):
+	default:
+		return Operation{}, fmt.Errorf("operation '%s' must start with q: or c:", fields[0])
+	}
+	op.Name = fields[0][2:]
+	if op.Name == "" {
+		return Operation{}, fmt.Errorf("operation '%s' has no name", fields[0])
+	}
+	op.Args = make(map[string]string, len(fields)-1)
+	for _, arg := range fields[1:] {
+		i := strings.Index(arg, ":")
+		if i < 1 {
+			return Operation{}, fmt.Errorf("argument '%s' of %s must be key:value", arg, op)
+		}
+		op.Args[arg[:i]] = arg[i+1:]
+	}
+	return op, nil
+}
+
+func isOperationPdu(typ PduType) bool {
+	return typ == QueryPduType || typ == QueryPduWithMtsType || typ == CommandPduType || typ == CommandPduWithMtsType
+}
+
+// processOperation executes the query or command with the application, the
+// arguments of operations with more to send are buffered until the last PDU
+func processOperation(udcpReq UdcpRequest, application UdcpApplication, session Session) (UdcpResponse, error) {
+	handler, ok := application.(OperationHandler)
+	if !ok {
+		return NewErrorResponse(ErrorCodeProtoErrorMask), nil
+	}
+	session.RecvBuffer().Write(udcpReq.Data())
+	if udcpReq.HasMoreToSend() {
+		return NewReceiveReadyResponse(), nil
+	}
+	data, err := session.RecvBuffer().Read()
+	session.RecvBuffer().Purge()
+	if err != nil {
+		return nil, err
+	}
+	op, err := ParseOperation(data)
+	if err != nil {
+		return NewErrorResponseWithMessage(ErrorCodeProtoErrorMask, err.Error()), nil
+	}
+	typ := udcpReq.Header().Type
+	query := typ == QueryPduType || typ == QueryPduWithMtsType
+	if op.Query != query {
+		return NewErrorResponseWithMessage(ErrorCodeProtoErrorMask, fmt.Sprintf("%s sent in a %s PDU", op, typ.String())), nil
+	}
+	var result string
+	if op.Query {
+		result, err = handler.Query(op, session)
+	} else {
+		result, err = handler.Command(op, session)
+	}
+	if errors.Is(err, ErrUnknownOperation) {
+		return NewErrorResponseWithMessage(ErrorCodeProtoErrorMask, fmt.Sprintf("unknown operation %s", op)), nil
+	}
+	if err != nil {
+		return NewErrorResponseWithMessage(ErrorCodeProtoErrorMask, err.Error()), nil
+	}
+	if len(result) > MaxDataLength {
+		return NewErrorResponseWithMessage(ErrorCodeUnknownMask, fmt.Sprintf("the result of %s is too long", op)), nil
+	}
+	if result == "" && !op.Query {
+		return NewReceiveReadyResponse(), nil
+	}
+	return NewDataResponse(udcpReq, []byte(result), false), nil
+}
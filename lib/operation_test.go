@@ -0,0 +1,93 @@
+package ussdproxy_test
+
+import (
+	"reflect"
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+)
+
+func TestParseOperation(t *testing.T) {
+	testCases := map[string]struct {
+		data     string
+		expected ussdproxy.Operation
+		err      bool
+	}{
+		"query":         {"q:apps", ussdproxy.Operation{Query: true, Name: "apps", Args: map[string]string{}}, false},
+		"command":       {"c:app id:echo", ussdproxy.Operation{Name: "app", Args: map[string]string{"id": "echo"}}, false},
+		"arguments":     {"c:sessCache  ttl:60 id:a:b", ussdproxy.Operation{Name: "sessCache", Args: map[string]string{"ttl": "60", "id": "a:b"}}, false},
+		"empty":         {"", ussdproxy.Operation{}, true},
+		"no prefix":     {"apps", ussdproxy.Operation{}, true},
+		"no name":       {"q:", ussdproxy.Operation{}, true},
+		"bad argument":  {"c:app echo", ussdproxy.Operation{}, true},
+		"empty key":     {"c:app :echo", ussdproxy.Operation{}, true},
+		"unknown class": {"x:app", ussdproxy.Operation{}, true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			op, err := ussdproxy.ParseOperation([]byte(tc.data))
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", op)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(op, tc.expected) {
+				t.Fatalf("expected %+v, got %+v", tc.expected, op)
+			}
+		})
+	}
+}
+
+func TestMultiplexingApplicationOperations(t *testing.T) {
+	mux := ussdproxy.NewMultiplexingApplication(&namedApp{id: "echo"}, &namedApp{id: "influx"})
+	sess := session.New("1234")
+	steps := []struct {
+		request  ussdproxy.UdcpRequest
+		expected string
+	}{
+		{ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("q:apps")), "D;echo,influx"},
+		{ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("q:sessID")), "D;1234"},
+		{ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("q:app")), "D;echo"},
+		{ussdproxy.NewRequest(ussdproxy.CommandPduType, []byte("c:app id:influx")), "R;__NODATA__"},
+		{ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("q:app verbose:true")), "D;id:influx|name:influx|author:NNDI"},
+		// arguments with more to send are buffered until the last PDU
+		{ussdproxy.NewRequest(ussdproxy.CommandPduWithMtsType, []byte("c:app ")), "R;__NODATA__"},
+		{ussdproxy.NewRequest(ussdproxy.CommandPduType, []byte("id:echo")), "R;__NODATA__"},
+		{ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("q:app")), "D;echo"},
+		{ussdproxy.NewRequest(ussdproxy.CommandPduType, []byte("c:app id:mqtt")), "E;67 application 'mqtt' is not available"},
+		{ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("q:rrSent")), "E;67 unknown operation q:rrSent"},
+		{ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("c:app id:echo")), "E;67 c:app sent in a Q; PDU"},
+	}
+	for _, step := range steps {
+		response, err := ussdproxy.ProcessSessionRequest(step.request, mux, sess)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.request.ToString(), err)
+		}
+		if got := string(ussdproxy.Encode(response)); got != step.expected {
+			t.Fatalf("%s: expected %s, got %s", step.request.ToString(), step.expected, got)
+		}
+	}
+
+	// applications which do not handle operations reject them
+	response, _ := ussdproxy.ProcessSessionRequest(ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte("q:apps")), &namedApp{id: "echo"}, sess)
+	if got := string(ussdproxy.Encode(response)); got != "E;67 protocol error" {
+		t.Fatalf("expected a protocol error, got %s", got)
+	}
+}
+
+func TestClearSelection(t *testing.T) {
+	mux := ussdproxy.NewMultiplexingApplication(&namedApp{id: "echo"}, &namedApp{id: "influx"})
+	sess := session.New("1234")
+	if err := mux.SelectApplication(sess, "influx"); err != nil {
+		t.Fatalf("SelectApplication: %v", err)
+	}
+	mux.ClearSelection("1234")
+	if result, _ := mux.Query(ussdproxy.Operation{Query: true, Name: "app"}, sess); result != "echo" {
+		t.Fatalf("expected the default app after clearing the selection, got %s", result)
+	}
+}
@@ -50,23 +50,87 @@ const (
 	UdcpProtocolPduAscii   PduTypeAscii = "U;"
 	ReleaseDialogPduAscii  PduTypeAscii = "X;"
 
-	ErrorCodeUnknownMask    = 0x66
-	ErrorCodeProtoErrorMask = 0x67
-	ErrorCodeVersionMask    = 0x68
-	ErrorCodeExtAddrMask    = 0x69
-
-	ReleaseCodeUnknownMask     = 0x77
-	ReleaseCodeUssdTimeoutMask = 0x76
-	ReleaseCodeIdleDialogMask  = 0x75
-	ReleaseCodeUserAbortMask   = 0x74
-
 	NoDataResponse    = "__NODATA__"
 	NoDataResponseLen = len("__NODATA__")
 )
 
+// ErrorCode is the code of the error of an Error PDU
+type ErrorCode uint8
+
+const (
+	ErrorCodeUnknownMask     ErrorCode = 0x66
+	ErrorCodeProtoErrorMask  ErrorCode = 0x67
+	ErrorCodeVersionMask     ErrorCode = 0x68
+	ErrorCodeExtAddrMask     ErrorCode = 0x69
+	ErrorCodeAppNotReadyMask ErrorCode = 0x6a
+)
+
+// String the message of the error code, sent with Error PDUs
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorCodeProtoErrorMask:
+		return "protocol error"
+	case ErrorCodeVersionMask:
+		return "version not supported"
+	case ErrorCodeExtAddrMask:
+		return "invalid extended address"
+	case ErrorCodeAppNotReadyMask:
+		return "application not ready"
+	default:
+		return "unknown error"
+	}
+}
+
+// ReleaseReason is the reason a dialogue was released, carried by ReleaseDialogue PDUs
+type ReleaseReason uint8
+
+const (
+	ReleaseCodeUnknownMask     ReleaseReason = 0x77
+	ReleaseCodeUssdTimeoutMask ReleaseReason = 0x76
+	ReleaseCodeIdleDialogMask  ReleaseReason = 0x75
+	ReleaseCodeUserAbortMask   ReleaseReason = 0x74
+)
+
+func (r ReleaseReason) String() string {
+	switch r {
+	case ReleaseCodeUserAbortMask:
+		return "user abort"
+	case ReleaseCodeIdleDialogMask:
+		return "idle dialogue"
+	case ReleaseCodeUssdTimeoutMask:
+		return "USSD timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// Disposition is what happens to the session after a response is sent to the subscriber
+type Disposition uint8
+
+const (
+	// DispositionContinue the subscriber is prompted for input and the session continues
+	DispositionContinue Disposition = iota + 1
+	// DispositionEnd the response is the last message of the session
+	DispositionEnd
+	// DispositionRedirect the session is handed over to another service, see UdcpResponse.RedirectTarget
+	DispositionRedirect
+)
+
+func (d Disposition) String() string {
+	switch d {
+	case DispositionContinue:
+		return "continue"
+	case DispositionEnd:
+		return "end"
+	case DispositionRedirect:
+		return "redirect"
+	default:
+		return "unknown"
+	}
+}
+
 func (p PduType) HasMoreToSend() bool {
 	if p == CommandPduWithMtsType ||
-		p == DataLongPduType ||
 		p == DataPduWithMtsType ||
 		p == QueryPduWithMtsType {
 		return true
@@ -76,7 +140,6 @@ func (p PduType) HasMoreToSend() bool {
 
 func (p PduTypeAscii) HasMoreToSend() bool {
 	if p == CommandPduWithMtsAscii ||
-		p == DataLongPduAscii ||
 		p == DataPduWithMtsAscii ||
 		p == QueryPduWithMtsAscii {
 		return true
@@ -136,9 +199,8 @@ func (p PduType) String() PduTypeAscii {
 		return QueryPduWithMtsAscii
 	case UdcpProtocolPduType:
 		return UdcpProtocolPduAscii
-	case ErrorPduType:
 	default:
+		// ErrorPduType, ErrorNotAsciiPduType and unknown types are errors
 		return ErrorPduAscii
 	}
-	return ErrorPduAscii
 }
@@ -1,6 +1,7 @@
 package ussdproxy
 
 import (
+	"fmt"
 	"io"
 	"unicode"
 	"unicode/utf8"
@@ -11,20 +12,26 @@ type UdcpHeader struct {
 	Type       PduType
 	Version    uint8
 	MoreToSend bool
+	// Disposition of a response, derived from the type when it is not set:
+	// ReleaseDialogue and Error PDUs end the session, others continue it
+	Disposition   Disposition
+	ReleaseReason ReleaseReason // Reason of a ReleaseDialogue PDU
+	ErrorCode     ErrorCode     // Code of an Error PDU
 }
 
 type udcpRequest struct {
-	// ussdRequest *UssdRequest
-	header *UdcpHeader
-	len    int
-	data   []byte
+	ussdRequest UssdRequestInterface
+	header      *UdcpHeader
+	len         int
+	data        []byte
 }
 
 type udcpResponse struct {
-	header  *UdcpHeader
-	request UdcpRequest
-	len     int
-	data    []byte
+	header         *UdcpHeader
+	request        UdcpRequest
+	len            int
+	data           []byte
+	redirectTarget string
 }
 
 func isASCII(s []byte) bool {
@@ -48,6 +55,10 @@ func (req *udcpRequest) Header() *UdcpHeader {
 	return req.header
 }
 
+func (req *udcpRequest) UssdRequest() UssdRequestInterface {
+	return req.ussdRequest
+}
+
 func (req *udcpRequest) Data() []byte {
 	return req.data
 }
@@ -65,11 +76,7 @@ func (req *udcpRequest) IsReceiveReadyPdu() bool {
 }
 
 func (req *udcpRequest) IsReleaseDialoguePdu() bool {
-	typ := req.Header().Type
-	return typ == ReleaseCodeUnknownMask ||
-		typ == ReleaseCodeUserAbortMask ||
-		typ == ReleaseCodeUssdTimeoutMask ||
-		typ == ReleaseCodeIdleDialogMask
+	return req.Header().Type == ReleaseDialogPduType
 }
 
 func (req *udcpRequest) IsErrorPdu() bool {
@@ -84,22 +91,14 @@ func (req *udcpRequest) String() string {
 	return req.ToString()
 }
 
+// ToString the request in the wire format, see Encode
 func (req *udcpRequest) ToString() string {
-	data := make([]byte, 0)
-	data = append(data, []byte(req.header.Type.String())...)
-	data = append(data, byte(len(req.data)))
-	data = append(data, 0x00)
-	data = append(data, req.data...)
-	return string(data)
+	return string(Encode(req))
 }
 
+// ToString the response in the wire format, see Encode
 func (res *udcpResponse) ToString() string {
-	data := make([]byte, 0)
-	data = append(data, []byte(res.header.Type.String())...)
-	data = append(data, byte(len(res.data)))
-	data = append(data, 0x00)
-	data = append(data, res.data...)
-	return string(data)
+	return string(Encode(res))
 }
 
 // NewUdcpResponse returns a UdcpResponse
@@ -134,6 +133,44 @@ func NewDataResponse(request UdcpRequest, data []byte, moreToSend bool) UdcpResp
 	}
 }
 
+// NewRequest returns a UdcpRequest of the given type
+func NewRequest(typ PduType, data []byte) UdcpRequest {
+	return &udcpRequest{
+		header: &UdcpHeader{
+			Type:       typ,
+			Version:    ProtocolVersion,
+			MoreToSend: typ.HasMoreToSend(),
+		},
+		data: data,
+		len:  len(data),
+	}
+}
+
+// ParseUssdRequest parses the data of a request from the Network into a UdcpRequest.
+// The data must start with the ASCII representation of the PDU type e.g. "D;".
+// An initial request without a PDU, e.g. the service code that was dialled, is a ReceiveReady
+func ParseUssdRequest(ussdRequest UssdRequestInterface) (UdcpRequest, error) {
+	ussdData := ussdRequest.Data()
+	if ussdRequest.Initial() && (len(ussdData) < 2 || RequestPduType(string(ussdData[0:2])) == InvalidPduType) {
+		request := NewReceiveReadyRequest().(*udcpRequest)
+		request.ussdRequest = ussdRequest
+		return request, nil
+	}
+	header, data, err := Decode(ussdData)
+	if err != nil {
+		return nil, err
+	}
+	if !isASCII(data) {
+		return nil, fmt.Errorf("request data must be ASCII")
+	}
+	return &udcpRequest{
+		ussdRequest: ussdRequest,
+		header:      header,
+		data:        data,
+		len:         len(data),
+	}, nil
+}
+
 // NewReceiveReadyRequest returns a UdcpRequest with a ReceiveReady type
 func NewReceiveReadyRequest() UdcpRequest {
 	return &udcpRequest{
@@ -150,7 +187,7 @@ func NewReceiveReadyRequest() UdcpRequest {
 // NewDataRequest returns a UdcpRequest
 func NewDataRequest(data []byte, moreToSend bool) UdcpRequest {
 	if !isASCII(data) {
-		return NewErrorResponse(ErrorNotAsciiPduType)
+		return NewRequest(ErrorNotAsciiPduType, nil)
 	}
 
 	typ := DataLongPduType
@@ -186,13 +223,15 @@ func NewUserAbortReleaseDialogueResponse() UdcpResponse {
 	return NewReleaseDialogueResponse(ReleaseCodeUserAbortMask)
 }
 
-// NewReleaseDialogueResponse returns a UdcpResponse with a ReceiveReady type
-func NewReleaseDialogueResponse(reason PduType) UdcpResponse {
+// NewReleaseDialogueResponse returns a UdcpResponse which releases the dialogue for the reason
+func NewReleaseDialogueResponse(reason ReleaseReason) UdcpResponse {
 	return &udcpResponse{
 		header: &UdcpHeader{
-			Type:       reason,
-			Version:    ProtocolVersion,
-			MoreToSend: false,
+			Type:          ReleaseDialogPduType,
+			Version:       ProtocolVersion,
+			MoreToSend:    false,
+			Disposition:   DispositionEnd,
+			ReleaseReason: reason,
 		},
 		request: nil,
 		data:    []byte(NoDataResponse),
@@ -204,18 +243,42 @@ func NewProtocolErrorResponse() UdcpResponse {
 	return NewErrorResponse(ErrorCodeProtoErrorMask)
 }
 
-// NewErrorResponse returns an error response
-func NewErrorResponse(errorCode PduType) UdcpResponse {
-	// TODO: Where to put error code?
+// NewErrorResponse returns an Error response with the code and its message
+func NewErrorResponse(errorCode ErrorCode) UdcpResponse {
+	return NewErrorResponseWithMessage(errorCode, errorCode.String())
+}
+
+// NewErrorResponseWithMessage returns an Error response with the code and message
+func NewErrorResponseWithMessage(errorCode ErrorCode, message string) UdcpResponse {
 	return &udcpResponse{
 		header: &UdcpHeader{
-			Type:       errorCode,
-			Version:    ProtocolVersion,
-			MoreToSend: false,
+			Type:        ErrorPduType,
+			Version:     ProtocolVersion,
+			MoreToSend:  false,
+			Disposition: DispositionEnd,
+			ErrorCode:   errorCode,
 		},
 		request: nil,
-		data:    []byte("Unknown Error"),
-		len:     0,
+		data:    []byte(message),
+		len:     len(message),
+	}
+}
+
+// NewRedirectResponse returns a UdcpResponse with the data which hands the
+// session over to the target service, e.g. a service code. Providers which
+// cannot redirect sessions end the session with the data
+func NewRedirectResponse(request UdcpRequest, target string, data []byte) UdcpResponse {
+	return &udcpResponse{
+		header: &UdcpHeader{
+			Type:        DataLongPduType,
+			Version:     ProtocolVersion,
+			MoreToSend:  false,
+			Disposition: DispositionRedirect,
+		},
+		request:        request,
+		data:           data,
+		len:            len(data),
+		redirectTarget: target,
 	}
 }
 
@@ -261,17 +324,27 @@ func (res *udcpResponse) IsReceiveReadyPdu() bool {
 	return res.Header().Type == ReceiveReadyPduType
 }
 func (res *udcpResponse) IsReleaseDialoguePdu() bool {
-	typ := res.Header().Type
-	return typ == ReleaseCodeUnknownMask ||
-		typ == ReleaseCodeUserAbortMask ||
-		typ == ReleaseCodeUssdTimeoutMask ||
-		typ == ReleaseCodeIdleDialogMask
+	return res.Header().Type == ReleaseDialogPduType
 }
 
 func (res *udcpResponse) IsErrorPdu() bool {
 	return res.Header().Type == ErrorPduType
 }
 
+func (res *udcpResponse) Disposition() Disposition {
+	if res.header.Disposition != 0 {
+		return res.header.Disposition
+	}
+	if res.IsReleaseDialoguePdu() || res.IsErrorPdu() {
+		return DispositionEnd
+	}
+	return DispositionContinue
+}
+
+func (res *udcpResponse) RedirectTarget() string {
+	return res.redirectTarget
+}
+
 func (res *udcpResponse) Version() uint8 {
 	return ProtocolVersion
 }
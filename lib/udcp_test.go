@@ -0,0 +1,35 @@
+package ussdproxy_test
+
+import (
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+)
+
+func TestResponseDisposition(t *testing.T) {
+	request := ussdproxy.NewReceiveReadyRequest()
+	testCases := map[string]struct {
+		response    ussdproxy.UdcpResponse
+		disposition ussdproxy.Disposition
+	}{
+		"data":            {ussdproxy.NewDataResponse(request, []byte("hello"), false), ussdproxy.DispositionContinue},
+		"receive ready":   {ussdproxy.NewReceiveReadyResponse(), ussdproxy.DispositionContinue},
+		"release":         {ussdproxy.NewUserAbortReleaseDialogueResponse(), ussdproxy.DispositionEnd},
+		"error":           {ussdproxy.NewProtocolErrorResponse(), ussdproxy.DispositionEnd},
+		"redirect":        {ussdproxy.NewRedirectResponse(request, "*123#", []byte("bye")), ussdproxy.DispositionRedirect},
+		"release by type": {ussdproxy.NewUdcpResponse(request, uint8(ussdproxy.ReleaseDialogPduType), false, nil), ussdproxy.DispositionEnd},
+	}
+	for name, tc := range testCases {
+		if got := tc.response.Disposition(); got != tc.disposition {
+			t.Errorf("%s: expected disposition %s, got %s", name, tc.disposition, got)
+		}
+	}
+
+	release := ussdproxy.NewReleaseDialogueResponse(ussdproxy.ReleaseCodeUssdTimeoutMask)
+	if !release.IsReleaseDialoguePdu() {
+		t.Error("expected a ReleaseDialogue PDU")
+	}
+	if release.Header().ReleaseReason != ussdproxy.ReleaseCodeUssdTimeoutMask {
+		t.Errorf("expected the USSD timeout release reason, got %x", release.Header().ReleaseReason)
+	}
+}
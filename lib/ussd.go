@@ -1,5 +1,9 @@
 package ussdproxy
 
+// MetadataApp is the Metadata key of the name of the app in udcp.apps a provider
+// routed the request to, e.g. by the service code that was dialled
+const MetadataApp = "app"
+
 // UssdRequestInterface struct represents a request coming in from the Network
 type UssdRequestInterface interface {
 	PhoneNumber() string
@@ -8,4 +12,77 @@ type UssdRequestInterface interface {
 	SessionID() string
 	Channel() string
 	Provider() string
+	// Initial whether the request starts a dialogue, i.e. the subscriber dialled the service code
+	Initial() bool
+	// Metadata provider specific details of the request e.g. the network code,
+	// providers add to the map before parsing the request
+	Metadata() map[string]string
+}
+
+type ussdRequest struct {
+	sessionID   string
+	phoneNumber string
+	channel     string
+	provider    string
+	data        []byte
+	initial     bool
+	metadata    map[string]string
+}
+
+// NewUssdRequest returns the UssdRequest a provider received from the Network
+func NewUssdRequest(provider, sessionID, phoneNumber, channel string, data []byte) UssdRequestInterface {
+	return &ussdRequest{
+		sessionID:   sessionID,
+		phoneNumber: phoneNumber,
+		channel:     channel,
+		provider:    provider,
+		data:        data,
+		metadata:    make(map[string]string),
+	}
+}
+
+// NewInitialUssdRequest returns the UssdRequest a provider received from the
+// Network when the subscriber dialled the service code, which starts a new dialogue
+func NewInitialUssdRequest(provider, sessionID, phoneNumber, channel string, data []byte) UssdRequestInterface {
+	return &ussdRequest{
+		sessionID:   sessionID,
+		phoneNumber: phoneNumber,
+		channel:     channel,
+		provider:    provider,
+		data:        data,
+		initial:     true,
+		metadata:    make(map[string]string),
+	}
+}
+
+func (u *ussdRequest) PhoneNumber() string {
+	return u.phoneNumber
+}
+
+func (u *ussdRequest) Data() []byte {
+	return u.data
+}
+
+func (u *ussdRequest) RawText() string {
+	return string(u.data)
+}
+
+func (u *ussdRequest) SessionID() string {
+	return u.sessionID
+}
+
+func (u *ussdRequest) Channel() string {
+	return u.channel
+}
+
+func (u *ussdRequest) Provider() string {
+	return u.provider
+}
+
+func (u *ussdRequest) Initial() bool {
+	return u.initial
+}
+
+func (u *ussdRequest) Metadata() map[string]string {
+	return u.metadata
 }
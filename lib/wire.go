@@ -0,0 +1,138 @@
+package ussdproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The wire format is the text of a PDU in a USSD message, the PDU type
+// followed by its payload
+//
+//	R;__NODATA__               ReceiveReady, the sender waits for the next PDU
+//	D;<data>                   Data, the last or only chunk of the data
+//	d;<data>                   Data with more to send, the receiver answers R; for the next chunk
+//	A;<data>                   Application selection e.g. A;application=echo
+//	C;<data> and c;<data>      Command, c; when the command has more to send
+//	Q;<data> and q;<data>      Query, q; when the query has more to send
+//	X;[r:<reason> ][<data>]    ReleaseDialogue, the reason is two hex digits and
+//	                           omitted for a release by the user (74), unless
+//	                           the data starts with r:
+//	E;<code>[ <message>]       Error, the code is two hex digits e.g. E;67 protocol error
+//
+// e.g. X;r:76 releases the dialogue because the USSD session timed out and
+// X;sessionID=1234 releases it by the user with data for the next dialogue.
+// See docs/design/index.md for the release reasons and error codes
+
+// releaseReasonPrefix prefixes the release reason in the payload of a ReleaseDialogue PDU
+const releaseReasonPrefix = "r:"
+
+// Encode encodes the PDU in the wire format, every provider writes responses
+// with Encode so clients see the same PDUs whichever provider they dial through
+func Encode(pdu UdcpData) []byte {
+	header := pdu.Header()
+	data := string(pdu.Data())
+	var b strings.Builder
+	switch header.Type {
+	case ReceiveReadyPduType:
+		b.WriteString(string(ReceiveReadyPduAscii) + NoDataResponse)
+	case DataLongPduType, DataPduWithMtsType:
+		b.WriteString(string(withMoreToSend(DataLongPduAscii, DataPduWithMtsAscii, header.MoreToSend)) + data)
+	case CommandPduType, CommandPduWithMtsType:
+		b.WriteString(string(withMoreToSend(CommandPduAscii, CommandPduWithMtsAscii, header.MoreToSend)) + data)
+	case QueryPduType, QueryPduWithMtsType:
+		b.WriteString(string(withMoreToSend(QueryPduAscii, QueryPduWithMtsAscii, header.MoreToSend)) + data)
+	case ReleaseDialogPduType:
+		b.WriteString(string(ReleaseDialogPduAscii))
+		payload := make([]string, 0, 2)
+		reason := header.ReleaseReason
+		if reason == 0 {
+			reason = ReleaseCodeUserAbortMask
+		}
+		if data == NoDataResponse {
+			data = ""
+		}
+		// data starting with the prefix would be decoded as the reason, so the
+		// reason is written even for a release by the user
+		if reason != ReleaseCodeUserAbortMask || strings.HasPrefix(data, releaseReasonPrefix) {
+			payload = append(payload, fmt.Sprintf("%s%02x", releaseReasonPrefix, uint8(reason)))
+		}
+		if data != "" {
+			payload = append(payload, data)
+		}
+		b.WriteString(strings.Join(payload, " "))
+	case ErrorPduType, ErrorNotAsciiPduType:
+		code := header.ErrorCode
+		if code == 0 {
+			code = ErrorCodeUnknownMask
+		}
+		fmt.Fprintf(&b, "%s%02x", ErrorPduAscii, uint8(code))
+		if data != "" {
+			b.WriteString(" " + data)
+		}
+	default:
+		b.WriteString(string(header.Type.String()) + data)
+	}
+	return []byte(b.String())
+}
+
+func withMoreToSend(last, more PduTypeAscii, moreToSend bool) PduTypeAscii {
+	if moreToSend {
+		return more
+	}
+	return last
+}
+
+// Decode decodes a PDU in the wire format into its header and data, the
+// release reason and error code are moved from the data to the header
+func Decode(text []byte) (*UdcpHeader, []byte, error) {
+	if len(text) < 2 {
+		return nil, nil, fmt.Errorf("%v got '%s'", ErrInvalidHeader, text)
+	}
+	typ := RequestPduType(string(text[0:2]))
+	if typ == InvalidPduType {
+		return nil, nil, fmt.Errorf("%v got '%s'", ErrInvalidHeader, text[0:2])
+	}
+	header := &UdcpHeader{
+		Type:       typ,
+		Version:    ProtocolVersion,
+		MoreToSend: typ.HasMoreToSend(),
+	}
+	data := text[2:]
+	switch typ {
+	case ReceiveReadyPduType:
+		if string(data) == NoDataResponse {
+			data = data[:0]
+		}
+	case ReleaseDialogPduType:
+		header.ReleaseReason = ReleaseCodeUserAbortMask
+		if reason, rest, ok := decodeCode(data, releaseReasonPrefix); ok {
+			header.ReleaseReason, data = ReleaseReason(reason), rest
+		}
+		header.Disposition = DispositionEnd
+	case ErrorPduType:
+		header.ErrorCode = ErrorCodeUnknownMask
+		if code, rest, ok := decodeCode(data, ""); ok {
+			header.ErrorCode, data = ErrorCode(code), rest
+		}
+		header.Disposition = DispositionEnd
+	}
+	return header, data, nil
+}
+
+// decodeCode decodes the two hex digit code after the prefix at the start of
+// the payload, the rest of the payload follows a space
+func decodeCode(data []byte, prefix string) (uint8, []byte, bool) {
+	n := len(prefix) + 2
+	if len(data) < n || string(data[:len(prefix)]) != prefix || (len(data) > n && data[n] != ' ') {
+		return 0, nil, false
+	}
+	code, err := strconv.ParseUint(string(data[len(prefix):n]), 16, 8)
+	if err != nil {
+		return 0, nil, false
+	}
+	if len(data) > n {
+		return uint8(code), data[n+1:], true
+	}
+	return uint8(code), data[n:], true
+}
@@ -0,0 +1,98 @@
+package ussdproxy_test
+
+import (
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+)
+
+func TestEncode(t *testing.T) {
+	request := ussdproxy.NewReceiveReadyRequest()
+	testCases := map[string]struct {
+		pdu      ussdproxy.UdcpData
+		expected string
+	}{
+		"receive ready":         {ussdproxy.NewReceiveReadyResponse(), "R;__NODATA__"},
+		"receive ready request": {ussdproxy.NewReceiveReadyRequest(), "R;__NODATA__"},
+		"data":                  {ussdproxy.NewDataResponse(request, []byte("Hello World"), false), "D;Hello World"},
+		"data more to send":     {ussdproxy.NewDataResponse(request, []byte("Hello"), true), "d;Hello"},
+		"data request":          {ussdproxy.NewDataRequest([]byte("Hello"), true), "d;Hello"},
+		"release by user":       {ussdproxy.NewUserAbortReleaseDialogueResponse(), "X;"},
+		"release with reason":   {ussdproxy.NewReleaseDialogueResponse(ussdproxy.ReleaseCodeUssdTimeoutMask), "X;r:76"},
+		"error":                 {ussdproxy.NewProtocolErrorResponse(), "E;67 protocol error"},
+		"error with message":    {ussdproxy.NewErrorResponseWithMessage(ussdproxy.ErrorCodeAppNotReadyMask, "influx is restarting"), "E;6a influx is restarting"},
+		"query":                 {ussdproxy.NewRequest(ussdproxy.QueryPduWithMtsType, []byte("q:apps")), "q;q:apps"},
+	}
+	for name, tc := range testCases {
+		if got := string(ussdproxy.Encode(tc.pdu)); got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", name, tc.expected, got)
+		}
+	}
+}
+
+// TestReleaseRoundTrip the data of a release is decoded as it was encoded, even
+// if it starts with the prefix of the release reason
+func TestReleaseRoundTrip(t *testing.T) {
+	testCases := []struct {
+		reason ussdproxy.ReleaseReason
+		data   string
+		text   string
+	}{
+		{reason: ussdproxy.ReleaseCodeUserAbortMask, data: "sessionID=1234", text: "X;sessionID=1234"},
+		{reason: ussdproxy.ReleaseCodeUserAbortMask, data: "r:ok bye", text: "X;r:74 r:ok bye"},
+		{reason: ussdproxy.ReleaseCodeUserAbortMask, data: "r:76", text: "X;r:74 r:76"},
+		{reason: ussdproxy.ReleaseCodeUserAbortMask, data: "r:", text: "X;r:74 r:"},
+		{reason: ussdproxy.ReleaseCodeUssdTimeoutMask, data: "r:ok bye", text: "X;r:76 r:ok bye"},
+		{reason: ussdproxy.ReleaseCodeUssdTimeoutMask, text: "X;r:76"},
+	}
+	for _, tc := range testCases {
+		pdu := ussdproxy.NewRequest(ussdproxy.ReleaseDialogPduType, []byte(tc.data))
+		pdu.Header().ReleaseReason = tc.reason
+		text := ussdproxy.Encode(pdu)
+		if string(text) != tc.text {
+			t.Errorf("%q: expected %q, got %q", tc.data, tc.text, text)
+		}
+		header, data, err := ussdproxy.Decode(text)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", text, err)
+		}
+		if header.ReleaseReason != tc.reason || string(data) != tc.data {
+			t.Errorf("%s: expected release reason %x with %q, got %x with %q", text, tc.reason, tc.data, header.ReleaseReason, data)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		text          string
+		pduType       ussdproxy.PduType
+		moreToSend    bool
+		data          string
+		releaseReason ussdproxy.ReleaseReason
+		errorCode     ussdproxy.ErrorCode
+	}{
+		{text: "R;__NODATA__", pduType: ussdproxy.ReceiveReadyPduType},
+		{text: "D;Hello World", pduType: ussdproxy.DataLongPduType, data: "Hello World"},
+		{text: "d;Hello", pduType: ussdproxy.DataPduWithMtsType, moreToSend: true, data: "Hello"},
+		{text: "X;", pduType: ussdproxy.ReleaseDialogPduType, releaseReason: ussdproxy.ReleaseCodeUserAbortMask},
+		{text: "X;r:76", pduType: ussdproxy.ReleaseDialogPduType, releaseReason: ussdproxy.ReleaseCodeUssdTimeoutMask},
+		{text: "X;sessionID=1234", pduType: ussdproxy.ReleaseDialogPduType, releaseReason: ussdproxy.ReleaseCodeUserAbortMask, data: "sessionID=1234"},
+		{text: "E;6a influx is restarting", pduType: ussdproxy.ErrorPduType, errorCode: ussdproxy.ErrorCodeAppNotReadyMask, data: "influx is restarting"},
+	}
+	for _, tc := range testCases {
+		header, data, err := ussdproxy.Decode([]byte(tc.text))
+		if err != nil {
+			t.Errorf("%s: failed to decode: %v", tc.text, err)
+			continue
+		}
+		if header.Type != tc.pduType || header.MoreToSend != tc.moreToSend || string(data) != tc.data {
+			t.Errorf("%s: expected %s%q more to send %v, got %s%q more to send %v", tc.text, tc.pduType.String(), tc.data, tc.moreToSend, header.Type.String(), data, header.MoreToSend)
+		}
+		if header.ReleaseReason != tc.releaseReason || header.ErrorCode != tc.errorCode {
+			t.Errorf("%s: expected release reason %x and error code %x, got %x and %x", tc.text, tc.releaseReason, tc.errorCode, header.ReleaseReason, header.ErrorCode)
+		}
+	}
+	if _, _, err := ussdproxy.Decode([]byte("Z;")); err == nil {
+		t.Error("expected an error for an unknown PDU type")
+	}
+}
@@ -0,0 +1,251 @@
+// client is a UDCP client for devices and gateways which talk to ussdproxy
+// applications over USSD. The client sequences the PDUs of the protocol, it
+// splits data into chunks of ussdproxy.MaxDataLength, polls the server with
+// ReceiveReady PDUs while it has more to send and handles releases and errors.
+// The USSD messages are carried by a Transport e.g.
+//
+//	c, err := client.Dial(ctx, transport, "*1234*1234#")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	if err := c.SelectApp(ctx, "influx"); err != nil {
+//		return err
+//	}
+//	_, err = c.Send(ctx, []byte("volume=0.9282|tags=meter10,site.bt.mw,water-level"))
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+)
+
+// DefaultReceiveReadyLimit is how many ReceiveReady PDUs Receive sends while
+// the server has no data, as udcp.receive_ready_limit on the server
+const DefaultReceiveReadyLimit = ussdproxy.MaxReceiveReadyCount
+
+var (
+	// ErrReleased is returned when the server released the dialogue, see ReleaseReason
+	ErrReleased = errors.New("the dialogue was released")
+	// ErrClosed is returned when the dialogue has already ended
+	ErrClosed = errors.New("the dialogue has ended")
+	// ErrNoData is returned by Receive when the server has no data after polling
+	ErrNoData = errors.New("the server has no data to send")
+)
+
+// ServerError is an Error PDU sent by the server, which ends the dialogue
+type ServerError struct {
+	Code    ussdproxy.ErrorCode
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error %02x: %s", uint8(e.Code), e.Message)
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithReceiveReadyLimit sets how many ReceiveReady PDUs Receive sends while the server has no data
+func WithReceiveReadyLimit(limit int) Option {
+	return func(c *Client) {
+		c.receiveReadyLimit = limit
+	}
+}
+
+// Client is a UDCP dialogue with ussdproxy. A Client is not safe for concurrent use
+type Client struct {
+	transport         Transport
+	receiveReadyLimit int
+
+	ended         bool
+	releaseReason ussdproxy.ReleaseReason
+}
+
+// Dial dials the service code of ussdproxy with the transport and waits for
+// the server to be ready to receive
+func Dial(ctx context.Context, transport Transport, serviceCode string, options ...Option) (*Client, error) {
+	c := &Client{
+		transport:         transport,
+		receiveReadyLimit: DefaultReceiveReadyLimit,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	reply, err := transport.Dial(ctx, serviceCode)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	if _, err := c.read(ctx, reply); err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// SelectApp selects the application on the server which processes the data of the dialogue
+func (c *Client) SelectApp(ctx context.Context, applicationID string) error {
+	_, err := c.exchange(ctx, ussdproxy.ApplicationPduType, []byte("application="+applicationID))
+	return err
+}
+
+// Send sends the data to the application in chunks and returns the data the
+// application replied with, nil if it replied with a ReceiveReady. The data
+// is returned with ErrReleased when the application released the dialogue
+func (c *Client) Send(ctx context.Context, data []byte) ([]byte, error) {
+	return c.sendChunks(ctx, ussdproxy.DataLongPduType, ussdproxy.DataPduWithMtsType, data)
+}
+
+// Query sends the query e.g. q:apps and returns its result
+func (c *Client) Query(ctx context.Context, query string) ([]byte, error) {
+	return c.sendChunks(ctx, ussdproxy.QueryPduType, ussdproxy.QueryPduWithMtsType, []byte(query))
+}
+
+// Command sends the command e.g. c:app id:echo and returns its result, nil if it has none
+func (c *Client) Command(ctx context.Context, command string) ([]byte, error) {
+	return c.sendChunks(ctx, ussdproxy.CommandPduType, ussdproxy.CommandPduWithMtsType, []byte(command))
+}
+
+// SendPDU sends the encoded PDU as it is, e.g. a PDU typed by a user, and
+// returns the data of the reply like the other requests. A release ends the
+// dialogue, with the reason of the PDU or ReleaseCodeUserAbortMask
+func (c *Client) SendPDU(ctx context.Context, pdu string) ([]byte, error) {
+	header, _, err := ussdproxy.Decode([]byte(pdu))
+	if err != nil {
+		return nil, err
+	}
+	if c.ended {
+		return nil, ErrClosed
+	}
+	reply, err := c.send(ctx, pdu)
+	if header.Type == ussdproxy.ReleaseDialogPduType {
+		c.ended = true
+		c.releaseReason = header.ReleaseReason
+		if c.releaseReason == 0 {
+			c.releaseReason = ussdproxy.ReleaseCodeUserAbortMask
+		}
+		if err != nil {
+			// the network may end the dialogue without answering the release
+			return nil, ErrReleased
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.read(ctx, reply)
+	if header.Type == ussdproxy.ReleaseDialogPduType && err == nil {
+		err = ErrReleased
+	}
+	return data, err
+}
+
+// Receive polls the server with ReceiveReady PDUs for data, up to the receive
+// ready limit, and returns it. ErrNoData is returned if the server has none
+func (c *Client) Receive(ctx context.Context) ([]byte, error) {
+	for i := 0; i < c.receiveReadyLimit; i++ {
+		data, err := c.exchange(ctx, ussdproxy.ReceiveReadyPduType, nil)
+		if err != nil || data != nil {
+			return data, err
+		}
+	}
+	return nil, ErrNoData
+}
+
+// Close releases the dialogue, if it is still open, and closes the transport
+func (c *Client) Close() error {
+	if !c.ended {
+		c.ended = true
+		c.releaseReason = ussdproxy.ReleaseCodeUserAbortMask
+		// the server answers the release with a release, the dialogue is over either way
+		c.transport.Send(context.Background(), string(ussdproxy.Encode(ussdproxy.NewRequest(ussdproxy.ReleaseDialogPduType, nil))))
+	}
+	return c.transport.Close()
+}
+
+// Ended whether the dialogue has ended
+func (c *Client) Ended() bool {
+	return c.ended
+}
+
+// ReleaseReason the reason the dialogue was released, 0 while it is open
+func (c *Client) ReleaseReason() ussdproxy.ReleaseReason {
+	return c.releaseReason
+}
+
+// sendChunks sends the data in chunks of at most MaxDataLength, every chunk
+// but the last with the more to send type
+func (c *Client) sendChunks(ctx context.Context, last, more ussdproxy.PduType, data []byte) ([]byte, error) {
+	for len(data) > ussdproxy.MaxDataLength {
+		reply, err := c.exchange(ctx, more, data[:ussdproxy.MaxDataLength])
+		if err != nil {
+			return reply, err
+		}
+		if reply != nil {
+			return reply, fmt.Errorf("the server replied with data before the last chunk")
+		}
+		data = data[ussdproxy.MaxDataLength:]
+	}
+	return c.exchange(ctx, last, data)
+}
+
+// exchange sends a PDU and reads the reply
+func (c *Client) exchange(ctx context.Context, typ ussdproxy.PduType, data []byte) ([]byte, error) {
+	if c.ended {
+		return nil, ErrClosed
+	}
+	reply, err := c.send(ctx, string(ussdproxy.Encode(ussdproxy.NewRequest(typ, data))))
+	if err != nil {
+		return nil, err
+	}
+	return c.read(ctx, reply)
+}
+
+// send sends the encoded PDU with the transport
+func (c *Client) send(ctx context.Context, pdu string) (string, error) {
+	reply, err := c.transport.Send(ctx, pdu)
+	if errors.Is(err, ErrDialogueEnded) {
+		c.ended = true
+	}
+	return reply, err
+}
+
+// read reads the reply of the server, polling with ReceiveReady PDUs while
+// the server has more data to send. A ReceiveReady reply has no data
+func (c *Client) read(ctx context.Context, reply string) ([]byte, error) {
+	var data []byte
+	for {
+		header, chunk, err := ussdproxy.Decode([]byte(reply))
+		if err != nil {
+			return data, err
+		}
+		switch header.Type {
+		case ussdproxy.ReceiveReadyPduType:
+			return data, nil
+		case ussdproxy.ReleaseDialogPduType:
+			c.ended = true
+			c.releaseReason = header.ReleaseReason
+			return append(data, chunk...), ErrReleased
+		case ussdproxy.ErrorPduType:
+			c.ended = true
+			return data, &ServerError{Code: header.ErrorCode, Message: string(chunk)}
+		}
+		data = append(data, chunk...)
+		if data == nil {
+			// an empty chunk is still a reply with data
+			data = []byte{}
+		}
+		if !header.MoreToSend {
+			return data, nil
+		}
+		if reply, err = c.transport.Send(ctx, string(ussdproxy.Encode(ussdproxy.NewReceiveReadyRequest()))); err != nil {
+			if errors.Is(err, ErrDialogueEnded) {
+				c.ended = true
+			}
+			return data, err
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/client"
+)
+
+// exchange a message the client is expected to send and the server's reply
+type exchange struct {
+	send  string
+	reply string
+}
+
+// scriptedTransport replies to the messages of the client as scripted
+type scriptedTransport struct {
+	t      *testing.T
+	script []exchange
+	closed bool
+}
+
+func (s *scriptedTransport) Dial(ctx context.Context, serviceCode string) (string, error) {
+	return s.Send(ctx, serviceCode)
+}
+
+func (s *scriptedTransport) Send(ctx context.Context, message string) (string, error) {
+	if len(s.script) == 0 {
+		s.t.Fatalf("unexpected message %q", message)
+	}
+	next := s.script[0]
+	s.script = s.script[1:]
+	if message != next.send {
+		s.t.Fatalf("expected message %q, got %q", next.send, message)
+	}
+	return next.reply, nil
+}
+
+func (s *scriptedTransport) Close() error {
+	s.closed = true
+	return nil
+}
+
+func dial(t *testing.T, script ...exchange) (*client.Client, *scriptedTransport) {
+	t.Helper()
+	transport := &scriptedTransport{t: t, script: append([]exchange{{"*1234#", "R;__NODATA__"}}, script...)}
+	c, err := client.Dial(context.Background(), transport, "*1234#", client.WithReceiveReadyLimit(2))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return c, transport
+}
+
+func TestSendInChunks(t *testing.T) {
+	data := strings.Repeat("a", ussdproxy.MaxDataLength) + strings.Repeat("b", 10)
+	c, transport := dial(t,
+		exchange{"A;application=echo", "R;__NODATA__"},
+		exchange{"d;" + strings.Repeat("a", ussdproxy.MaxDataLength), "R;__NODATA__"},
+		exchange{"D;" + strings.Repeat("b", 10), "d;" + strings.Repeat("a", ussdproxy.MaxDataLength)},
+		exchange{"R;__NODATA__", "D;" + strings.Repeat("b", 10)},
+		exchange{"X;", "X;"},
+	)
+	if err := c.SelectApp(context.Background(), "echo"); err != nil {
+		t.Fatalf("failed to select the app: %v", err)
+	}
+	reply, err := c.Send(context.Background(), []byte(data))
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if string(reply) != data {
+		t.Errorf("expected the data to be echoed, got %q", reply)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if !transport.closed || len(transport.script) != 0 {
+		t.Errorf("expected the dialogue to be released and the transport closed")
+	}
+}
+
+func TestReleasedByServer(t *testing.T) {
+	c, _ := dial(t, exchange{"D;volume=0.9", "X;sessionID=1234"})
+	reply, err := c.Send(context.Background(), []byte("volume=0.9"))
+	if !errors.Is(err, client.ErrReleased) {
+		t.Fatalf("expected the dialogue to be released, got %v", err)
+	}
+	if string(reply) != "sessionID=1234" || c.ReleaseReason() != ussdproxy.ReleaseCodeUserAbortMask || !c.Ended() {
+		t.Errorf("expected the release data and reason, got %q and %x", reply, c.ReleaseReason())
+	}
+	if _, err := c.Send(context.Background(), []byte("more")); !errors.Is(err, client.ErrClosed) {
+		t.Errorf("expected the client to be closed, got %v", err)
+	}
+	// the dialogue was released, Close only closes the transport
+	c.Close()
+}
+
+func TestServerError(t *testing.T) {
+	c, _ := dial(t, exchange{"A;application=nope", "E;6a application 'nope' is not available"})
+	err := c.SelectApp(context.Background(), "nope")
+	var serverErr *client.ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("expected a server error, got %v", err)
+	}
+	if serverErr.Code != ussdproxy.ErrorCodeAppNotReadyMask || !c.Ended() {
+		t.Errorf("expected application not ready, got %v", serverErr)
+	}
+}
+
+func TestReceive(t *testing.T) {
+	c, _ := dial(t,
+		exchange{"R;__NODATA__", "R;__NODATA__"},
+		exchange{"R;__NODATA__", "D;reboot"},
+		exchange{"R;__NODATA__", "R;__NODATA__"},
+		exchange{"R;__NODATA__", "R;__NODATA__"},
+	)
+	data, err := c.Receive(context.Background())
+	if err != nil || string(data) != "reboot" {
+		t.Fatalf("expected the data of the server, got %q %v", data, err)
+	}
+	if _, err := c.Receive(context.Background()); !errors.Is(err, client.ErrNoData) {
+		t.Errorf("expected no data after the receive ready limit, got %v", err)
+	}
+}
+
+func TestQueryAndCommand(t *testing.T) {
+	c, _ := dial(t,
+		exchange{"Q;q:apps", "D;echo,influx"},
+		exchange{"C;c:app id:echo", "R;__NODATA__"},
+	)
+	apps, err := c.Query(context.Background(), "q:apps")
+	if err != nil || string(apps) != "echo,influx" {
+		t.Fatalf("expected the apps, got %q %v", apps, err)
+	}
+	result, err := c.Command(context.Background(), "c:app id:echo")
+	if err != nil || result != nil {
+		t.Fatalf("expected a command without a result, got %q %v", result, err)
+	}
+}
+
+func TestSendPDU(t *testing.T) {
+	c, _ := dial(t,
+		exchange{"d;hel", "R;__NODATA__"},
+		exchange{"D;lo", "d;hel"},
+		exchange{"R;__NODATA__", "D;lo"},
+		exchange{"X;", "X;"},
+	)
+	if data, err := c.SendPDU(context.Background(), "d;hel"); err != nil || data != nil {
+		t.Fatalf("expected a receive ready, got %q %v", data, err)
+	}
+	if data, err := c.SendPDU(context.Background(), "D;lo"); err != nil || string(data) != "hello" {
+		t.Fatalf("expected the data polled until the last chunk, got %q %v", data, err)
+	}
+	if _, err := c.SendPDU(context.Background(), "X;"); !errors.Is(err, client.ErrReleased) {
+		t.Fatalf("expected the dialogue to be released, got %v", err)
+	}
+	if !c.Ended() || c.ReleaseReason() != ussdproxy.ReleaseCodeUserAbortMask {
+		t.Errorf("expected a release by the user, got ended %v reason %x", c.Ended(), c.ReleaseReason())
+	}
+	if _, err := c.SendPDU(context.Background(), "D;more"); !errors.Is(err, client.ErrClosed) {
+		t.Errorf("expected the client to be closed, got %v", err)
+	}
+	if _, err := c.SendPDU(context.Background(), "Z;"); err == nil {
+		t.Error("expected an error for a message which is not a PDU")
+	}
+}
@@ -0,0 +1,150 @@
+package modem
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// gsm7Escape escapes the characters of the GSM 03.38 extension table
+const gsm7Escape = 0x1b
+
+// gsm7Basic the characters of the GSM 03.38 default alphabet, by septet
+var gsm7Basic = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+// gsm7Extension the characters of the GSM 03.38 extension table, by the septet after the escape
+var gsm7Extension = map[byte]rune{
+	0x0a: '\f', 0x14: '^', 0x28: '{', 0x29: '}', 0x2f: '\\',
+	0x3c: '[', 0x3d: '~', 0x3e: ']', 0x40: '|', 0x65: '€',
+}
+
+// encodeGSM7 encodes the text as septets of the GSM 03.38 default alphabet
+func encodeGSM7(text string) ([]byte, error) {
+	septets := make([]byte, 0, len(text))
+	for _, r := range text {
+		if septet := indexRune(gsm7Basic, r); septet >= 0 && septet != gsm7Escape {
+			septets = append(septets, byte(septet))
+			continue
+		}
+		found := false
+		for septet, ext := range gsm7Extension {
+			if ext == r {
+				septets = append(septets, gsm7Escape, septet)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("'%c' is not in the GSM 7-bit alphabet", r)
+		}
+	}
+	return septets, nil
+}
+
+// decodeGSM7 decodes septets of the GSM 03.38 default alphabet
+func decodeGSM7(septets []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(septets); i++ {
+		septet := septets[i] & 0x7f
+		if septet == gsm7Escape && i+1 < len(septets) {
+			i++
+			if r, ok := gsm7Extension[septets[i]&0x7f]; ok {
+				b.WriteRune(r)
+			} else {
+				b.WriteRune(' ')
+			}
+			continue
+		}
+		b.WriteRune(gsm7Basic[septet])
+	}
+	return b.String()
+}
+
+// packSeptets packs septets into octets, 8 septets in 7 octets
+func packSeptets(septets []byte) []byte {
+	packed := make([]byte, 0, (len(septets)*7+7)/8)
+	var acc uint
+	bits := uint(0)
+	for _, septet := range septets {
+		acc |= uint(septet&0x7f) << bits
+		bits += 7
+		for bits >= 8 {
+			packed = append(packed, byte(acc))
+			acc >>= 8
+			bits -= 8
+		}
+	}
+	if bits > 0 {
+		packed = append(packed, byte(acc))
+	}
+	return packed
+}
+
+// unpackSeptets unpacks septets packed into octets. The 7 free bits of a last
+// octet are padding, a carriage return as in USSD strings or zeros
+func unpackSeptets(packed []byte) []byte {
+	septets := make([]byte, 0, len(packed)*8/7)
+	var acc uint
+	bits := uint(0)
+	for _, octet := range packed {
+		acc |= uint(octet) << bits
+		bits += 8
+		for bits >= 7 {
+			septets = append(septets, byte(acc&0x7f))
+			acc >>= 7
+			bits -= 7
+		}
+	}
+	if n := len(septets); n > 0 && len(packed)%7 == 0 && (septets[n-1] == '\r' || septets[n-1] == 0) {
+		septets = septets[:n-1]
+	}
+	return septets
+}
+
+// encodePackedGSM7 encodes the text as hex of packed GSM 7-bit septets, as
+// modems which expect USSD strings in PDU mode do
+func encodePackedGSM7(text string) (string, error) {
+	septets, err := encodeGSM7(text)
+	if err != nil {
+		return "", err
+	}
+	// a last octet with 7 free bits would read as an @, pad it with a carriage return
+	if len(septets)%8 == 7 {
+		septets = append(septets, '\r')
+	}
+	return strings.ToUpper(hex.EncodeToString(packSeptets(septets))), nil
+}
+
+// decodePackedGSM7 decodes hex of packed GSM 7-bit septets
+func decodePackedGSM7(text string) (string, error) {
+	packed, err := hex.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+	return decodeGSM7(unpackSeptets(packed)), nil
+}
+
+// decodeUCS2 decodes hex of UCS-2 code units
+func decodeUCS2(text string) (string, error) {
+	raw, err := hex.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+	if len(raw)%2 != 0 {
+		return "", fmt.Errorf("UCS-2 string has an odd number of octets")
+	}
+	runes := make([]rune, 0, len(raw)/2)
+	for i := 0; i < len(raw); i += 2 {
+		runes = append(runes, rune(raw[i])<<8|rune(raw[i+1]))
+	}
+	return string(runes), nil
+}
+
+func indexRune(runes []rune, r rune) int {
+	for i, c := range runes {
+		if c == r {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,359 @@
+// modem is a client.Transport which dials USSD with the AT+CUSD command of a
+// GSM modem, the reference implementation of a device for ussdproxy
+//
+//	port, err := modem.Open("/dev/ttyUSB0")
+//	if err != nil {
+//		return err
+//	}
+//	c, err := client.Dial(ctx, modem.New(port, modem.Config{}), "*1234*1234#")
+//
+// The line settings of the serial port are not changed, configure them before
+// opening it e.g. stty -F /dev/ttyUSB0 115200 raw. The modem replies to AT+CUSD=1 with OK and later sends the USSD string of the
+// network in an unsolicited +CUSD: <m>,"<str>",<dcs> result. The data coding
+// scheme dcs selects how the string is decoded
+package modem
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nndi-oss/ussdproxy/pkg/client"
+)
+
+const (
+	// DefaultTimeout is how long the modem waits for the network's reply, USSD
+	// dialogues time out on the network after about 30 seconds
+	DefaultTimeout = 30 * time.Second
+
+	// DCSGSM7 the data coding scheme of GSM 7-bit strings, language unspecified
+	DCSGSM7 = 15
+)
+
+// +CUSD result codes, 3GPP TS 27.007
+const (
+	cusdNoActionRequired    = 0 // the network ended the dialogue with the string
+	cusdActionRequired      = 1 // the network waits for the reply of the user
+	cusdTerminatedByNetwork = 2
+	cusdOtherClient         = 3
+	cusdNotSupported        = 4
+	cusdNetworkTimeout      = 5
+)
+
+var (
+	// ErrModem is wrapped by errors the modem replied with, e.g. +CME ERROR: 100
+	ErrModem = errors.New("modem error")
+	// ErrTimeout is returned when the network did not reply in time
+	ErrTimeout = errors.New("timed out waiting for the USSD reply")
+)
+
+// Config configures the modem
+type Config struct {
+	Timeout time.Duration // How long to wait for the network's reply. default: DefaultTimeout
+	DCS     int           // Data coding scheme of the strings sent. default: DCSGSM7
+	// PackedGSM7 sends and reads GSM 7-bit strings as hex of packed septets, as
+	// modems in PDU mode do e.g. most Huawei modems. Otherwise strings are
+	// text in the modem's character set, see AT+CSCS
+	PackedGSM7 bool
+}
+
+// Modem is a client.Transport over the AT commands of a GSM modem
+type Modem struct {
+	config Config
+	port   io.ReadWriteCloser
+
+	results chan result // +CUSD results and final result codes read from the modem
+	done    chan struct{}
+
+	mu     sync.Mutex // guards open, closed and early
+	open   bool       // the network waits for the next string of the dialogue
+	closed bool
+	early  []result // +CUSD results read before the final result code of the command
+}
+
+// result a line of interest read from the modem
+type result struct {
+	line string
+	err  error
+}
+
+// Open opens the serial port of the modem
+func Open(path string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// New creates a transport which sends AT commands to the modem on the port and closes the port with Close
+func New(port io.ReadWriteCloser, cfg Config) *Modem {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.DCS == 0 {
+		cfg.DCS = DCSGSM7
+	}
+	m := &Modem{
+		config:  cfg,
+		port:    port,
+		results: make(chan result, 8),
+		done:    make(chan struct{}),
+	}
+	go m.read()
+	return m
+}
+
+// Dial dials the service code e.g. *1234*1234#
+func (m *Modem) Dial(ctx context.Context, serviceCode string) (string, error) {
+	return m.send(ctx, serviceCode)
+}
+
+// Send sends the reply of the user in the dialogue
+func (m *Modem) Send(ctx context.Context, message string) (string, error) {
+	m.mu.Lock()
+	open := m.open
+	m.mu.Unlock()
+	if !open {
+		return "", client.ErrDialogueEnded
+	}
+	return m.send(ctx, message)
+}
+
+// Close cancels the dialogue if it is open and closes the port
+func (m *Modem) Close() error {
+	m.mu.Lock()
+	open, closed := m.open, m.closed
+	m.open, m.closed = false, true
+	m.mu.Unlock()
+	if closed {
+		return nil
+	}
+	if open {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		m.command(ctx, "AT+CUSD=2")
+		cancel()
+	}
+	close(m.done)
+	m.mu.Lock()
+	m.early = nil
+	m.mu.Unlock()
+	return m.port.Close()
+}
+
+// send sends the string and waits for the network's reply
+func (m *Modem) send(ctx context.Context, message string) (string, error) {
+	text, err := m.encode(message)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+	if err := m.command(ctx, fmt.Sprintf("AT+CUSD=1,\"%s\",%d", text, m.config.DCS)); err != nil {
+		return "", err
+	}
+	for {
+		if r, ok := m.popEarly(); ok {
+			return m.reply(r.line)
+		}
+		select {
+		case r := <-m.results:
+			if r.err != nil {
+				return "", r.err
+			}
+			if strings.HasPrefix(r.line, "+CUSD:") {
+				return m.reply(r.line)
+			}
+		case <-ctx.Done():
+			m.setOpen(false)
+			return "", ErrTimeout
+		}
+	}
+}
+
+// command writes the AT command and waits for its final result code
+func (m *Modem) command(ctx context.Context, command string) error {
+	if _, err := io.WriteString(m.port, command+"\r"); err != nil {
+		return err
+	}
+	for {
+		select {
+		case r := <-m.results:
+			switch {
+			case r.err != nil:
+				return r.err
+			case r.line == "OK":
+				return nil
+			case r.line == "ERROR" || strings.HasPrefix(r.line, "+CME ERROR:"):
+				return fmt.Errorf("%w: %s replied %s", ErrModem, command, r.line)
+			case strings.HasPrefix(r.line, "+CUSD:"):
+				// some modems send the reply before OK
+				m.mu.Lock()
+				m.early = append(m.early, r)
+				m.mu.Unlock()
+			}
+		case <-ctx.Done():
+			return ErrTimeout
+		}
+	}
+}
+
+// popEarly removes the first +CUSD result read before a final result code
+func (m *Modem) popEarly() (result, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.early) == 0 {
+		return result{}, false
+	}
+	r := m.early[0]
+	m.early = m.early[1:]
+	return r, true
+}
+
+// reply parses a +CUSD: <m>[,"<str>"[,<dcs>]] result
+func (m *Modem) reply(line string) (string, error) {
+	status, text, dcs, err := parseCUSD(line)
+	if err != nil {
+		return "", err
+	}
+	switch status {
+	case cusdActionRequired:
+		m.setOpen(true)
+	case cusdNoActionRequired:
+		m.setOpen(false)
+	case cusdNotSupported:
+		m.setOpen(false)
+		return "", fmt.Errorf("%w: the network does not support the USSD operation", ErrModem)
+	default:
+		// terminated by the network, answered by another client or timed out
+		m.setOpen(false)
+		return "", client.ErrDialogueEnded
+	}
+	return m.decode(text, dcs)
+}
+
+func (m *Modem) setOpen(open bool) {
+	m.mu.Lock()
+	m.open = open
+	m.mu.Unlock()
+}
+
+func (m *Modem) encode(message string) (string, error) {
+	if m.config.PackedGSM7 && m.config.DCS == DCSGSM7 {
+		return encodePackedGSM7(message)
+	}
+	if strings.ContainsAny(message, "\"\r") {
+		return "", fmt.Errorf("the modem cannot send quotes or carriage returns in a USSD string")
+	}
+	return message, nil
+}
+
+// decode decodes the string by its data coding scheme, 3GPP TS 23.038
+func (m *Modem) decode(text string, dcs int) (string, error) {
+	switch {
+	case dcs == 0x11 || dcs&0xcc == 0x48:
+		return decodeUCS2(text)
+	case m.config.PackedGSM7 && (dcs&0xf0 == 0x00 || dcs&0xcc == 0x40):
+		return decodePackedGSM7(text)
+	default:
+		return text, nil
+	}
+}
+
+// parseCUSD parses the status, string and data coding scheme of a +CUSD result
+func parseCUSD(line string) (int, string, int, error) {
+	fields := strings.TrimSpace(strings.TrimPrefix(line, "+CUSD:"))
+	comma := strings.Index(fields, ",")
+	if comma < 0 {
+		comma = len(fields)
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(fields[:comma]))
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("%w: invalid result %s", ErrModem, line)
+	}
+	if comma == len(fields) {
+		return status, "", 0, nil
+	}
+	rest := strings.TrimSpace(fields[comma+1:])
+	if !strings.HasPrefix(rest, "\"") {
+		return 0, "", 0, fmt.Errorf("%w: invalid result %s", ErrModem, line)
+	}
+	end := strings.LastIndex(rest, "\"")
+	if end < 1 {
+		return 0, "", 0, fmt.Errorf("%w: unterminated string in %s", ErrModem, line)
+	}
+	text := rest[1:end]
+	dcs := DCSGSM7
+	if after := strings.TrimSpace(rest[end+1:]); strings.HasPrefix(after, ",") {
+		if dcs, err = strconv.Atoi(strings.TrimSpace(after[1:])); err != nil {
+			return 0, "", 0, fmt.Errorf("%w: invalid data coding scheme in %s", ErrModem, line)
+		}
+	}
+	return status, text, dcs, nil
+}
+
+// read reads the lines of the modem, joining +CUSD results whose string spans
+// lines, and passes the results of interest to the waiting command
+func (m *Modem) read() {
+	scanner := bufio.NewScanner(m.port)
+	scanner.Split(scanLines)
+	var pending string // a +CUSD result with an unterminated string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if pending != "" {
+			pending += "\n" + line
+			if strings.Count(pending, "\"")%2 == 0 {
+				m.deliver(result{line: pending})
+				pending = ""
+			}
+			continue
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "AT"):
+			// blank lines and the echo of commands
+		case strings.HasPrefix(line, "+CUSD:") && strings.Count(line, "\"")%2 == 1:
+			pending = line
+		case line == "OK", line == "ERROR", strings.HasPrefix(line, "+CME ERROR:"), strings.HasPrefix(line, "+CUSD:"):
+			m.deliver(result{line: line})
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	m.deliver(result{err: fmt.Errorf("%w: %v", ErrModem, err)})
+}
+
+func (m *Modem) deliver(r result) {
+	select {
+	case m.results <- r:
+	case <-m.done:
+	}
+}
+
+// scanLines splits on \r\n, \r or \n, modems end lines with \r\n
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		switch {
+		case b == '\n':
+			return i + 1, data[:i], nil
+		case b == '\r' && i+1 < len(data):
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		case b == '\r' && !atEOF:
+			// wait for a \n which may follow
+			return 0, nil, nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), bytes.TrimSuffix(data, []byte("\r")), nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,186 @@
+package modem
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nndi-oss/ussdproxy/pkg/client"
+)
+
+// step a command the modem expects and the lines it replies with
+type step struct {
+	command string
+	reply   []string
+}
+
+// simulate a modem on one end of a pipe which echoes the commands it receives
+// and replies as scripted, the other end is returned for the transport
+func simulate(t *testing.T, script ...step) net.Conn {
+	t.Helper()
+	port, sim := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer sim.Close()
+		reader := bufio.NewReader(sim)
+		for _, s := range script {
+			command, err := reader.ReadString('\r')
+			if err != nil {
+				t.Errorf("expected command %q, got %v", s.command, err)
+				return
+			}
+			if command = strings.TrimSuffix(command, "\r"); command != s.command {
+				t.Errorf("expected command %q, got %q", s.command, command)
+				return
+			}
+			out := command + "\r\r\n"
+			for _, line := range s.reply {
+				out += line + "\r\n"
+			}
+			if _, err := sim.Write([]byte(out)); err != nil {
+				t.Errorf("failed to reply to %q: %v", command, err)
+				return
+			}
+		}
+		// keep the port open until the transport closes it
+		io.Copy(ioutil.Discard, reader)
+	}()
+	t.Cleanup(func() {
+		port.Close()
+		<-done
+	})
+	return port
+}
+
+func TestDialAndContinue(t *testing.T) {
+	m := New(simulate(t,
+		step{`AT+CUSD=1,"*1234#",15`, []string{"OK", "", `+CUSD: 1,"Welcome",15`}},
+		step{`AT+CUSD=1,"1",15`, []string{`+CUSD: 0,"Bye",15`, "OK"}},
+	), Config{})
+	reply, err := m.Dial(context.Background(), "*1234#")
+	if err != nil || reply != "Welcome" {
+		t.Fatalf("expected the menu, got %q %v", reply, err)
+	}
+	// the reply before the OK ends the dialogue
+	reply, err = m.Send(context.Background(), "1")
+	if err != nil || reply != "Bye" {
+		t.Fatalf("expected the last message, got %q %v", reply, err)
+	}
+	if _, err := m.Send(context.Background(), "2"); !errors.Is(err, client.ErrDialogueEnded) {
+		t.Errorf("expected the dialogue to have ended, got %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+}
+
+func TestTerminatedByNetwork(t *testing.T) {
+	m := New(simulate(t, step{`AT+CUSD=1,"*1234#",15`, []string{"OK", "+CUSD: 2"}}), Config{})
+	defer m.Close()
+	if _, err := m.Dial(context.Background(), "*1234#"); !errors.Is(err, client.ErrDialogueEnded) {
+		t.Errorf("expected the dialogue to have ended, got %v", err)
+	}
+}
+
+func TestModemError(t *testing.T) {
+	m := New(simulate(t, step{`AT+CUSD=1,"*1234#",15`, []string{"+CME ERROR: 100"}}), Config{})
+	defer m.Close()
+	if _, err := m.Dial(context.Background(), "*1234#"); !errors.Is(err, ErrModem) || !strings.Contains(err.Error(), "+CME ERROR: 100") {
+		t.Errorf("expected the modem error, got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	m := New(simulate(t, step{`AT+CUSD=1,"*1234#",15`, []string{"OK"}}), Config{Timeout: 50 * time.Millisecond})
+	defer m.Close()
+	if _, err := m.Dial(context.Background(), "*1234#"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestCancelOnClose(t *testing.T) {
+	m := New(simulate(t,
+		step{`AT+CUSD=1,"*1234#",15`, []string{"OK", `+CUSD: 1,"Welcome",15`}},
+		step{"AT+CUSD=2", []string{"OK"}},
+	), Config{})
+	if _, err := m.Dial(context.Background(), "*1234#"); err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+}
+
+func TestDataCodingSchemes(t *testing.T) {
+	m := New(simulate(t,
+		step{`AT+CUSD=1,"*1234#",15`, []string{"OK", `+CUSD: 1,"004D0061006C0061007700690020263A",72`}},
+		step{`AT+CUSD=1,"1",15`, []string{"OK", `+CUSD: 1,"1. Balance`, `2. Bundles",15`}},
+		step{"AT+CUSD=2", []string{"OK"}},
+	), Config{})
+	defer m.Close()
+	reply, err := m.Dial(context.Background(), "*1234#")
+	if err != nil || reply != "Malawi ☺" {
+		t.Fatalf("expected the UCS2 text, got %q %v", reply, err)
+	}
+	reply, err = m.Send(context.Background(), "1")
+	if err != nil || reply != "1. Balance\n2. Bundles" {
+		t.Fatalf("expected the text spanning lines, got %q %v", reply, err)
+	}
+}
+
+func TestPackedGSM7(t *testing.T) {
+	packed, err := encodePackedGSM7("*100#")
+	if err != nil || packed != "AA180C3602" {
+		t.Fatalf("expected the packed service code, got %q %v", packed, err)
+	}
+	pack := func(text string) string {
+		packed, err := encodePackedGSM7(text)
+		if err != nil {
+			t.Fatalf("failed to pack %q: %v", text, err)
+		}
+		return packed
+	}
+	m := New(simulate(t,
+		step{`AT+CUSD=1,"AA180C3602",15`, []string{"OK", `+CUSD: 1,"` + pack("D;a") + `",15`}},
+		step{`AT+CUSD=1,"` + pack("D;abc") + `",15`, []string{"OK", `+CUSD: 0,"` + pack("Test") + `",15`}},
+	), Config{PackedGSM7: true})
+	defer m.Close()
+	reply, err := m.Dial(context.Background(), "*100#")
+	if err != nil || reply != "D;a" {
+		t.Fatalf("expected the unpacked reply, got %q %v", reply, err)
+	}
+	reply, err = m.Send(context.Background(), "D;abc")
+	if err != nil || reply != "Test" {
+		t.Fatalf("expected the unpacked reply, got %q %v", reply, err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	transport := New(simulate(t,
+		step{`AT+CUSD=1,"*1234*1234#",15`, []string{"OK", `+CUSD: 1,"R;__NODATA__",15`}},
+		step{`AT+CUSD=1,"A;application=echo",15`, []string{"OK", `+CUSD: 1,"R;__NODATA__",15`}},
+		step{`AT+CUSD=1,"D;hello",15`, []string{"OK", `+CUSD: 1,"D;hello",15`}},
+		step{`AT+CUSD=1,"X;",15`, []string{"OK", `+CUSD: 0,"X;",15`}},
+	), Config{})
+	c, err := client.Dial(context.Background(), transport, "*1234*1234#")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if err := c.SelectApp(context.Background(), "echo"); err != nil {
+		t.Fatalf("failed to select the app: %v", err)
+	}
+	reply, err := c.Send(context.Background(), []byte("hello"))
+	if err != nil || string(reply) != "hello" {
+		t.Fatalf("expected the data to be echoed, got %q %v", reply, err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+}
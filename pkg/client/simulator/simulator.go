@@ -0,0 +1,304 @@
+// simulator is a client.Transport which sends the messages of the client to
+// the callback URL of a running ussdproxy as a USSD provider would, e.g.
+//
+//	transport, err := simulator.New(simulator.Config{
+//		Provider: simulator.AfricasTalking,
+//		URL:      "http://localhost:3000/ussd/callback",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	c, err := client.Dial(ctx, transport, "*1234*1234#")
+//
+// This allows testing devices and applications without a mobile network. Each
+// dialogue has a new session ID, the msisdn is generated once unless it is set
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nndi-oss/ussdproxy/pkg/client"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/flares"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/truroute"
+)
+
+// Providers whose callbacks the simulator sends, named as in ussd.providers
+const (
+	AfricasTalking = "africastalking"
+	TruRoute       = "truroute"
+	Flares         = "flares"
+)
+
+// TruRoute message types
+const (
+	truRouteInitial  = 1
+	truRouteContinue = 2
+	truRouteRelease  = 3
+)
+
+// Config configures the simulator
+type Config struct {
+	Provider string // Provider the callbacks are sent as, see Providers
+	URL      string // Callback URL of the provider in ussdproxy
+	// Msisdn of the simulated subscriber. default: a random Malawian number
+	Msisdn     string
+	Header     http.Header  // Headers added to the callbacks, e.g. Authorization
+	HTTPClient *http.Client // default: http.DefaultClient
+}
+
+// Simulator is a USSD provider sending callbacks for the dialogues of a subscriber
+type Simulator struct {
+	config Config
+
+	sessionID   string
+	serviceCode string
+	hops        []string // the input of the subscriber in the dialogue, Africa's Talking sends all of it
+	open        bool
+}
+
+// Providers the providers the simulator can send callbacks as
+func Providers() []string {
+	return []string{AfricasTalking, TruRoute, Flares}
+}
+
+// New creates a simulator, the callbacks are sent when the client dials
+func New(cfg Config) (*Simulator, error) {
+	switch cfg.Provider {
+	case AfricasTalking, TruRoute, Flares:
+	default:
+		return nil, fmt.Errorf("unknown provider '%s', expected one of %s", cfg.Provider, strings.Join(Providers(), ", "))
+	}
+	if _, err := url.ParseRequestURI(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid callback URL '%s', got %v", cfg.URL, err)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.Msisdn == "" {
+		msisdn, err := randomMsisdn()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Msisdn = msisdn
+	}
+	return &Simulator{config: cfg}, nil
+}
+
+// SessionID the session ID of the current or last dialogue
+func (s *Simulator) SessionID() string {
+	return s.sessionID
+}
+
+// Msisdn the msisdn of the simulated subscriber
+func (s *Simulator) Msisdn() string {
+	return s.config.Msisdn
+}
+
+// Dial starts a new session by dialling the service code
+func (s *Simulator) Dial(ctx context.Context, serviceCode string) (string, error) {
+	sessionID, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	s.sessionID, s.serviceCode, s.hops, s.open = sessionID, serviceCode, nil, true
+	return s.callback(ctx, true, serviceCode)
+}
+
+// Send sends the input of the subscriber in the session
+func (s *Simulator) Send(ctx context.Context, message string) (string, error) {
+	if !s.open {
+		return "", client.ErrDialogueEnded
+	}
+	s.hops = append(s.hops, message)
+	return s.callback(ctx, false, message)
+}
+
+// Close ends the session, Flares notifies the application with a cleanup
+// request when the session is ended on the network
+func (s *Simulator) Close() error {
+	if !s.open {
+		return nil
+	}
+	s.open = false
+	if s.config.Provider != Flares {
+		return nil
+	}
+	body, err := s.flaresRequest(flares.RequestTypeCleanup, false, "")
+	if err != nil {
+		return err
+	}
+	_, err = s.post(context.Background(), "text/xml", body)
+	return err
+}
+
+// callback sends the callback of the provider with the message and returns the reply
+func (s *Simulator) callback(ctx context.Context, initial bool, message string) (string, error) {
+	var (
+		reply string
+		end   bool
+		err   error
+	)
+	switch s.config.Provider {
+	case AfricasTalking:
+		reply, end, err = s.africasTalking(ctx, initial)
+	case TruRoute:
+		reply, end, err = s.truRoute(ctx, initial, message)
+	case Flares:
+		reply, end, err = s.flares(ctx, initial, message)
+	}
+	if err != nil || end {
+		s.open = false
+	}
+	return reply, err
+}
+
+// africasTalking sends the form of Africa's Talking, text has the input of
+// every hop of the session joined with '*' and is empty when the service
+// code is dialled. The reply starts with CON, or END when the session ends
+func (s *Simulator) africasTalking(ctx context.Context, initial bool) (string, bool, error) {
+	form := url.Values{
+		"sessionId":   {s.sessionID},
+		"phoneNumber": {s.config.Msisdn},
+		"serviceCode": {s.serviceCode},
+		"text":        {strings.Join(s.hops, "*")},
+	}
+	body, err := s.post(ctx, "application/x-www-form-urlencoded", []byte(form.Encode()))
+	if err != nil {
+		return "", false, err
+	}
+	reply := string(body)
+	switch {
+	case strings.HasPrefix(reply, "CON"):
+		return trimSpaceOnce(reply[3:]), false, nil
+	case strings.HasPrefix(reply, "END"):
+		return trimSpaceOnce(reply[3:]), true, nil
+	default:
+		return "", true, fmt.Errorf("invalid reply without CON or END, got %q", reply)
+	}
+}
+
+// truRoute sends the XML of TruRoute, the service code is the message of the initial request
+func (s *Simulator) truRoute(ctx context.Context, initial bool, message string) (string, bool, error) {
+	request := truroute.TruRouteRequest{
+		Type:    truRouteContinue,
+		Message: message,
+		Session: s.sessionID,
+		Msisdn:  s.config.Msisdn,
+	}
+	if initial {
+		request.Type = truRouteInitial
+	}
+	data, err := xml.Marshal(request)
+	if err != nil {
+		return "", false, err
+	}
+	body, err := s.post(ctx, "text/xml", data)
+	if err != nil {
+		return "", false, err
+	}
+	var response truroute.TruRouteResponse
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return "", true, fmt.Errorf("invalid reply %q, got %v", body, err)
+	}
+	return response.GetText(), response.Type == truRouteRelease, nil
+}
+
+// flares sends the XML of Flares, the service code is the subscriber input of the new request
+func (s *Simulator) flares(ctx context.Context, initial bool, message string) (string, bool, error) {
+	data, err := s.flaresRequest(flares.RequestTypePull, initial, message)
+	if err != nil {
+		return "", false, err
+	}
+	body, err := s.post(ctx, "text/xml", data)
+	if err != nil {
+		return "", false, err
+	}
+	var response flares.FlaresResponse
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return "", true, fmt.Errorf("invalid reply %q, got %v", body, err)
+	}
+	return response.GetText(), response.IsRelease(), nil
+}
+
+func (s *Simulator) flaresRequest(requestType string, initial bool, message string) ([]byte, error) {
+	transactionID, err := randomHex(4)
+	if err != nil {
+		return nil, err
+	}
+	request := flares.FlaresRequest{
+		Type:          requestType,
+		Session:       s.sessionID,
+		TransactionID: transactionID,
+		Msisdn:        s.config.Msisdn,
+		FlowState:     "FD",
+		Message:       message,
+	}
+	if initial {
+		request.NewRequest = 1
+	}
+	data, err := xml.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// post sends the callback and returns the body of a successful reply
+func (s *Simulator) post(ctx context.Context, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range s.config.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	res, err := s.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	reply, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("callback failed with status %d: %s", res.StatusCode, strings.TrimSpace(string(reply)))
+	}
+	return reply, nil
+}
+
+// trimSpaceOnce trims the space separating CON or END from the message
+func trimSpaceOnce(s string) string {
+	if len(s) > 0 && (s[0] == ' ' || s[0] == '\n') {
+		return s[1:]
+	}
+	return s
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// randomMsisdn a number of a Malawian mobile network e.g. 265991234567
+func randomMsisdn() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("26599%07d", n.Int64()), nil
+}
@@ -0,0 +1,69 @@
+package simulator_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nndi-oss/ussdproxy/app/echo"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/client"
+	"github.com/nndi-oss/ussdproxy/pkg/client/simulator"
+	"github.com/nndi-oss/ussdproxy/pkg/server"
+	"github.com/nndi-oss/ussdproxy/pkg/session/memory"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+// dial serves the provider with the echo application and dials it with a simulator
+func dial(t *testing.T, provider string) (*client.Client, *simulator.Simulator) {
+	t.Helper()
+	ussdProvider, err := ussd.New(provider, nil)
+	if err != nil {
+		t.Fatalf("failed to create the provider: %v", err)
+	}
+	proxy := httptest.NewServer(server.NewHandler(ussdProvider, memory.New(), echo.NewEchoApplication()))
+	t.Cleanup(proxy.Close)
+
+	transport, err := simulator.New(simulator.Config{Provider: provider, URL: proxy.URL})
+	if err != nil {
+		t.Fatalf("failed to create the simulator: %v", err)
+	}
+	c, err := client.Dial(context.Background(), transport, "*1234#")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return c, transport
+}
+
+func TestEcho(t *testing.T) {
+	data := strings.Repeat("a", ussdproxy.MaxDataLength) + strings.Repeat("b", ussdproxy.MaxDataLength) + "c"
+	for _, provider := range simulator.Providers() {
+		t.Run(provider, func(t *testing.T) {
+			c, transport := dial(t, provider)
+			if transport.SessionID() == "" || !strings.HasPrefix(transport.Msisdn(), "265") {
+				t.Errorf("expected a generated session and msisdn, got '%s' and '%s'", transport.SessionID(), transport.Msisdn())
+			}
+			reply, err := c.Send(context.Background(), []byte(data))
+			if err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+			if string(reply) != data {
+				t.Errorf("expected the data to be echoed, got %q", reply)
+			}
+			if err := c.Close(); err != nil {
+				t.Errorf("failed to close: %v", err)
+			}
+			if _, err := transport.Send(context.Background(), "D;more"); !errors.Is(err, client.ErrDialogueEnded) {
+				t.Errorf("expected the session to have ended, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	if _, err := simulator.New(simulator.Config{Provider: "kannel", URL: "http://localhost:3000"}); err == nil {
+		t.Error("expected an error for a provider the simulator cannot send")
+	}
+}
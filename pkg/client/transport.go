@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDialogueEnded is returned by transports when the network ended the USSD
+// dialogue without a message, e.g. the session timed out
+var ErrDialogueEnded = errors.New("the USSD dialogue was ended by the network")
+
+// Transport carries the USSD messages of a dialogue between the client and
+// ussdproxy, e.g. a GSM modem or an HTTP simulator of a USSD provider. USSD is
+// two-way-alternate, every message sent is answered with one message
+type Transport interface {
+	// Dial starts a dialogue by dialling the service code and returns the first message of the server
+	Dial(ctx context.Context, serviceCode string) (string, error)
+
+	// Send sends the message in the dialogue and returns the reply of the server
+	Send(ctx context.Context, message string) (string, error)
+
+	// Close ends the dialogue, if it is still open, and releases the transport's resources
+	Close() error
+}
@@ -1,22 +1,59 @@
 package config
 
 import (
-	"fmt"
+	"net"
+	"strconv"
 
 	"github.com/nndi-oss/ussdproxy/pkg/ussd"
-	"github.com/nndi-oss/ussdproxy/pkg/ussd/africastalking"
-	"github.com/nndi-oss/ussdproxy/pkg/ussd/flares"
-	"github.com/nndi-oss/ussdproxy/pkg/ussd/truroute"
+	// The built-in providers are always available
+	_ "github.com/nndi-oss/ussdproxy/pkg/ussd/africastalking"
+	_ "github.com/nndi-oss/ussdproxy/pkg/ussd/flares"
+	_ "github.com/nndi-oss/ussdproxy/pkg/ussd/jsonprovider"
+	_ "github.com/nndi-oss/ussdproxy/pkg/ussd/kannel"
+	_ "github.com/nndi-oss/ussdproxy/pkg/ussd/templateprovider"
+	_ "github.com/nndi-oss/ussdproxy/pkg/ussd/truroute"
+)
+
+const (
+	DefaultHost = "localhost"
+	DefaultPort = 3000
 )
 
 // ServerConfig configuraiton
 type ServerConfig struct {
-	Name           string `mapstructure:"name"`
-	Host           string `mapstructure:"host"`
-	Port           int    `mapstructure:"port"`
-	Username       string `mapstructure:"username"`
-	Password       string `mapstructure:"password"`
-	RequestTimeout int    `mapstructure:"request_timeout"`
+	Name           string    `mapstructure:"name"`
+	Host           string    `mapstructure:"host"`
+	Port           int       `mapstructure:"port"`
+	Username       string    `mapstructure:"username"`
+	Password       string    `mapstructure:"password"`
+	RequestTimeout int       `mapstructure:"request_timeout"`
+	TLS            TLSConfig `mapstructure:"tls"`
+}
+
+// Addr the host:port the server listens on, defaults to localhost:3000
+func (s ServerConfig) Addr() string {
+	host := s.Host
+	if host == "" {
+		host = DefaultHost
+	}
+	port := s.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// TLSConfig configures HTTPS for the server. TLS is enabled when both cert and key are set
+type TLSConfig struct {
+	Cert       string `mapstructure:"cert"`        // PEM encoded certificate (chain) presented by the server
+	Key        string `mapstructure:"key"`         // PEM encoded private key for the certificate
+	CAStore    string `mapstructure:"ca_store"`    // PEM encoded CA bundle used to verify client certificates
+	ClientAuth string `mapstructure:"client_auth"` // none, request or require. default: none, or require when ca_store is set
+}
+
+// Enabled whether the server should serve HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.Cert != "" && t.Key != ""
 }
 
 // DatabaseConfig database configuration
@@ -49,22 +86,78 @@ type UdcpCommandsConfig struct {
 
 // SessionConfig is configuration for session management
 type SessionConfig struct {
-	Database string
-	Path     string
-	Name     string
-	Username string
-	Password string
+	Driver   string `mapstructure:"driver"` // default: boltdb
+	URL      string `mapstructure:"url"`
+	Database string `mapstructure:"database"`
+	Path     string `mapstructure:"path"` // Path of the BoltDB file for the boltdb driver
+	Name     string `mapstructure:"name"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
 }
 
-// AppConfig configuration
+// AppConfig configuration of an application, options other than name are
+// passed to the application's factory, see the app package
 type AppConfig struct {
-	Name string `mapstructure:"name"`
+	Name    string                 `mapstructure:"name"`
+	Options map[string]interface{} `mapstructure:",remain"`
 }
 
-// AppConfig configuration
+// UssdConfig configuration. Callbacks are served for each binding in providers,
+// provider and callback_url configure a single binding named after the provider
 type UssdConfig struct {
-	Provider    string `mapstructure:"provider"`
-	CallbackURL string `mapstructure:"callback_url"`
+	Provider    string                  `mapstructure:"provider"`
+	CallbackURL string                  `mapstructure:"callback_url"`
+	Auth        CallbackAuthConfig      `mapstructure:"auth"`
+	Options     map[string]interface{}  `mapstructure:"options"` // Options specific to the provider, see the provider's package
+	Providers   []ProviderBindingConfig `mapstructure:"providers"`
+}
+
+// ProviderBindingConfig binds a USSD provider to a callback path. Sessions are
+// namespaced by the binding's name so session IDs from different providers never collide
+type ProviderBindingConfig struct {
+	Name        string                 `mapstructure:"name"` // Unique name of the binding e.g. tnm-malawi
+	Provider    string                 `mapstructure:"provider"`
+	CallbackURL string                 `mapstructure:"callback_url"`
+	Auth        CallbackAuthConfig     `mapstructure:"auth"`
+	DefaultApp  string                 `mapstructure:"default_app"` // App new sessions start with. default: the first of udcp.apps
+	Options     map[string]interface{} `mapstructure:"options"`     // Options specific to the provider, see the provider's package
+}
+
+// Bindings the provider bindings to serve, including the binding configured by
+// ussd.provider and ussd.callback_url if the provider is set
+func (c UssdConfig) Bindings() []ProviderBindingConfig {
+	bindings := make([]ProviderBindingConfig, 0, len(c.Providers)+1)
+	if c.Provider != "" {
+		bindings = append(bindings, ProviderBindingConfig{
+			Name:        c.Provider,
+			Provider:    c.Provider,
+			CallbackURL: c.CallbackURL,
+			Auth:        c.Auth,
+			Options:     c.Options,
+		})
+	}
+	return append(bindings, c.Providers...)
+}
+
+// CallbackAuthConfig configures how callbacks from the USSD provider are verified.
+// All configured checks must pass for a callback to be processed
+type CallbackAuthConfig struct {
+	AllowedIPs []string `mapstructure:"allowed_ips"` // IP addresses or CIDR ranges the provider calls from
+	IPHeader   string   `mapstructure:"ip_header"`   // Header carrying the client IP when behind a reverse proxy e.g. X-Forwarded-For
+	// TrustedProxies are the IP addresses or CIDR ranges of the reverse proxies
+	// which set ip_header, the header is ignored unless the request comes from one
+	TrustedProxies []string   `mapstructure:"trusted_proxies"`
+	BasicAuth      bool       `mapstructure:"basic_auth"` // Require server.username and server.password via HTTP Basic Auth
+	HMAC           HMACConfig `mapstructure:"hmac"`
+}
+
+// HMACConfig configures verification of a signature header computed over the request body
+type HMACConfig struct {
+	Header    string `mapstructure:"header"`    // Header containing the signature e.g. X-Signature
+	Secret    string `mapstructure:"secret"`    // Shared secret used to compute the signature
+	Algorithm string `mapstructure:"algorithm"` // sha1, sha256 or sha512. default: sha256
+	Encoding  string `mapstructure:"encoding"`  // hex or base64. default: hex
+	Prefix    string `mapstructure:"prefix"`    // Prefix to strip from the header value e.g. "sha256="
 }
 
 // UdcpConfig configuration
@@ -75,6 +168,7 @@ type UdcpConfig struct {
 	MinBufferSize     uint16             `mapstructure:"min_buffer_size"`     // default: 512 # Minimum size of the buffer on the server and client side
 	MaxBufferSize     uint16             `mapstructure:"max_buffer_size"`     // default: 8096 # Maximum size of the buffer on the server and client side
 	Commands          UdcpCommandsConfig `mapstructure:"commands"`
+	AllowedDevices    []string           `mapstructure:"allowed_devices"` // MSISDNs of the devices that may use the server, all devices if empty
 	Session           SessionConfig      `mapstructure:"session"`
 	Apps              []AppConfig        `mapstructure:"apps"` // Services or Apps are applications running on the UDCP server
 }
@@ -87,23 +181,10 @@ type UssdProxyConfig struct {
 	Logging LoggingConfig `mapstructure:"logging"`
 }
 
-func Validate(cfg UssdProxyConfig) error {
-	// TODO: implement me!
-	return fmt.Errorf("not implemented")
-}
-
 func (c *UssdProxyConfig) GetProvider() ussd.UssdProvider {
-	if c.Ussd.Provider == "africastalking" {
-		return africastalking.New()
-	}
-
-	if c.Ussd.Provider == "truroute" {
-		return truroute.New()
+	provider, err := ussd.New(c.Ussd.Provider, c.Ussd.Options)
+	if err != nil {
+		return nil
 	}
-
-	if c.Ussd.Provider == "flares" {
-		return flares.New()
-	}
-
-	return nil
+	return provider
 }
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/nndi-oss/ussdproxy/pkg/secret"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the
+// configuration. The variable for a key is the key in upper case with dots
+// replaced by underscores e.g. server.tls.ca_store is USSDPROXY_SERVER_TLS_CA_STORE.
+// Lists such as ussd.auth.allowed_ips are comma separated. Lists of objects,
+// ussd.providers and udcp.apps, can only be set in the configuration file
+const EnvPrefix = "USSDPROXY"
+
+// EnvKeyReplacer replaces the separators of configuration keys to form environment variable names
+var EnvKeyReplacer = strings.NewReplacer(".", "_")
+
+// EnvKeys the keys of every configuration field that can be set from the
+// environment, lists of objects such as ussd.providers and udcp.apps are left out
+func EnvKeys() []string {
+	return envKeys("", reflect.TypeOf(UssdProxyConfig{}))
+}
+
+// EnvVar the name of the environment variable for the configuration key
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(EnvKeyReplacer.Replace(key))
+}
+
+func envKeys(prefix string, t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+		if name == "" {
+			continue
+		}
+		key := prefix + name
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			keys = append(keys, envKeys(key+".", field.Type)...)
+		case reflect.Slice:
+			if field.Type.Elem().Kind() == reflect.String {
+				keys = append(keys, key)
+			}
+		default:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ResolveSecrets replaces the credentials in the configuration which reference
+// an environment variable (env:NAME) or a file (file:/path) with the secret, see the secret package.
+// Credentials in app options are resolved by the apps
+func (c *UssdProxyConfig) ResolveSecrets() error {
+	v := &ValidationError{}
+	resolve := func(path string, value *string) {
+		if err := secret.ResolveAll(value); err != nil {
+			v.add(path, "%v", err)
+		}
+	}
+	resolve("server.username", &c.Server.Username)
+	resolve("server.password", &c.Server.Password)
+	resolve("ussd.auth.hmac.secret", &c.Ussd.Auth.HMAC.Secret)
+	for i := range c.Ussd.Providers {
+		resolve(fmt.Sprintf("ussd.providers[%d].auth.hmac.secret", i), &c.Ussd.Providers[i].Auth.HMAC.Secret)
+	}
+	resolve("udcp.session.url", &c.Udcp.Session.URL)
+	resolve("udcp.session.username", &c.Udcp.Session.Username)
+	resolve("udcp.session.password", &c.Udcp.Session.Password)
+
+	if len(v.Errors) > 0 {
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/nndi-oss/ussdproxy/app"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+const (
+	// MaxBufferSizeLimit is the largest session buffer that can be configured
+	MaxBufferSizeLimit = 32_768
+)
+
+// sessionDrivers the supported session drivers and the fields they require
+var sessionDrivers = map[string][]string{
+	"boltdb": {"path"},
+	"memory": {},
+}
+
+// FieldError is a problem with the value at a YAML path of the configuration
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError reports all the problems found in a configuration
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Error()
+	}
+	return fmt.Sprintf("invalid configuration, found %d problem(s):\n  %s", len(e.Errors), strings.Join(messages, "\n  "))
+}
+
+func (e *ValidationError) add(path, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the configuration and returns a *ValidationError listing every
+// problem found, or nil if the configuration is valid.
+//
+// Apps are checked against the applications registered with the app package,
+// so the packages of the configured apps must be imported
+func Validate(cfg UssdProxyConfig) error {
+	v := &ValidationError{}
+
+	validateServer(v, cfg.Server)
+	validateUssd(v, cfg.Ussd, cfg.Udcp.Apps)
+	validateUdcp(v, cfg.Udcp)
+
+	if len(v.Errors) > 0 {
+		return v
+	}
+	return nil
+}
+
+func validateServer(v *ValidationError, server ServerConfig) {
+	if server.Port < 1 || server.Port > 65535 {
+		v.add("server.port", "must be between 1 and 65535, got %d", server.Port)
+	}
+	if server.RequestTimeout < 0 {
+		v.add("server.request_timeout", "must not be negative, got %d", server.RequestTimeout)
+	}
+	if (server.TLS.Cert == "") != (server.TLS.Key == "") {
+		v.add("server.tls", "cert and key must be set together")
+	}
+	switch strings.ToLower(server.TLS.ClientAuth) {
+	case "", "none", "request", "require":
+	default:
+		v.add("server.tls.client_auth", "must be one of none, request or require, got '%s'", server.TLS.ClientAuth)
+	}
+}
+
+func validateUssd(v *ValidationError, ussdConfig UssdConfig, apps []AppConfig) {
+	if ussdConfig.Provider == "" && len(ussdConfig.Providers) == 0 {
+		v.add("ussd.provider", "is required unless ussd.providers is set")
+		return
+	}
+	names := make(map[string]string)
+	callbackURLs := make(map[string]string)
+	checkUnique := func(path string, binding ProviderBindingConfig) {
+		if other, ok := names[binding.Name]; ok {
+			v.add(path+".name", "binding '%s' is already configured by %s", binding.Name, other)
+		} else {
+			names[binding.Name] = path
+		}
+		if other, ok := callbackURLs[binding.CallbackURL]; ok && binding.CallbackURL != "" {
+			v.add(path+".callback_url", "'%s' is already used by %s", binding.CallbackURL, other)
+		} else {
+			callbackURLs[binding.CallbackURL] = path
+		}
+	}
+
+	if ussdConfig.Provider != "" {
+		validateAuth(v, "ussd.auth", ussdConfig.Auth)
+		validateProvider(v, "ussd.", ussdConfig.Provider, ussdConfig.Options)
+		validateCallbackURL(v, "ussd.callback_url", ussdConfig.CallbackURL)
+		checkUnique("ussd", ussdConfig.Bindings()[0])
+	}
+	appNames := []string{"echo"} // the echo app is served when udcp.apps is empty
+	if len(apps) > 0 {
+		appNames = make([]string, len(apps))
+		for i, appConfig := range apps {
+			appNames[i] = appConfig.Name
+		}
+	}
+	for i, binding := range ussdConfig.Providers {
+		path := fmt.Sprintf("ussd.providers[%d]", i)
+		if binding.Name == "" {
+			v.add(path+".name", "is required")
+		}
+		validateAuth(v, path+".auth", binding.Auth)
+		validateProvider(v, path+".", binding.Provider, binding.Options)
+		validateCallbackURL(v, path+".callback_url", binding.CallbackURL)
+		if binding.DefaultApp != "" && !ussd.ContainsString(appNames, binding.DefaultApp) {
+			v.add(path+".default_app", "app '%s' is not configured, expected one of %s", binding.DefaultApp, strings.Join(appNames, ", "))
+		}
+		checkUnique(path, binding)
+	}
+}
+
+// validateProvider checks the provider is registered with the ussd package and accepts the options
+func validateProvider(v *ValidationError, path, provider string, options map[string]interface{}) {
+	if !ussd.ContainsString(ussd.Registered(), provider) {
+		v.add(path+"provider", "unknown provider '%s', expected one of %s", provider, strings.Join(ussd.Registered(), ", "))
+		return
+	}
+	if _, err := ussd.New(provider, options); err != nil {
+		v.add(path+"options", "%v", err)
+	}
+}
+
+func validateAuth(v *ValidationError, path string, auth CallbackAuthConfig) {
+	if auth.IPHeader != "" && len(auth.TrustedProxies) == 0 {
+		v.add(path+".trusted_proxies", "is required when ip_header is set, otherwise any client can set the header")
+	}
+}
+
+func validateCallbackURL(v *ValidationError, path, callbackURL string) {
+	if callbackURL == "" {
+		v.add(path, "is required")
+		return
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		v.add(path, "is not a valid URL path: %v", err)
+		return
+	}
+	if u.Scheme != "" || u.Host != "" || !strings.HasPrefix(callbackURL, "/") {
+		v.add(path, "must be a path starting with '/' e.g. /ussd/callback, got '%s'", callbackURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		v.add(path, "must not contain a query or fragment, got '%s'", callbackURL)
+	}
+}
+
+func validateUdcp(v *ValidationError, udcp UdcpConfig) {
+	if udcp.MinBufferSize > udcp.MaxBufferSize && udcp.MaxBufferSize != 0 {
+		v.add("udcp.min_buffer_size", "must not be greater than udcp.max_buffer_size (%d), got %d", udcp.MaxBufferSize, udcp.MinBufferSize)
+	}
+	if udcp.MaxBufferSize > MaxBufferSizeLimit {
+		v.add("udcp.max_buffer_size", "must not be greater than %d, got %d", MaxBufferSizeLimit, udcp.MaxBufferSize)
+	}
+	if udcp.MinBufferSize > MaxBufferSizeLimit {
+		v.add("udcp.min_buffer_size", "must not be greater than %d, got %d", MaxBufferSizeLimit, udcp.MinBufferSize)
+	}
+
+	for i, device := range udcp.AllowedDevices {
+		if strings.Trim(strings.TrimPrefix(device, "+"), "0123456789") != "" || strings.TrimPrefix(device, "+") == "" {
+			v.add(fmt.Sprintf("udcp.allowed_devices[%d]", i), "must be an MSISDN e.g. 265991234567, got '%s'", device)
+		}
+	}
+
+	validateSession(v, udcp.Session)
+
+	registered := app.Registered()
+	seen := make(map[string]bool)
+	for i, appConfig := range udcp.Apps {
+		path := fmt.Sprintf("udcp.apps[%d].name", i)
+		if appConfig.Name == "" {
+			v.add(path, "is required")
+			continue
+		}
+		if seen[appConfig.Name] {
+			v.add(path, "app '%s' is listed more than once", appConfig.Name)
+		}
+		seen[appConfig.Name] = true
+		if !ussd.ContainsString(registered, appConfig.Name) {
+			v.add(path, "unknown app '%s', expected one of %s", appConfig.Name, strings.Join(registered, ", "))
+		}
+	}
+}
+
+func validateSession(v *ValidationError, session SessionConfig) {
+	driver := session.Driver
+	if driver == "" {
+		// the boltdb driver with its default path is used
+		return
+	}
+	required, ok := sessionDrivers[driver]
+	if !ok {
+		drivers := make([]string, 0, len(sessionDrivers))
+		for name := range sessionDrivers {
+			drivers = append(drivers, name)
+		}
+		sort.Strings(drivers)
+		v.add("udcp.session.driver", "unknown session driver '%s', expected one of %s", driver, strings.Join(drivers, ", "))
+		return
+	}
+	values := map[string]string{
+		"url":      session.URL,
+		"path":     session.Path,
+		"database": session.Database,
+	}
+	for _, field := range required {
+		if values[field] == "" {
+			v.add("udcp.session."+field, "is required for the %s session driver", driver)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// secret resolves configuration values that reference a secret kept outside
+// the configuration file, so secrets need not be baked into YAML e.g.
+//
+//	password: env:MQTT_PASSWORD           # read from the MQTT_PASSWORD environment variable
+//	password: file:/run/secrets/mqtt_pass # read from a file e.g. a Docker or Kubernetes secret
+//
+// Values without one of the prefixes are used as they are
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	EnvPrefix  = "env:"
+	FilePrefix = "file:"
+)
+
+// Resolve returns the secret value references, trailing newlines are trimmed
+// from secrets read from files
+func Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, EnvPrefix):
+		name := strings.TrimPrefix(value, EnvPrefix)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, FilePrefix):
+		path := strings.TrimPrefix(value, FilePrefix)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %v", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// ResolveAll resolves each of the values in place
+func ResolveAll(values ...*string) error {
+	for _, value := range values {
+		secret, err := Resolve(*value)
+		if err != nil {
+			return err
+		}
+		*value = secret
+	}
+	return nil
+}
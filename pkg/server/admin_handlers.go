@@ -1,6 +1,10 @@
 package server
 
-import "github.com/valyala/fasthttp"
+import (
+	"encoding/json"
+
+	"github.com/valyala/fasthttp"
+)
 
 func (s *UssdProxyServer) notImplementedHandler(ctx *fasthttp.RequestCtx) {
 	data, err := unhealthy().JSON()
@@ -9,3 +13,16 @@ func (s *UssdProxyServer) notImplementedHandler(ctx *fasthttp.RequestCtx) {
 	}
 	ctx.Write(data)
 }
+
+func (s *UssdProxyServer) appsHandler(ctx *fasthttp.RequestCtx) {
+	statuses := []appStatus{}
+	if _, supervisor := s.application(); supervisor != nil {
+		statuses = supervisor.Status()
+	}
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		panic(err)
+	}
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.Write(data)
+}
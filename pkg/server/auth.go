@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+var (
+	ErrCallbackIPNotAllowed     = errors.New("callback source address is not in the allowed list")
+	ErrCallbackUnauthorized     = errors.New("callback credentials missing or invalid")
+	ErrCallbackInvalidSignature = errors.New("callback signature missing or invalid")
+)
+
+// callbackVerifier verifies that a callback was sent by the configured USSD provider
+type callbackVerifier struct {
+	allowedNets    []*net.IPNet
+	ipHeader       string
+	trustedProxies []*net.IPNet
+
+	basicAuth bool
+	username  string
+	password  string
+
+	hmacHeader   string
+	hmacPrefix   string
+	hmacEncoding string
+	hmacSecret   []byte
+	hmacHash     func() hash.Hash
+}
+
+func newCallbackVerifier(auth config.CallbackAuthConfig, server config.ServerConfig) (*callbackVerifier, error) {
+	v := &callbackVerifier{
+		ipHeader:  auth.IPHeader,
+		basicAuth: auth.BasicAuth,
+		username:  server.Username,
+		password:  server.Password,
+	}
+
+	for _, entry := range auth.AllowedIPs {
+		ipNet, err := parseIPOrCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%v in allowed_ips", err)
+		}
+		v.allowedNets = append(v.allowedNets, ipNet)
+	}
+	for _, entry := range auth.TrustedProxies {
+		ipNet, err := parseIPOrCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%v in trusted_proxies", err)
+		}
+		v.trustedProxies = append(v.trustedProxies, ipNet)
+	}
+
+	if auth.BasicAuth && (server.Username == "" || server.Password == "") {
+		return nil, fmt.Errorf("basic_auth requires server.username and server.password to be set")
+	}
+
+	if auth.HMAC.Header != "" {
+		if auth.HMAC.Secret == "" {
+			return nil, fmt.Errorf("hmac verification on header '%s' requires a secret", auth.HMAC.Header)
+		}
+		switch strings.ToLower(auth.HMAC.Algorithm) {
+		case "", "sha256":
+			v.hmacHash = sha256.New
+		case "sha1":
+			v.hmacHash = sha1.New
+		case "sha512":
+			v.hmacHash = sha512.New
+		default:
+			return nil, fmt.Errorf("unsupported hmac algorithm '%s'", auth.HMAC.Algorithm)
+		}
+		switch strings.ToLower(auth.HMAC.Encoding) {
+		case "", "hex":
+			v.hmacEncoding = "hex"
+		case "base64":
+			v.hmacEncoding = "base64"
+		default:
+			return nil, fmt.Errorf("unsupported hmac encoding '%s'", auth.HMAC.Encoding)
+		}
+		v.hmacHeader = auth.HMAC.Header
+		v.hmacPrefix = auth.HMAC.Prefix
+		v.hmacSecret = []byte(auth.HMAC.Secret)
+	}
+
+	return v, nil
+}
+
+func parseIPOrCIDR(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s': %v", entry, err)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address '%s'", entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Verify checks the source address, credentials and signature of the request
+func (v *callbackVerifier) Verify(request *ussd.Request) error {
+	if len(v.allowedNets) > 0 {
+		if err := v.verifySourceIP(v.sourceIP(request)); err != nil {
+			return err
+		}
+	}
+	if v.basicAuth {
+		if err := v.verifyBasicAuth(request.Header.Get("Authorization")); err != nil {
+			return err
+		}
+	}
+	if v.hmacHeader != "" {
+		if err := v.verifySignature(request.Header.Get(v.hmacHeader), request.Body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceIP the address of the client which sent the request. The ip_header is
+// only read when the request comes from a trusted proxy, the client is the
+// rightmost address of the header which is not a trusted proxy as the entries
+// on its left are set by the client
+func (v *callbackVerifier) sourceIP(request *ussd.Request) net.IP {
+	remoteIP := request.RemoteIP()
+	if v.ipHeader == "" || !containsIP(v.trustedProxies, remoteIP) {
+		return remoteIP
+	}
+	var entries []string
+	for _, value := range request.Header.Values(v.ipHeader) {
+		entries = append(entries, strings.Split(value, ",")...)
+	}
+	if len(entries) == 0 {
+		return remoteIP
+	}
+	var ip net.IP
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(entries[i]))
+		if ip == nil || !containsIP(v.trustedProxies, ip) {
+			return ip
+		}
+	}
+	// every address is a trusted proxy, the leftmost is the closest to the client
+	return ip
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *callbackVerifier) verifySourceIP(ip net.IP) error {
+	if ip == nil {
+		return ErrCallbackIPNotAllowed
+	}
+	if containsIP(v.allowedNets, ip) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCallbackIPNotAllowed, ip)
+}
+
+func (v *callbackVerifier) verifyBasicAuth(authorization string) error {
+	const prefix = "Basic "
+	value := authorization
+	if !strings.HasPrefix(value, prefix) {
+		return ErrCallbackUnauthorized
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value[len(prefix):])
+	if err != nil {
+		return ErrCallbackUnauthorized
+	}
+	credentials := strings.SplitN(string(decoded), ":", 2)
+	if len(credentials) != 2 {
+		return ErrCallbackUnauthorized
+	}
+	usernameOk := subtle.ConstantTimeCompare([]byte(credentials[0]), []byte(v.username)) == 1
+	passwordOk := subtle.ConstantTimeCompare([]byte(credentials[1]), []byte(v.password)) == 1
+	if !usernameOk || !passwordOk {
+		return ErrCallbackUnauthorized
+	}
+	return nil
+}
+
+func (v *callbackVerifier) verifySignature(signature string, body []byte) error {
+	value := strings.TrimPrefix(strings.TrimSpace(signature), v.hmacPrefix)
+	if value == "" {
+		return ErrCallbackInvalidSignature
+	}
+	var expected []byte
+	var err error
+	if v.hmacEncoding == "base64" {
+		expected, err = base64.StdEncoding.DecodeString(value)
+	} else {
+		expected, err = hex.DecodeString(value)
+	}
+	if err != nil {
+		return ErrCallbackInvalidSignature
+	}
+	mac := hmac.New(v.hmacHash, v.hmacSecret)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrCallbackInvalidSignature
+	}
+	return nil
+}
+
+// verifyCallback wraps the handler of a binding and only calls it once the
+// request passes the binding's verification
+func (s *UssdProxyServer) verifyCallback(binding *providerBinding, next callbackHandler) callbackHandler {
+	return func(request *ussd.Request) *ussd.Response {
+		s.configMu.RLock()
+		verifier := s.verifiers[binding.name]
+		s.configMu.RUnlock()
+		if err := verifier.Verify(request); err != nil {
+			s.logger.Warn("rejected ussd callback", "binding", binding.name, "remote_addr", request.RemoteAddr, "path", request.Path, "error", err)
+			response := ussd.NewResponse()
+			response.StatusCode = http.StatusUnauthorized
+			if errors.Is(err, ErrCallbackIPNotAllowed) {
+				response.StatusCode = http.StatusForbidden
+			}
+			return response
+		}
+		return next(request)
+	}
+}
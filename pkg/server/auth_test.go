@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+func TestParseIPOrCIDR(t *testing.T) {
+	testCases := []struct {
+		entry    string
+		expected string
+		invalid  bool
+	}{
+		{entry: "127.0.0.1", expected: "127.0.0.1/32"},
+		{entry: "196.201.214.0/24", expected: "196.201.214.0/24"},
+		{entry: "196.201.214.7/24", expected: "196.201.214.0/24"},
+		{entry: "::1", expected: "::1/128"},
+		{entry: "localhost", invalid: true},
+		{entry: "10.0.0.0/33", invalid: true},
+	}
+	for _, tc := range testCases {
+		ipNet, err := parseIPOrCIDR(tc.entry)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", tc.entry, ipNet)
+			}
+			continue
+		}
+		if err != nil || ipNet.String() != tc.expected {
+			t.Errorf("%s: expected %s, got %v %v", tc.entry, tc.expected, ipNet, err)
+		}
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	testCases := []struct {
+		name           string
+		ipHeader       string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   []string
+		expected       string
+	}{
+		{name: "no header", remoteAddr: "41.77.8.1:5000", expected: "41.77.8.1"},
+		{name: "header ignored without trusted proxies", ipHeader: "X-Forwarded-For", remoteAddr: "41.77.8.1:5000", forwardedFor: []string{"127.0.0.1"}, expected: "41.77.8.1"},
+		{name: "header ignored from an untrusted peer", ipHeader: "X-Forwarded-For", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "41.77.8.1:5000", forwardedFor: []string{"127.0.0.1"}, expected: "41.77.8.1"},
+		{name: "client of a trusted proxy", ipHeader: "X-Forwarded-For", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"196.201.214.7"}, expected: "196.201.214.7"},
+		{name: "spoofed leftmost entry", ipHeader: "X-Forwarded-For", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"127.0.0.1, 41.77.8.1"}, expected: "41.77.8.1"},
+		{name: "chain of trusted proxies", ipHeader: "X-Forwarded-For", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"127.0.0.1, 41.77.8.1, 10.0.0.3", "10.0.0.4"}, expected: "41.77.8.1"},
+		{name: "only trusted proxies", ipHeader: "X-Forwarded-For", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"10.0.0.3, 10.0.0.4"}, expected: "10.0.0.3"},
+		{name: "trusted proxy without the header", ipHeader: "X-Forwarded-For", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.2:5000", expected: "10.0.0.2"},
+		{name: "invalid entry", ipHeader: "X-Forwarded-For", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"127.0.0.1, unknown"}, expected: "<nil>"},
+	}
+	for _, tc := range testCases {
+		v, err := newCallbackVerifier(config.CallbackAuthConfig{IPHeader: tc.ipHeader, TrustedProxies: tc.trustedProxies}, config.ServerConfig{})
+		if err != nil {
+			t.Fatalf("%s: failed to create the verifier: %v", tc.name, err)
+		}
+		request := &ussd.Request{RemoteAddr: tc.remoteAddr, Header: http.Header{}}
+		for _, value := range tc.forwardedFor {
+			request.Header.Add("X-Forwarded-For", value)
+		}
+		if ip := v.sourceIP(request); ip.String() != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, ip)
+		}
+	}
+}
+
+func TestVerifySpoofedForwardedFor(t *testing.T) {
+	v, err := newCallbackVerifier(config.CallbackAuthConfig{AllowedIPs: []string{"127.0.0.1"}, IPHeader: "X-Forwarded-For"}, config.ServerConfig{})
+	if err != nil {
+		t.Fatalf("failed to create the verifier: %v", err)
+	}
+	request := &ussd.Request{RemoteAddr: "41.77.8.1:5000", Header: http.Header{"X-Forwarded-For": {"127.0.0.1"}}}
+	if err := v.Verify(request); !errors.Is(err, ErrCallbackIPNotAllowed) {
+		t.Errorf("expected the spoofed address to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyBasicAuth(t *testing.T) {
+	v, err := newCallbackVerifier(config.CallbackAuthConfig{BasicAuth: true}, config.ServerConfig{Username: "ussd", Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create the verifier: %v", err)
+	}
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	testCases := []struct {
+		name          string
+		authorization string
+		valid         bool
+	}{
+		{name: "valid", authorization: basic("ussd:secret"), valid: true},
+		{name: "password with a colon", authorization: basic("ussd:secret:more")},
+		{name: "wrong password", authorization: basic("ussd:wrong")},
+		{name: "wrong username", authorization: basic("admin:secret")},
+		{name: "no separator", authorization: basic("ussdsecret")},
+		{name: "not base64", authorization: "Basic !!!"},
+		{name: "bearer", authorization: "Bearer token"},
+		{name: "missing"},
+	}
+	for _, tc := range testCases {
+		err := v.verifyBasicAuth(tc.authorization)
+		if tc.valid && err != nil {
+			t.Errorf("%s: expected the credentials to be accepted, got %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrCallbackUnauthorized) {
+			t.Errorf("%s: expected the credentials to be rejected, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte("sessionId=1234&text=D;hello")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	sum := mac.Sum(nil)
+
+	testCases := []struct {
+		name      string
+		hmac      config.HMACConfig
+		signature string
+		valid     bool
+	}{
+		{name: "hex", hmac: config.HMACConfig{Header: "X-Signature", Secret: "secret"}, signature: hex.EncodeToString(sum), valid: true},
+		{name: "base64", hmac: config.HMACConfig{Header: "X-Signature", Secret: "secret", Encoding: "base64"}, signature: base64.StdEncoding.EncodeToString(sum), valid: true},
+		{name: "prefix", hmac: config.HMACConfig{Header: "X-Signature", Secret: "secret", Prefix: "sha256="}, signature: "sha256=" + hex.EncodeToString(sum), valid: true},
+		{name: "other secret", hmac: config.HMACConfig{Header: "X-Signature", Secret: "other"}, signature: hex.EncodeToString(sum)},
+		{name: "other algorithm", hmac: config.HMACConfig{Header: "X-Signature", Secret: "secret", Algorithm: "sha512"}, signature: hex.EncodeToString(sum)},
+		{name: "not hex", hmac: config.HMACConfig{Header: "X-Signature", Secret: "secret"}, signature: "zz"},
+		{name: "missing", hmac: config.HMACConfig{Header: "X-Signature", Secret: "secret"}},
+	}
+	for _, tc := range testCases {
+		v, err := newCallbackVerifier(config.CallbackAuthConfig{HMAC: tc.hmac}, config.ServerConfig{})
+		if err != nil {
+			t.Fatalf("%s: failed to create the verifier: %v", tc.name, err)
+		}
+		err = v.verifySignature(tc.signature, body)
+		if tc.valid && err != nil {
+			t.Errorf("%s: expected the signature to be accepted, got %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrCallbackInvalidSignature) {
+			t.Errorf("%s: expected the signature to be rejected, got %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+// providerBinding serves the callbacks of a USSD provider on a callback path
+type providerBinding struct {
+	name        string
+	callbackURL string
+	defaultApp  string // name of the app in udcp.apps new sessions start with
+	provider    ussd.UssdProvider
+}
+
+func newProviderBindings(cfg *config.UssdProxyConfig) ([]*providerBinding, error) {
+	bindingConfigs := cfg.Ussd.Bindings()
+	if len(bindingConfigs) < 1 {
+		return nil, fmt.Errorf("ussd.provider or ussd.providers is required")
+	}
+	bindings := make([]*providerBinding, 0, len(bindingConfigs))
+	for _, bindingConfig := range bindingConfigs {
+		provider, err := ussd.New(bindingConfig.Provider, bindingConfig.Options)
+		if err != nil {
+			return nil, fmt.Errorf("binding '%s': %v", bindingConfig.Name, err)
+		}
+		if bindingConfig.CallbackURL == "" {
+			return nil, fmt.Errorf("callback_url is required for binding '%s'", bindingConfig.Name)
+		}
+		bindings = append(bindings, &providerBinding{
+			name:        bindingConfig.Name,
+			callbackURL: bindingConfig.CallbackURL,
+			defaultApp:  bindingConfig.DefaultApp,
+			provider:    provider,
+		})
+	}
+	return bindings, nil
+}
+
+// sessionID namespaces the provider's session ID by the binding so session IDs
+// from different providers never collide in the session store
+func (b *providerBinding) sessionID(id string) string {
+	return b.name + ":" + id
+}
+
+// newCallbackVerifiers the callback verifier of each binding by name
+func newCallbackVerifiers(cfg *config.UssdProxyConfig) (map[string]*callbackVerifier, error) {
+	verifiers := make(map[string]*callbackVerifier)
+	for _, bindingConfig := range cfg.Ussd.Bindings() {
+		verifier, err := newCallbackVerifier(bindingConfig.Auth, cfg.Server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth configuration for binding '%s': %v", bindingConfig.Name, err)
+		}
+		verifiers[bindingConfig.Name] = verifier
+	}
+	return verifiers, nil
+}
+
+// bindingApplications the application serving each binding, which starts new
+// sessions with the binding's default app
+func (s *UssdProxyServer) bindingApplications(app *ussdproxy.MultiplexingApplication, apps []ussdproxy.UdcpApplication) map[string]*ussdproxy.MultiplexingApplication {
+	bindingApps := make(map[string]*ussdproxy.MultiplexingApplication)
+	for _, binding := range s.bindings {
+		bindingApps[binding.name] = app
+		if index, ok := s.appIndex(binding.defaultApp, apps); ok {
+			bindingApps[binding.name] = app.WithDefaultApplication(index)
+		}
+	}
+	return bindingApps
+}
+
+// routeApplication the application serving the request, the binding's
+// application unless the provider routed the request to an app in udcp.apps
+func (s *UssdProxyServer) routeApplication(binding *providerBinding, request ussdproxy.UssdRequestInterface) ussdproxy.UdcpApplication {
+	app := s.bindingApplication(binding)
+	name := request.Metadata()[ussdproxy.MetadataApp]
+	if name == "" {
+		return app
+	}
+	index, ok := s.appIndex(name, s.servedApplications())
+	if !ok {
+		s.logger.Warn("request routed to an app which is not configured", "binding", binding.name, "app", name)
+		return app
+	}
+	return app.WithDefaultApplication(index)
+}
+
+// appIndex the index of the named app of udcp.apps in apps, which are created in the order of udcp.apps
+func (s *UssdProxyServer) appIndex(name string, apps []ussdproxy.UdcpApplication) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	s.configMu.RLock()
+	appConfigs := s.Config.Udcp.Apps
+	s.configMu.RUnlock()
+	for i, appConfig := range appConfigs {
+		if appConfig.Name == name && i < len(apps) {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+// handler serves the callbacks of a USSD provider with net/http
+type handler struct {
+	processor *callbackProcessor
+	app       ussdproxy.UdcpApplication
+}
+
+// NewHandler creates an http.Handler which serves the callbacks of the provider
+// with the application, for mounting ussdproxy in a net/http service, e.g. chi
+//
+//	provider, _ := ussd.New("africastalking", nil)
+//	r.Post("/ussd/callback", server.NewHandler(provider, memory.New(), echo.NewEchoApplication()).ServeHTTP)
+func NewHandler(provider ussd.UssdProvider, sessions session.Store, app ussdproxy.UdcpApplication) http.Handler {
+	if _, ok := app.(ussdproxy.ApplicationSelector); !ok {
+		app = ussdproxy.NewMultiplexingApplication(app)
+	}
+	policy := newUdcpPolicy(handlerUdcpConfig)
+	h := &handler{app: policy.wrap(app)}
+	if notifier, ok := sessions.(session.EvictionNotifier); ok {
+		// clears the selection and the idle Receive Ready PDUs of evicted sessions
+		notifier.OnEvict(h.app.(ussdproxy.ApplicationSelector).ClearSelection)
+	}
+	h.processor = &callbackProcessor{
+		provider:  provider,
+		sessions:  sessions,
+		locks:     &sessionLocks{},
+		sessionID: providerSessionID,
+		route:     h.route,
+		logger:    hclog.Default().Named("ussdproxy"),
+	}
+	return h
+}
+
+// handlerUdcpConfig the udcp settings of handlers created by NewHandler
+func handlerUdcpConfig() config.UdcpConfig {
+	return config.UdcpConfig{
+		Commands: config.UdcpCommandsConfig{
+			QuerySessionID:         true,
+			QueryKeepAlive:         true,
+			QueryReceiveReadyLimit: true,
+			QueryMaxBufferSize:     true,
+		},
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := ussd.RequestFromHTTP(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.processor.process(request).WriteHTTP(w)
+}
+
+// route serves every request with the handler's application
+func (h *handler) route(ussdproxy.UssdRequestInterface) ussdproxy.UdcpApplication {
+	return h.app
+}
+
+// providerSessionID uses the provider's session ID as it is, for stores that
+// only hold the sessions of one provider
+func providerSessionID(id string) string {
+	return id
+}
@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nndi-oss/ussdproxy/app"
+	_ "github.com/nndi-oss/ussdproxy/app/echo"
+	_ "github.com/nndi-oss/ussdproxy/app/influx"
+	_ "github.com/nndi-oss/ussdproxy/app/mqtt"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/server"
+	"github.com/nndi-oss/ussdproxy/pkg/session/memory"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/truroute"
+)
+
+// callback sends a TruRoute callback to the handler and returns the message of the reply
+func callback(t *testing.T, handler http.Handler, messageType int, sessionID, message string) string {
+	t.Helper()
+	body := fmt.Sprintf("<ussd><type>%d</type><msg>%s</msg><sessionid>%s</sessionid><msisdn>265991234567</msisdn></ussd>", messageType, message, sessionID)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/ussd/callback", strings.NewReader(body)))
+	reply, _ := ioutil.ReadAll(recorder.Body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, reply)
+	}
+	start, end := strings.Index(string(reply), "<msg>"), strings.Index(string(reply), "</msg>")
+	if start < 0 || end < start {
+		t.Fatalf("expected a message in the reply, got %s", reply)
+	}
+	return string(reply[start+len("<msg>") : end])
+}
+
+// TestRegisteredApps every registered app processes requests without an
+// external system to connect to, replying with a PDU
+func TestRegisteredApps(t *testing.T) {
+	for _, name := range app.Registered() {
+		t.Run(name, func(t *testing.T) {
+			application, err := app.New(name, nil)
+			if err != nil {
+				t.Fatalf("failed to create the app: %v", err)
+			}
+			handler := server.NewHandler(truroute.New(), memory.New(), application)
+			for _, message := range []string{"*1234#", "D;temp:38.29|tag_meter_no:ABCD", "R;__NODATA__"} {
+				messageType := 2
+				if strings.HasPrefix(message, "*") {
+					messageType = 1
+				}
+				reply := callback(t, handler, messageType, "1234", message)
+				if _, _, err := ussdproxy.Decode([]byte(reply)); err != nil {
+					t.Errorf("expected a PDU in reply to %s, got %q: %v", message, reply, err)
+				}
+			}
+		})
+	}
+}
+
+// TestConcurrentSessions the data of each session is echoed back in chunks
+// while the requests of other sessions are processed
+func TestConcurrentSessions(t *testing.T) {
+	application, err := app.New("echo", nil)
+	if err != nil {
+		t.Fatalf("failed to create the app: %v", err)
+	}
+	handler := server.NewHandler(truroute.New(), memory.New(), application)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(sessionID string) {
+			defer wg.Done()
+			data := strings.Repeat(sessionID, 200/len(sessionID))
+			callback(t, handler, 1, sessionID, "*1234#")
+			if reply := callback(t, handler, 2, sessionID, "D;"+data); reply != "d;"+data[:ussdproxy.MaxDataLength] {
+				t.Errorf("session %s: expected the first chunk, got %q", sessionID, reply)
+			}
+			if reply := callback(t, handler, 2, sessionID, "R;__NODATA__"); reply != "D;"+data[ussdproxy.MaxDataLength:] {
+				t.Errorf("session %s: expected the last chunk, got %q", sessionID, reply)
+			}
+		}(fmt.Sprintf("session%02d", i))
+	}
+	wg.Wait()
+}
+
+// TestNewDialogueClearsSelection a dialogue started with the same session ID
+// starts with the default application, not the one selected before
+func TestNewDialogueClearsSelection(t *testing.T) {
+	echoApp, _ := app.New("echo", nil)
+	influxApp, _ := app.New("influx", nil)
+	handler := server.NewHandler(truroute.New(), memory.New(), ussdproxy.NewMultiplexingApplication(echoApp, influxApp))
+	callback(t, handler, 1, "1234", "*1234#")
+	if reply := callback(t, handler, 2, "1234", "A;application=influxdb"); reply != "R;__NODATA__" {
+		t.Fatalf("expected the app to be selected, got %q", reply)
+	}
+	if reply := callback(t, handler, 2, "1234", "Q;q:app"); reply != "D;influxdb" {
+		t.Fatalf("expected the selected app, got %q", reply)
+	}
+	callback(t, handler, 1, "1234", "*1234#")
+	if reply := callback(t, handler, 2, "1234", "Q;q:app"); reply != "D;echo" {
+		t.Fatalf("expected the default app in the new dialogue, got %q", reply)
+	}
+}
+
+// TestHandlerServesApplicationLikeTheServer the application is served by a
+// MultiplexingApplication, so clients can select it and query the server
+func TestHandlerServesApplicationLikeTheServer(t *testing.T) {
+	application, _ := app.New("echo", nil)
+	handler := server.NewHandler(truroute.New(), memory.New(), application)
+	steps := []struct {
+		message  string
+		expected string
+	}{
+		{"A;application=echo", "R;__NODATA__"},
+		{"A;application=mqtt", "E;6a application 'mqtt' is not available"},
+		{"Q;q:apps", "D;echo"},
+		{"Q;q:app", "D;echo"},
+		{"Q;q:sessID", "D;1234"},
+		{"C;c:app id:echo", "R;__NODATA__"},
+		{"D;hello", "D;hello"},
+		{"X;", "X;"},
+	}
+	callback(t, handler, 1, "1234", "*1234#")
+	for _, step := range steps {
+		reply := strings.ReplaceAll(callback(t, handler, 2, "1234", step.message), "&#39;", "'")
+		if reply != step.expected {
+			t.Errorf("%s: expected %q, got %q", step.message, step.expected, reply)
+		}
+	}
+}
+
+func TestHandlerRejectsInvalidRequests(t *testing.T) {
+	application, _ := app.New("echo", nil)
+	handler := server.NewHandler(truroute.New(), memory.New(), application)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ussd/callback", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected status 405, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/ussd/callback", strings.NewReader("not xml")))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid body: expected status 400, got %d", recorder.Code)
+	}
+}
@@ -7,7 +7,8 @@ import (
 )
 
 type healthcheck struct {
-	Status string `json:"status"`
+	Status string            `json:"status"`
+	Apps   map[string]string `json:"apps,omitempty"`
 }
 
 func (h healthcheck) JSON() ([]byte, error) {
@@ -24,10 +25,34 @@ func unhealthy() healthcheck {
 	}
 }
 
+// degraded the server is up but some applications are not ready
+func degraded() healthcheck {
+	return healthcheck{
+		Status: "DEGRADED",
+	}
+}
+
+func (s *UssdProxyServer) healthcheck() healthcheck {
+	_, supervisor := s.application()
+	if supervisor == nil {
+		return healthy()
+	}
+	h := healthy()
+	if !supervisor.Ready() {
+		h = degraded()
+	}
+	h.Apps = make(map[string]string)
+	for _, status := range supervisor.Status() {
+		h.Apps[status.ID] = status.State
+	}
+	return h
+}
+
 func (s *UssdProxyServer) healthcheckHandler(ctx *fasthttp.RequestCtx) {
-	data, err := healthy().JSON()
+	data, err := s.healthcheck().JSON()
 	if err != nil {
 		panic(err)
 	}
+	ctx.SetContentType("application/json; charset=utf-8")
 	ctx.Write(data)
 }
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+)
+
+const (
+	DefaultAppRestartBackoff    = 1 * time.Second
+	DefaultAppMaxRestartBackoff = 1 * time.Minute
+	DefaultAppReadinessInterval = 15 * time.Second
+)
+
+// supervisedApplication tracks the lifecycle state of an application on behalf
+// of the server. Requests are only routed to it while it is Ready
+type supervisedApplication struct {
+	ussdproxy.UdcpApplication
+
+	mu       sync.RWMutex
+	state    ussdproxy.ApplicationState
+	lastErr  error
+	restarts int
+
+	started bool          // whether a supervisor started the application, see appSupervisor.Start
+	stop    chan struct{} // closed to stop supervising the application
+	done    chan struct{} // closed once the application is no longer supervised
+}
+
+// CurrentState the state of the application as observed by the server
+func (a *supervisedApplication) CurrentState(string) ussdproxy.ApplicationState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.state
+}
+
+func (a *supervisedApplication) setState(state ussdproxy.ApplicationState, err error) {
+	a.mu.Lock()
+	a.state = state
+	a.lastErr = err
+	a.mu.Unlock()
+}
+
+// Shutdown calls the ShutdownHook of the application if it has one
+func (a *supervisedApplication) Shutdown(ctx context.Context) error {
+	if hook, ok := a.UdcpApplication.(ussdproxy.ShutdownHook); ok {
+		return hook.Shutdown(ctx)
+	}
+	return nil
+}
+
+// Query forwards the query to the application if it handles operations
+func (a *supervisedApplication) Query(op ussdproxy.Operation, session ussdproxy.Session) (string, error) {
+	if handler, ok := a.UdcpApplication.(ussdproxy.OperationHandler); ok {
+		return handler.Query(op, session)
+	}
+	return "", ussdproxy.ErrUnknownOperation
+}
+
+// Command forwards the command to the application if it handles operations
+func (a *supervisedApplication) Command(op ussdproxy.Operation, session ussdproxy.Session) (string, error) {
+	if handler, ok := a.UdcpApplication.(ussdproxy.OperationHandler); ok {
+		return handler.Command(op, session)
+	}
+	return "", ussdproxy.ErrUnknownOperation
+}
+
+type appStatus struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Author   string `json:"author"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+	Restarts int    `json:"restarts"`
+}
+
+func (a *supervisedApplication) status() appStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	status := appStatus{
+		ID:       a.ApplicationID(),
+		Name:     a.Name(),
+		Author:   a.Author(),
+		State:    a.state.String(),
+		Restarts: a.restarts,
+	}
+	if a.lastErr != nil {
+		status.Error = a.lastErr.Error()
+	}
+	return status
+}
+
+// appSupervisor initializes applications, checks that they remain ready and
+// restarts them with exponential backoff when they fail
+type appSupervisor struct {
+	logger hclog.Logger
+	apps   []*supervisedApplication
+
+	restartBackoff    time.Duration
+	maxRestartBackoff time.Duration
+	readinessInterval time.Duration
+}
+
+func newAppSupervisor(logger hclog.Logger, apps ...ussdproxy.UdcpApplication) *appSupervisor {
+	supervisor := &appSupervisor{
+		logger:            logger.Named("apps"),
+		restartBackoff:    DefaultAppRestartBackoff,
+		maxRestartBackoff: DefaultAppMaxRestartBackoff,
+		readinessInterval: DefaultAppReadinessInterval,
+	}
+	for _, app := range apps {
+		supervisor.apps = append(supervisor.apps, newSupervisedApplication(app))
+	}
+	return supervisor
+}
+
+func newSupervisedApplication(app ussdproxy.UdcpApplication) *supervisedApplication {
+	return &supervisedApplication{
+		UdcpApplication: app,
+		state:           ussdproxy.ApplicationInitializing,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+}
+
+// replace returns a supervisor for apps which keeps supervising the
+// applications it already supervises, and the applications which are no longer
+// among apps so they can be stopped
+func (s *appSupervisor) replace(apps ...ussdproxy.UdcpApplication) (*appSupervisor, []*supervisedApplication) {
+	supervisor := &appSupervisor{
+		logger:            s.logger,
+		restartBackoff:    s.restartBackoff,
+		maxRestartBackoff: s.maxRestartBackoff,
+		readinessInterval: s.readinessInterval,
+	}
+	kept := make(map[*supervisedApplication]bool)
+	for _, app := range apps {
+		supervised := newSupervisedApplication(app)
+		for _, current := range s.apps {
+			if current.UdcpApplication == app && !kept[current] {
+				supervised = current
+				kept[current] = true
+				break
+			}
+		}
+		supervisor.apps = append(supervisor.apps, supervised)
+	}
+	var removed []*supervisedApplication
+	for _, current := range s.apps {
+		if !kept[current] {
+			removed = append(removed, current)
+		}
+	}
+	return supervisor, removed
+}
+
+// Applications the supervised applications, to be registered with the MultiplexingApplication
+func (s *appSupervisor) Applications() []ussdproxy.UdcpApplication {
+	apps := make([]ussdproxy.UdcpApplication, len(s.apps))
+	for i, app := range s.apps {
+		apps[i] = app
+	}
+	return apps
+}
+
+// Status reports the state of every supervised application
+func (s *appSupervisor) Status() []appStatus {
+	statuses := make([]appStatus, len(s.apps))
+	for i, app := range s.apps {
+		statuses[i] = app.status()
+	}
+	return statuses
+}
+
+// Ready whether all applications are ready to process requests
+func (s *appSupervisor) Ready() bool {
+	for _, app := range s.apps {
+		if app.CurrentState("") != ussdproxy.ApplicationReady {
+			return false
+		}
+	}
+	return true
+}
+
+// Start registers the applications which were not started yet and starts
+// managing their lifecycle in the background
+func (s *appSupervisor) Start() {
+	for _, app := range s.apps {
+		if app.started {
+			continue
+		}
+		app.started = true
+		app.Register()
+		go s.supervise(app)
+	}
+}
+
+func (s *appSupervisor) supervise(app *supervisedApplication) {
+	defer close(app.done)
+
+	lifecycle, ok := app.UdcpApplication.(ussdproxy.ApplicationLifecycle)
+	if !ok {
+		// nothing to initialize, the application can process requests right away
+		app.setState(ussdproxy.ApplicationReady, nil)
+		return
+	}
+
+	backoff := s.restartBackoff
+	for {
+		app.setState(ussdproxy.ApplicationInitializing, nil)
+		err := lifecycle.Init()
+		if err == nil {
+			app.setState(ussdproxy.ApplicationInitialized, nil)
+			err = lifecycle.Ready()
+		}
+		if err == nil {
+			app.setState(ussdproxy.ApplicationReady, nil)
+			s.logger.Info("application is ready", "app", app.ApplicationID())
+			backoff = s.restartBackoff
+			err = s.waitUntilNotReady(app, lifecycle)
+			if err == nil {
+				// the supervisor is stopping
+				return
+			}
+		}
+
+		s.logger.Error("application is not ready, restarting", "app", app.ApplicationID(), "error", err, "backoff", backoff)
+		if stopErr := lifecycle.Stop(); stopErr != nil {
+			s.logger.Warn("failed to stop application", "app", app.ApplicationID(), "error", stopErr)
+		}
+		app.setState(ussdproxy.ApplicationStopped, err)
+
+		select {
+		case <-app.stop:
+			return
+		case <-time.After(backoff):
+		}
+		app.mu.Lock()
+		app.restarts++
+		app.mu.Unlock()
+		backoff *= 2
+		if backoff > s.maxRestartBackoff {
+			backoff = s.maxRestartBackoff
+		}
+	}
+}
+
+// waitUntilNotReady periodically checks readiness, it returns the error of the
+// failed check or nil if the supervisor was stopped
+func (s *appSupervisor) waitUntilNotReady(app *supervisedApplication, lifecycle ussdproxy.ApplicationLifecycle) error {
+	ticker := time.NewTicker(s.readinessInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.stop:
+			return nil
+		case <-ticker.C:
+			if err := lifecycle.Ready(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Stop stops supervising and calls Stop on the applications that implement ApplicationLifecycle
+func (s *appSupervisor) Stop(ctx context.Context) error {
+	return stopSupervised(ctx, s.apps)
+}
+
+// stopSupervised stops supervising the applications and calls Stop on the
+// applications that implement ApplicationLifecycle
+func stopSupervised(ctx context.Context, apps []*supervisedApplication) error {
+	for _, app := range apps {
+		close(app.stop)
+	}
+	for _, app := range apps {
+		if !app.started {
+			continue
+		}
+		select {
+		case <-app.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var firstErr error
+	for _, app := range apps {
+		if lifecycle, ok := app.UdcpApplication.(ussdproxy.ApplicationLifecycle); ok {
+			if err := lifecycle.Stop(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		app.setState(ussdproxy.ApplicationShutdown, nil)
+	}
+	return firstErr
+}
@@ -0,0 +1,41 @@
+package server
+
+import "sync"
+
+// sessionLocks serializes the requests of each session while requests of
+// different sessions are processed concurrently
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	sync.Mutex
+	waiters int // requests holding or waiting for the lock, it is removed at 0
+}
+
+// Lock locks the session and returns the function which unlocks it
+func (l *sessionLocks) Lock(sessionID string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sessionLock)
+	}
+	lock, ok := l.locks[sessionID]
+	if !ok {
+		lock = &sessionLock{}
+		l.locks[sessionID] = lock
+	}
+	lock.waiters++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(l.locks, sessionID)
+		}
+		l.mu.Unlock()
+	}
+}
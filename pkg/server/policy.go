@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+)
+
+// udcpPolicy applies the udcp settings of the configuration, which may change
+// on reload, to the requests of the applications it wraps: the receive ready
+// limit, the maximum buffer size, the enabled queries and the allowed devices
+type udcpPolicy struct {
+	settings func() config.UdcpConfig
+
+	mu   sync.Mutex
+	idle map[string]int // Receive Ready PDUs of each session answered without data in a row
+}
+
+func newUdcpPolicy(settings func() config.UdcpConfig) *udcpPolicy {
+	return &udcpPolicy{
+		settings: settings,
+		idle:     make(map[string]int),
+	}
+}
+
+// allowsDevice whether the device may use the server, every device may if
+// udcp.allowed_devices is empty
+func (p *udcpPolicy) allowsDevice(msisdn string) bool {
+	allowed := p.settings().AllowedDevices
+	if len(allowed) == 0 {
+		return true
+	}
+	msisdn = strings.TrimPrefix(msisdn, "+")
+	for _, device := range allowed {
+		if strings.TrimPrefix(device, "+") == msisdn {
+			return true
+		}
+	}
+	return false
+}
+
+// idleReceiveReady counts a Receive Ready PDU of the session answered without
+// data and returns how many were answered in a row
+func (p *udcpPolicy) idleReceiveReady(sessionID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[sessionID]++
+	return p.idle[sessionID]
+}
+
+func (p *udcpPolicy) resetIdle(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.idle, sessionID)
+}
+
+// wrap applies the policy to the requests of the application
+func (p *udcpPolicy) wrap(app ussdproxy.UdcpApplication) ussdproxy.UdcpApplication {
+	return &policyApplication{UdcpApplication: app, policy: p}
+}
+
+// policyApplication an application with the udcp settings applied to its requests
+type policyApplication struct {
+	ussdproxy.UdcpApplication
+	policy *udcpPolicy
+}
+
+// OnData rejects data which exceeds udcp.max_buffer_size
+func (a *policyApplication) OnData(request ussdproxy.UdcpRequest, session ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	a.policy.resetIdle(session.SessionID())
+	maxBufferSize := int(a.policy.settings().MaxBufferSize)
+	if size := session.RecvBuffer().Length(); maxBufferSize > 0 && size > maxBufferSize {
+		session.Reset()
+		message := fmt.Sprintf("%d bytes exceed the buffer size of %d bytes", size, maxBufferSize)
+		return ussdproxy.NewErrorResponseWithMessage(ussdproxy.ErrorCodeUnknownMask, message), nil
+	}
+	return a.UdcpApplication.OnData(request, session)
+}
+
+// OnReceiveReady releases the dialogue as idle once the application has
+// answered udcp.receive_ready_limit Receive Ready PDUs in a row without data
+func (a *policyApplication) OnReceiveReady(request ussdproxy.UdcpRequest, session ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	response, err := a.UdcpApplication.OnReceiveReady(request, session)
+	if err != nil || response == nil || !response.IsReceiveReadyPdu() {
+		a.policy.resetIdle(session.SessionID())
+		return response, err
+	}
+	limit := int(a.policy.settings().ReceiveReadyLimit)
+	if idle := a.policy.idleReceiveReady(session.SessionID()); limit > 0 && idle >= limit {
+		a.policy.resetIdle(session.SessionID())
+		return ussdproxy.NewReleaseDialogueResponse(ussdproxy.ReleaseCodeIdleDialogMask), nil
+	}
+	return response, nil
+}
+
+func (a *policyApplication) OnReleaseDialogue(request ussdproxy.UdcpRequest, session ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	a.policy.resetIdle(session.SessionID())
+	return a.UdcpApplication.OnReleaseDialogue(request, session)
+}
+
+// SelectApplication selects the application of the session if the wrapped application is an ApplicationSelector
+func (a *policyApplication) SelectApplication(session ussdproxy.Session, applicationID string) error {
+	selector, ok := a.UdcpApplication.(ussdproxy.ApplicationSelector)
+	if !ok {
+		return fmt.Errorf("application '%s' is not available", applicationID)
+	}
+	return selector.SelectApplication(session, applicationID)
+}
+
+// ClearSelection forgets the session, it was removed from the store
+func (a *policyApplication) ClearSelection(sessionID string) {
+	a.policy.resetIdle(sessionID)
+	if selector, ok := a.UdcpApplication.(ussdproxy.ApplicationSelector); ok {
+		selector.ClearSelection(sessionID)
+	}
+}
+
+// Query answers the queries of the session and buffer settings enabled in
+// udcp.commands, other queries are forwarded to the wrapped application
+//
+//	q:sessID      the ID of the session, with query_session_id
+//	q:keepAlive   udcp.keep_alive, with query_keep_alive
+//	q:rrLimit     udcp.receive_ready_limit, with query_receive_ready_limit
+//	q:bufMaxSize  udcp.max_buffer_size, with query_max_buffer_size
+func (a *policyApplication) Query(op ussdproxy.Operation, session ussdproxy.Session) (string, error) {
+	settings := a.policy.settings()
+	answer := func(enabled bool, result string) (string, error) {
+		if !enabled {
+			return "", fmt.Errorf("%s is disabled", op)
+		}
+		return result, nil
+	}
+	switch op.Name {
+	case "sessID":
+		return answer(settings.Commands.QuerySessionID, session.SessionID())
+	case "keepAlive":
+		return answer(settings.Commands.QueryKeepAlive, strconv.FormatBool(settings.KeepAlive))
+	case "rrLimit":
+		return answer(settings.Commands.QueryReceiveReadyLimit, strconv.Itoa(int(settings.ReceiveReadyLimit)))
+	case "bufMaxSize":
+		return answer(settings.Commands.QueryMaxBufferSize, strconv.Itoa(int(settings.MaxBufferSize)))
+	}
+	if handler, ok := a.UdcpApplication.(ussdproxy.OperationHandler); ok {
+		return handler.Query(op, session)
+	}
+	return "", ussdproxy.ErrUnknownOperation
+}
+
+// Command forwards the command to the wrapped application
+func (a *policyApplication) Command(op ussdproxy.Operation, session ussdproxy.Session) (string, error) {
+	if handler, ok := a.UdcpApplication.(ussdproxy.OperationHandler); ok {
+		return handler.Command(op, session)
+	}
+	return "", ussdproxy.ErrUnknownOperation
+}
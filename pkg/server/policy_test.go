@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nndi-oss/ussdproxy/app/echo"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+	"github.com/nndi-oss/ussdproxy/pkg/session/memory"
+)
+
+// exchange processes the requests in order and returns the encoded responses
+func exchange(t *testing.T, app ussdproxy.UdcpApplication, sess ussdproxy.Session, requests ...ussdproxy.UdcpRequest) []string {
+	t.Helper()
+	replies := make([]string, len(requests))
+	for i, request := range requests {
+		response, err := ussdproxy.ProcessSessionRequest(request, app, sess)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", request.ToString(), err)
+		}
+		replies[i] = string(ussdproxy.Encode(response))
+	}
+	return replies
+}
+
+func TestPolicyReceiveReadyLimit(t *testing.T) {
+	settings := config.UdcpConfig{ReceiveReadyLimit: 3}
+	policy := newUdcpPolicy(func() config.UdcpConfig { return settings })
+	app := policy.wrap(ussdproxy.NewMultiplexingApplication(echo.NewEchoApplication()))
+	sess := session.New("1234")
+
+	rr := ussdproxy.NewReceiveReadyRequest()
+	replies := exchange(t, app, sess, rr, rr, ussdproxy.NewDataRequest([]byte("hello"), false), rr, rr, rr)
+	expected := []string{"R;__NODATA__", "R;__NODATA__", "D;hello", "R;__NODATA__", "R;__NODATA__", "X;r:75"}
+	if strings.Join(replies, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected %v, got %v", expected, replies)
+	}
+
+	// the limit is read on every request, so a reload applies to live sessions
+	settings.ReceiveReadyLimit = 0
+	replies = exchange(t, app, sess, rr, rr, rr, rr)
+	if replies[3] != "R;__NODATA__" {
+		t.Fatalf("expected no limit, got %v", replies)
+	}
+}
+
+func TestPolicyMaxBufferSize(t *testing.T) {
+	settings := config.UdcpConfig{MaxBufferSize: 10}
+	policy := newUdcpPolicy(func() config.UdcpConfig { return settings })
+	app := policy.wrap(ussdproxy.NewMultiplexingApplication(echo.NewEchoApplication()))
+	sess := session.New("1234")
+
+	replies := exchange(t, app, sess,
+		ussdproxy.NewDataRequest([]byte("0123456789"), false),
+		ussdproxy.NewDataRequest([]byte("012345"), true),
+		ussdproxy.NewDataRequest([]byte("6789X"), false),
+	)
+	expected := []string{"D;0123456789", "R;__NODATA__", "E;66 11 bytes exceed the buffer size of 10 bytes"}
+	if strings.Join(replies, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, replies)
+	}
+	if !sess.RecvBuffer().IsEmpty() {
+		t.Fatal("expected the rejected data to be discarded")
+	}
+}
+
+func TestPolicyQueries(t *testing.T) {
+	settings := config.UdcpConfig{KeepAlive: true, ReceiveReadyLimit: 5, MaxBufferSize: 8096}
+	policy := newUdcpPolicy(func() config.UdcpConfig { return settings })
+	app := policy.wrap(ussdproxy.NewMultiplexingApplication(echo.NewEchoApplication()))
+	sess := session.New("1234")
+	query := func(q string) ussdproxy.UdcpRequest {
+		return ussdproxy.NewRequest(ussdproxy.QueryPduType, []byte(q))
+	}
+
+	replies := exchange(t, app, sess, query("q:sessID"), query("q:keepAlive"), query("q:rrLimit"), query("q:bufMaxSize"), query("q:apps"))
+	expected := []string{"E;67 q:sessID is disabled", "E;67 q:keepAlive is disabled", "E;67 q:rrLimit is disabled", "E;67 q:bufMaxSize is disabled", "D;echo"}
+	if strings.Join(replies, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, replies)
+	}
+
+	settings.Commands = config.UdcpCommandsConfig{QuerySessionID: true, QueryKeepAlive: true, QueryReceiveReadyLimit: true, QueryMaxBufferSize: true}
+	replies = exchange(t, app, sess, query("q:sessID"), query("q:keepAlive"), query("q:rrLimit"), query("q:bufMaxSize"))
+	expected = []string{"D;1234", "D;true", "D;5", "D;8096"}
+	if strings.Join(replies, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, replies)
+	}
+}
+
+func TestPolicyAllowsDevice(t *testing.T) {
+	settings := config.UdcpConfig{}
+	policy := newUdcpPolicy(func() config.UdcpConfig { return settings })
+	if !policy.allowsDevice("265991234567") {
+		t.Fatal("expected every device to be allowed without udcp.allowed_devices")
+	}
+	settings.AllowedDevices = []string{"+265991234567", "260971234567"}
+	testCases := map[string]bool{
+		"265991234567":  true,
+		"+265991234567": true,
+		"+260971234567": true,
+		"265881234567":  false,
+		"":              false,
+	}
+	for msisdn, expected := range testCases {
+		if got := policy.allowsDevice(msisdn); got != expected {
+			t.Errorf("allowsDevice(%q): expected %v, got %v", msisdn, expected, got)
+		}
+	}
+}
+
+func TestPolicyForgetsEvictedSessions(t *testing.T) {
+	cfg := &config.UssdProxyConfig{
+		Ussd: config.UssdConfig{Provider: "truroute", CallbackURL: "/ussd/callback"},
+		Udcp: config.UdcpConfig{ReceiveReadyLimit: 5, Session: config.SessionConfig{Driver: "memory"}},
+	}
+	s, err := NewUssdProxyServer(cfg)
+	if err != nil {
+		t.Fatalf("NewUssdProxyServer: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	s.startApplications(s.apps, s.appConfigs)
+	waitUntilReady(t, s)
+	mux, _ := s.application()
+	sess, _ := s.sessions.GetOrCreateSession("1234")
+
+	exchange(t, s.policy.wrap(mux), sess, ussdproxy.NewReceiveReadyRequest(), ussdproxy.NewReceiveReadyRequest())
+	s.sessions.(*memory.Store).Evict(time.Now().Add(memory.DefaultTTL + time.Minute))
+	s.policy.mu.Lock()
+	idle := len(s.policy.idle)
+	s.policy.mu.Unlock()
+	if idle != 0 {
+		t.Fatalf("expected the idle count of the evicted session to be removed, %d left", idle)
+	}
+}
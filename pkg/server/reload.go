@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nndi-oss/ussdproxy/app"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+)
+
+const (
+	// DefaultAppReloadTimeout how long replaced applications have to stop after a reload
+	DefaultAppReloadTimeout = 30 * time.Second
+)
+
+// application the application and supervisor currently serving requests
+func (s *UssdProxyServer) application() (*ussdproxy.MultiplexingApplication, *appSupervisor) {
+	s.appMu.RLock()
+	defer s.appMu.RUnlock()
+	return s.app, s.supervisor
+}
+
+// servedApplications the applications currently served
+func (s *UssdProxyServer) servedApplications() []ussdproxy.UdcpApplication {
+	s.appMu.RLock()
+	defer s.appMu.RUnlock()
+	return s.apps
+}
+
+// bindingApplication the application serving requests of the provider binding
+func (s *UssdProxyServer) bindingApplication(binding *providerBinding) *ussdproxy.MultiplexingApplication {
+	s.appMu.RLock()
+	defer s.appMu.RUnlock()
+	if app, ok := s.bindingApps[binding.name]; ok {
+		return app
+	}
+	return s.app
+}
+
+// startApplications starts supervising the given applications, created for
+// appConfigs, and routes new requests to them. Applications which are already
+// served keep running and sessions keep the application they selected. The
+// applications which are no longer served are returned so they can be stopped
+func (s *UssdProxyServer) startApplications(apps []ussdproxy.UdcpApplication, appConfigs []config.AppConfig) []*supervisedApplication {
+	s.appMu.Lock()
+	defer s.appMu.Unlock()
+	var removed []*supervisedApplication
+	supervisor := newAppSupervisor(s.logger, apps...)
+	if s.supervisor != nil {
+		supervisor, removed = s.supervisor.replace(apps...)
+	}
+	app := ussdproxy.NewMultiplexingApplication(supervisor.Applications()...)
+	if s.app != nil {
+		app = s.app.WithApplications(supervisor.Applications()...)
+	}
+	s.app, s.bindingApps, s.supervisor = app, s.bindingApplications(app, apps), supervisor
+	s.apps, s.appConfigs = apps, appConfigs
+	supervisor.Start()
+	return removed
+}
+
+// reloadedApplications the applications for appConfigs, nil if they are the
+// applications already served. Applications whose configuration did not change
+// are kept, the others are created
+func (s *UssdProxyServer) reloadedApplications(appConfigs []config.AppConfig) ([]ussdproxy.UdcpApplication, error) {
+	s.appMu.RLock()
+	currentApps, currentConfigs := s.apps, s.appConfigs
+	s.appMu.RUnlock()
+	appConfigs = append([]config.AppConfig{}, appConfigs...)
+	if currentConfigs != nil && reflect.DeepEqual(currentConfigs, appConfigs) {
+		return nil, nil
+	}
+	if len(appConfigs) == 0 {
+		return NewApplications(&config.UssdProxyConfig{})
+	}
+	kept := make([]bool, len(currentConfigs))
+	apps := make([]ussdproxy.UdcpApplication, 0, len(appConfigs))
+	for _, appConfig := range appConfigs {
+		var application ussdproxy.UdcpApplication
+		for i, current := range currentConfigs {
+			if !kept[i] && i < len(currentApps) && reflect.DeepEqual(current, appConfig) {
+				application, kept[i] = currentApps[i], true
+				break
+			}
+		}
+		if application == nil {
+			created, err := app.New(appConfig.Name, appConfig.Options)
+			if err != nil {
+				return nil, err
+			}
+			application = created
+		}
+		apps = append(apps, application)
+	}
+	return apps, nil
+}
+
+// stopReplacedApplications stops the applications which are no longer served
+// after a reload and calls their ShutdownHook
+func stopReplacedApplications(ctx context.Context, apps []*supervisedApplication) error {
+	stopErr := stopSupervised(ctx, apps)
+	for _, app := range apps {
+		if err := app.Shutdown(ctx); err != nil && stopErr == nil {
+			stopErr = fmt.Errorf("failed to shutdown %s: %v", app.ApplicationID(), err)
+		}
+	}
+	return stopErr
+}
+
+func stopApplications(ctx context.Context, app *ussdproxy.MultiplexingApplication, supervisor *appSupervisor) error {
+	var stopErr error
+	if supervisor != nil {
+		stopErr = supervisor.Stop(ctx)
+	}
+	if app != nil {
+		if err := app.Shutdown(ctx); err != nil && stopErr == nil {
+			stopErr = err
+		}
+	}
+	return stopErr
+}
+
+// Reload applies the settings of cfg that can change while the server is
+// running: logging.level, the auth of each binding, server.username and
+// server.password, and the udcp settings applied to each request, which are
+// receive_ready_limit, max_buffer_size, keep_alive (reported by q:keepAlive),
+// the queries enabled in commands and allowed_devices. The apps of udcp.apps
+// whose configuration changed are created again and the replaced applications
+// are stopped in the background, sessions keep the application they selected.
+//
+// The paths of changed settings which only take effect after a restart are returned
+func (s *UssdProxyServer) Reload(cfg *config.UssdProxyConfig) ([]string, error) {
+	s.configMu.RLock()
+	current := s.Config
+	s.configMu.RUnlock()
+	if current == nil {
+		return nil, fmt.Errorf("server has no configuration to reload")
+	}
+
+	// Settings which are read when the server starts are kept as they are
+	restartRequired := restartRequiredChanges(current, cfg)
+	next := *cfg
+	next.Server = current.Server
+	next.Server.Username, next.Server.Password = cfg.Server.Username, cfg.Server.Password
+	next.Ussd = reloadedUssdConfig(current.Ussd, cfg.Ussd)
+	next.Udcp.Session = current.Udcp.Session
+	next.Logging.Path = current.Logging.Path
+
+	verifiers, err := newCallbackVerifiers(&next)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := s.reloadedApplications(next.Udcp.Apps)
+	if err != nil {
+		return nil, err
+	}
+
+	s.configMu.Lock()
+	s.Config = &next
+	s.verifiers = verifiers
+	s.configMu.Unlock()
+
+	if next.Logging.Level != "" {
+		s.logger.SetLevel(hclog.LevelFromString(next.Logging.Level))
+	}
+
+	if apps != nil {
+		removed := s.startApplications(apps, append([]config.AppConfig{}, next.Udcp.Apps...))
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultAppReloadTimeout)
+			defer cancel()
+			if err := stopReplacedApplications(ctx, removed); err != nil {
+				s.logger.Error("failed to stop replaced applications", "error", err)
+			}
+		}()
+	}
+
+	s.logger.Info("reloaded configuration", "apps_replaced", apps != nil, "restart_required", restartRequired)
+	return restartRequired, nil
+}
+
+// restartRequiredChanges the paths of settings that differ between the
+// configurations but cannot be changed while the server is running
+func restartRequiredChanges(current, next *config.UssdProxyConfig) []string {
+	var changed []string
+	check := func(path string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, path)
+		}
+	}
+	check("server.host", current.Server.Host, next.Server.Host)
+	check("server.port", current.Server.Port, next.Server.Port)
+	check("server.name", current.Server.Name, next.Server.Name)
+	check("server.request_timeout", current.Server.RequestTimeout, next.Server.RequestTimeout)
+	check("server.tls", current.Server.TLS, next.Server.TLS)
+	check("ussd.provider", current.Ussd.Provider, next.Ussd.Provider)
+	check("ussd.callback_url", current.Ussd.CallbackURL, next.Ussd.CallbackURL)
+	check("ussd.providers", withoutAuth(current.Ussd.Providers), withoutAuth(next.Ussd.Providers))
+	check("udcp.session", current.Udcp.Session, next.Udcp.Session)
+	check("logging.log_file", current.Logging.Path, next.Logging.Path)
+	return changed
+}
+
+// reloadedUssdConfig the provider bindings of current with the auth settings of
+// next, the bindings themselves are only changed by a restart
+func reloadedUssdConfig(current, next config.UssdConfig) config.UssdConfig {
+	reloaded := current
+	reloaded.Auth = next.Auth
+	reloaded.Providers = make([]config.ProviderBindingConfig, len(current.Providers))
+	for i, binding := range current.Providers {
+		reloaded.Providers[i] = binding
+		for _, nextBinding := range next.Providers {
+			if nextBinding.Name == binding.Name {
+				reloaded.Providers[i].Auth = nextBinding.Auth
+			}
+		}
+	}
+	return reloaded
+}
+
+func withoutAuth(bindings []config.ProviderBindingConfig) []config.ProviderBindingConfig {
+	stripped := make([]config.ProviderBindingConfig, len(bindings))
+	for i, binding := range bindings {
+		binding.Auth = config.CallbackAuthConfig{}
+		stripped[i] = binding
+	}
+	return stripped
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nndi-oss/ussdproxy/app"
+	"github.com/nndi-oss/ussdproxy/app/echo"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+)
+
+// taggedApp replies to data with its ID and the tag of its configuration, so
+// replies tell apart the applications created by each reload
+type taggedApp struct {
+	ussdproxy.UdcpApplication
+	id, tag string
+}
+
+func (a *taggedApp) ApplicationID() string { return a.id }
+
+func (a *taggedApp) OnData(request ussdproxy.UdcpRequest, _ ussdproxy.Session) (ussdproxy.UdcpResponse, error) {
+	return ussdproxy.NewDataResponse(request, []byte(a.id+a.tag), false), nil
+}
+
+func init() {
+	app.Register("tagged", func(options map[string]interface{}) (ussdproxy.UdcpApplication, error) {
+		tagged := &taggedApp{UdcpApplication: echo.NewEchoApplication(), id: "tagged"}
+		if id, ok := options["id"]; ok {
+			tagged.id = fmt.Sprint(id)
+		}
+		tagged.tag = fmt.Sprint(options["tag"])
+		return tagged, nil
+	})
+}
+
+func taggedApps(tags ...string) []config.AppConfig {
+	apps := make([]config.AppConfig, len(tags))
+	for i, tag := range tags {
+		apps[i] = config.AppConfig{Name: "tagged", Options: map[string]interface{}{"id": string(rune('a' + i)), "tag": tag}}
+	}
+	return apps
+}
+
+// waitUntilReady waits for the supervisor to report the served applications ready
+func waitUntilReady(t *testing.T, s *UssdProxyServer) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, supervisor := s.application(); supervisor.Ready() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("applications did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadKeepsTheSelectedApplication(t *testing.T) {
+	cfg := &config.UssdProxyConfig{
+		Ussd: config.UssdConfig{Provider: "truroute", CallbackURL: "/ussd/callback"},
+		Udcp: config.UdcpConfig{Apps: taggedApps("1", "1"), Session: config.SessionConfig{Driver: "memory"}},
+	}
+	s, err := NewUssdProxyServer(cfg)
+	if err != nil {
+		t.Fatalf("NewUssdProxyServer: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	s.startApplications(s.apps, s.appConfigs)
+	waitUntilReady(t, s)
+	sess := session.New("1234")
+	send := func(pduType ussdproxy.PduType, data string) string {
+		mux, _ := s.application()
+		response, err := ussdproxy.ProcessSessionRequest(ussdproxy.NewRequest(pduType, []byte(data)), mux, sess)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", data, err)
+		}
+		return string(ussdproxy.Encode(response))
+	}
+
+	if reply := send(ussdproxy.CommandPduType, "c:app id:b"); reply != "R;__NODATA__" {
+		t.Fatalf("expected app b to be selected, got %s", reply)
+	}
+	if reply := send(ussdproxy.DataLongPduType, "hello"); reply != "D;b1" {
+		t.Fatalf("expected app b to reply, got %s", reply)
+	}
+	unchanged := s.servedApplications()[0]
+
+	// app b is created again with its new configuration, app a keeps running
+	next := *cfg
+	next.Udcp.Apps = taggedApps("1", "2")
+	if _, err := s.Reload(&next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	waitUntilReady(t, s)
+	if reply := send(ussdproxy.DataLongPduType, "hello"); reply != "D;b2" {
+		t.Fatalf("expected the session to stay with app b, got %s", reply)
+	}
+	if s.servedApplications()[0] != unchanged {
+		t.Fatal("expected the unchanged app to be kept")
+	}
+
+	// the session returns to the default app once its app is removed
+	next.Udcp.Apps = taggedApps("1")
+	if _, err := s.Reload(&next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	waitUntilReady(t, s)
+	if reply := send(ussdproxy.DataLongPduType, "hello"); reply != "D;a1" {
+		t.Fatalf("expected the default app, got %s", reply)
+	}
+}
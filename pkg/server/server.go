@@ -1,20 +1,29 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/fasthttp/router"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nndi-oss/ussdproxy/app"
 	"github.com/nndi-oss/ussdproxy/app/echo"
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
 	"github.com/nndi-oss/ussdproxy/pkg/config"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
 	"github.com/nndi-oss/ussdproxy/pkg/session/boltdb"
-	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/session/memory"
 	"github.com/valyala/fasthttp"
 )
 
 const (
-	DefaultServerRequestTimeout = 5_000
+	DefaultServerRequestTimeout = 5_000 // milliseconds
+	DefaultSessionStorePath     = "udcp.sessions"
+	DefaultIdleTimeout          = 30 * time.Second
 )
 
 // UssdProxyServer manages overall resources on the server side
@@ -37,58 +46,248 @@ const (
 //
 // * provides a UI for management/statistics?
 type UssdProxyServer struct {
-	sessionMu sync.Mutex // mutex for the buffer store
+	sessionLocks sessionLocks // serializes the requests of each session
+	policy       *udcpPolicy  // udcp settings applied to the requests of the applications
+	storeMu      sync.RWMutex // held for reading while a callback uses the session store
 
+	appMu          sync.RWMutex // guards app, bindingApps and supervisor which are replaced on reload
 	app            *ussdproxy.MultiplexingApplication
-	requestTimeout int // Seconds for the request to timeout
+	bindingApps    map[string]*ussdproxy.MultiplexingApplication // application serving each provider binding
+	apps           []ussdproxy.UdcpApplication                   // applications to serve, started by ListenAndServe
+	appConfigs     []config.AppConfig                            // udcp.apps the applications were created for, nil if set by UseApplications
+	supervisor     *appSupervisor
+	requestTimeout time.Duration
 
-	ussdReader ussd.UssdRequestReader
-	ussdWriter ussd.UssdResponseWriter
+	bindings []*providerBinding // USSD providers and the callback paths they are served on
 
-	Session ussdproxy.Session // session buffer for buffering request data
-	Config  *config.UssdProxyConfig
+	logger hclog.Logger
+
+	serverMu   sync.Mutex // guards httpServer
+	httpServer *fasthttp.Server
+
+	sessions session.Store // session buffers for buffering request data
+
+	configMu  sync.RWMutex                 // guards Config and verifiers which are replaced on reload
+	verifiers map[string]*callbackVerifier // callback verifier of each provider binding
+	Config    *config.UssdProxyConfig
+}
+
+// NewUssdProxyServer creates a server for the provider bindings, session store
+// and applications in the configuration
+func NewUssdProxyServer(cfg *config.UssdProxyConfig) (*UssdProxyServer, error) {
+	apps, err := NewApplications(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newUssdProxyServer(cfg, apps, append([]config.AppConfig{}, cfg.Udcp.Apps...))
 }
 
-func NewUssdProxyServer(configs ...config.UssdProxyConfig) *UssdProxyServer {
-	defaultConfig := &config.UssdProxyConfig{}
+// newUssdProxyServer creates a server for the provider bindings and session
+// store in the configuration which serves apps, created for appConfigs
+func newUssdProxyServer(cfg *config.UssdProxyConfig, apps []ussdproxy.UdcpApplication, appConfigs []config.AppConfig) (*UssdProxyServer, error) {
+	bindings, err := newProviderBindings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := newSessionStore(cfg.Udcp.Session)
+	if err != nil {
+		return nil, err
+	}
 
-	ussdProvider := defaultConfig.GetProvider()
+	requestTimeout := time.Duration(cfg.Server.RequestTimeout) * time.Millisecond
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultServerRequestTimeout * time.Millisecond
+	}
+
+	logger := hclog.Default().Named("ussdproxy")
+	if cfg.Logging.Level != "" {
+		logger.SetLevel(hclog.LevelFromString(cfg.Logging.Level))
+	}
 
-	return &UssdProxyServer{
-		app:            ussdproxy.NewMultiplexingApplication(echo.NewEchoApplication()),
-		requestTimeout: defaultConfig.Server.RequestTimeout,
-		ussdReader:     ussdProvider,
-		ussdWriter:     ussdProvider,
-		Session:        boltdb.GetOrCreateSession("test"),
+	s := &UssdProxyServer{
+		apps:           apps,
+		appConfigs:     appConfigs,
+		requestTimeout: requestTimeout,
+		bindings:       bindings,
+		sessions:       sessions,
+		logger:         logger,
+		Config:         cfg,
+	}
+	s.policy = newUdcpPolicy(s.udcpConfig)
+	if notifier, ok := sessions.(session.EvictionNotifier); ok {
+		notifier.OnEvict(s.sessionEvicted)
+	}
+	return s, nil
+}
+
+// sessionEvicted removes the application selected and the idle Receive Ready
+// PDUs counted for a session the store evicted without its dialogue ending
+func (s *UssdProxyServer) sessionEvicted(sessionID string) {
+	s.policy.resetIdle(sessionID)
+	if app, _ := s.application(); app != nil {
+		app.ClearSelection(sessionID)
 	}
 }
 
-func ListenAndServe(addr string, app ussdproxy.UdcpApplication) error {
-	s := NewUssdProxyServer()
-	s.app = ussdproxy.NewMultiplexingApplication(app)
-	fmt.Println("starting the application", app.Name())
-	return s.ListenAndServe(addr)
+// udcpConfig the udcp settings of the current configuration
+func (s *UssdProxyServer) udcpConfig() config.UdcpConfig {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.Config.Udcp
 }
 
-func (s *UssdProxyServer) parseUssdRequest(ctx *fasthttp.RequestCtx) (ussdproxy.UdcpRequest, error) {
-	return s.ussdReader.Read(ctx)
+// NewApplications creates the applications listed in udcp.apps, in order. The
+// packages of the applications must be imported so they are registered with the
+// app package. The echo application is served if no applications are configured
+func NewApplications(cfg *config.UssdProxyConfig) ([]ussdproxy.UdcpApplication, error) {
+	if len(cfg.Udcp.Apps) < 1 {
+		return []ussdproxy.UdcpApplication{echo.NewEchoApplication()}, nil
+	}
+	apps := make([]ussdproxy.UdcpApplication, 0, len(cfg.Udcp.Apps))
+	for _, appConfig := range cfg.Udcp.Apps {
+		application, err := app.New(appConfig.Name, appConfig.Options)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, application)
+	}
+	return apps, nil
 }
 
-func (s *UssdProxyServer) ListenAndServe(addr string) error {
+func newSessionStore(cfg config.SessionConfig) (session.Store, error) {
+	switch cfg.Driver {
+	case "", "boltdb":
+		path := cfg.Path
+		if path == "" {
+			path = DefaultSessionStorePath
+		}
+		store, err := boltdb.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open session store %s: %v", path, err)
+		}
+		return store, nil
+	case "memory":
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown session driver '%s'", cfg.Driver)
+	}
+}
+
+// UseApplications sets the applications served by the server, the first
+// application is the default application for new sessions
+func (s *UssdProxyServer) UseApplications(apps ...ussdproxy.UdcpApplication) {
+	if len(apps) < 1 {
+		panic("UseApplications: at least one application must be provided")
+	}
+	s.apps, s.appConfigs = apps, nil
+}
+
+// ListenAndServe serves the given application on the address in the
+// configuration, the applications of udcp.apps are not created
+func ListenAndServe(cfg *config.UssdProxyConfig, app ussdproxy.UdcpApplication) error {
+	s, err := newUssdProxyServer(cfg, []ussdproxy.UdcpApplication{app}, nil)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("starting the application", "app", app.Name())
+	return s.ListenAndServe()
+}
+
+// ListenAndServe serves callbacks on server.host and server.port until Shutdown is called
+func (s *UssdProxyServer) ListenAndServe() error {
+	addr := s.Config.Server.Addr()
+	verifiers, err := newCallbackVerifiers(s.Config)
+	if err != nil {
+		return err
+	}
+	s.configMu.Lock()
+	s.verifiers = verifiers
+	s.configMu.Unlock()
+
+	s.startApplications(s.apps, s.appConfigs)
+
 	r := router.New()
 
 	r.GET("/healthz", s.healthcheckHandler)
-	r.GET(s.Config.Ussd.CallbackURL, s.ussdCallbackHandler)
-	r.POST(s.Config.Ussd.CallbackURL, s.ussdCallbackHandler)
+	for _, binding := range s.bindings {
+		callbackHandler := s.ussdCallbackHandler(binding)
+		r.GET(binding.callbackURL, callbackHandler)
+		r.POST(binding.callbackURL, callbackHandler)
+	}
 	// TODO: Add telemetry stuff
 	r.GET("/metrics", s.notImplementedHandler)
 	// Admin routes, which need to be protected btw
-	r.GET("/admin/apps", s.notImplementedHandler)
+	r.GET("/admin/apps", s.appsHandler)
 	r.GET("/admin/sessions", s.notImplementedHandler)
 	r.GET("/admin/sessions/active", s.notImplementedHandler)
-	r.GET("/admin/sessions/active", s.notImplementedHandler)
 	r.GET("/admin/settings/udcp", s.notImplementedHandler)
 	r.GET("/admin/settings/apps", s.notImplementedHandler)
 
-	return fasthttp.ListenAndServe(addr, r.Handler)
+	httpServer := &fasthttp.Server{
+		Handler:      r.Handler,
+		ReadTimeout:  s.requestTimeout,
+		WriteTimeout: s.requestTimeout,
+		IdleTimeout:  DefaultIdleTimeout,
+	}
+	s.serverMu.Lock()
+	s.httpServer = httpServer
+	s.serverMu.Unlock()
+	if !s.Config.Server.TLS.Enabled() {
+		return httpServer.ListenAndServe(addr)
+	}
+
+	tlsConfig, reloader, err := newTLSConfig(s.Config.Server.TLS)
+	if err != nil {
+		return fmt.Errorf("invalid server.tls configuration: %v", err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go s.reloadCertificatesOnHangup(reloader)
+	return httpServer.Serve(tls.NewListener(ln, tlsConfig))
+}
+
+// Shutdown stops accepting callbacks and waits for in-flight requests to
+// complete until the context is done. It then shuts down the applications
+// and flushes the session store, even if draining requests timed out
+func (s *UssdProxyServer) Shutdown(ctx context.Context) error {
+	var shutdownErr error
+
+	s.serverMu.Lock()
+	httpServer := s.httpServer
+	s.serverMu.Unlock()
+	if httpServer != nil {
+		drained := make(chan error, 1)
+		go func() {
+			drained <- httpServer.Shutdown()
+		}()
+		select {
+		case err := <-drained:
+			shutdownErr = err
+		case <-ctx.Done():
+			s.logger.Warn("timed out waiting for in-flight requests to complete")
+			shutdownErr = ctx.Err()
+		}
+	}
+
+	app, supervisor := s.application()
+	if err := stopApplications(ctx, app, supervisor); err != nil {
+		s.logger.Error("failed to shutdown applications", "error", err)
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	// wait for callbacks using the session store before closing it
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	if err := s.sessions.Close(); err != nil {
+		s.logger.Error("failed to close session store", "error", err)
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
 }
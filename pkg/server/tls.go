@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/nndi-oss/ussdproxy/pkg/config"
+)
+
+// certificateReloader serves the server certificate and allows it to be
+// replaced while the server is running e.g. after a certificate renewal
+type certificateReloader struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate and key from disk. The current certificate
+// is kept if the files cannot be loaded
+func (r *certificateReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %v", r.certFile, err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, *certificateReloader, error) {
+	reloader, err := newCertificateReloader(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	clientAuth := strings.ToLower(cfg.ClientAuth)
+	if clientAuth == "" && cfg.CAStore != "" {
+		clientAuth = "require"
+	}
+	switch clientAuth {
+	case "", "none":
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case "request":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, nil, fmt.Errorf("unsupported client_auth '%s', expected one of none, request or require", cfg.ClientAuth)
+	}
+
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		if cfg.CAStore == "" {
+			return nil, nil, fmt.Errorf("client_auth '%s' requires a ca_store to verify client certificates", clientAuth)
+		}
+		pem, err := os.ReadFile(cfg.CAStore)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read ca_store: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in ca_store %s", cfg.CAStore)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, reloader, nil
+}
+
+// reloadCertificatesOnHangup reloads the server certificate each time the process receives SIGHUP
+func (s *UssdProxyServer) reloadCertificatesOnHangup(reloader *certificateReloader) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := reloader.Reload(); err != nil {
+			s.logger.Error("failed to reload TLS certificate, keeping the current certificate", "error", err)
+			continue
+		}
+		s.logger.Info("reloaded TLS certificate", "cert", reloader.certFile)
+	}
+}
@@ -2,83 +2,151 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"net/http"
 	"strings"
 
+	"github.com/hashicorp/go-hclog"
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
 	"github.com/valyala/fasthttp"
 )
 
-func (s *UssdProxyServer) ussdCallbackHandler(ctx *fasthttp.RequestCtx) {
-	// TODO: review how sessions are handled at a global level
-	s.sessionMu.Lock()
-	s.app.UseSession(s.Session) // use the session from the server
-	s.sessionMu.Unlock()
-	path := string(ctx.Path())
-	if strings.HasPrefix(path, "/healthcheck") {
-		b, err := json.Marshal(healthy())
-		if err != nil {
-			fmt.Println(fmt.Errorf("failed to marshal healthcheck. Error: %v", err))
-			ctx.WriteString(unhealthy().Status)
+// callbackHandler handles a callback from a USSD provider independent of the HTTP server
+type callbackHandler func(request *ussd.Request) *ussd.Response
+
+// fastHTTPHandler serves a callbackHandler with fasthttp
+func fastHTTPHandler(handler callbackHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		handler(ussd.RequestFromFastHTTP(ctx)).WriteFastHTTP(ctx)
+	}
+}
+
+// ussdCallbackHandler handles the callbacks of the provider binding
+func (s *UssdProxyServer) ussdCallbackHandler(binding *providerBinding) fasthttp.RequestHandler {
+	handler := fastHTTPHandler(s.verifyCallback(binding, func(request *ussd.Request) *ussd.Response {
+		return s.handleUssdCallback(binding, request)
+	}))
+	return func(ctx *fasthttp.RequestCtx) {
+		path := string(ctx.Path())
+		if strings.HasPrefix(path, "/healthcheck") {
+			b, err := json.Marshal(s.healthcheck())
+			if err != nil {
+				s.logger.Error("failed to marshal healthcheck", "error", err)
+				ctx.WriteString(unhealthy().Status)
+				return
+			}
+			ctx.Write(b)
+			ctx.SetContentType("application/json; charset=utf-8")
 			return
 		}
-		ctx.Write(b)
-		ctx.SetContentType("application/json; charset=utf-8")
-		return
-	}
-	method := string(ctx.Method())
-	if strings.Compare(method, "POST") != 0 {
-		ctx.SetStatusCode(405)
-		return
+		handler(ctx)
 	}
+}
 
-	request, err := s.parseUssdRequest(ctx)
-	if err != nil {
-		fmt.Println(fmt.Errorf("failed to parse ussd request, got %v", err))
-		// TODO: should this be a protocol error?
-		s.ussdWriter.WriteEnd(ussdproxy.NewProtocolErrorResponse(), ctx)
-		return
+func (s *UssdProxyServer) handleUssdCallback(binding *providerBinding, ussdRequest *ussd.Request) *ussd.Response {
+	// Shutdown takes the write lock to close the store once no callback is using it
+	s.storeMu.RLock()
+	defer s.storeMu.RUnlock()
+	processor := &callbackProcessor{
+		provider:    binding.provider,
+		sessions:    s.sessions,
+		locks:       &s.sessionLocks,
+		sessionID:   binding.sessionID,
+		allowDevice: s.policy.allowsDevice,
+		logger:      s.logger.With("binding", binding.name),
+		route: func(request ussdproxy.UssdRequestInterface) ussdproxy.UdcpApplication {
+			return s.policy.wrap(s.routeApplication(binding, request))
+		},
 	}
-	fmt.Println("Processing request ", request)
-	// done := make(chan struct{})
-	// requestDurationCtx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*30))
-	// defer cancel()
+	return processor.process(ussdRequest)
+}
 
-	// select {
-	// case <-done:
-	ussdAction := ussdproxy.UssdContinue
-	ctx.SetContentType(s.ussdWriter.GetContentType())
-	response, err := ussdproxy.ProcessUdcpRequest(request, s.app)
-	if err != nil {
-		fmt.Println(fmt.Errorf("failed to process request, got %v", err))
-		// TODO: wrap the error according to the type
-		s.ussdWriter.WriteEnd(ussdproxy.NewErrorResponse(ussdproxy.ErrorNotAsciiPduType), ctx)
-		// close(done)
-		return
-	}
+// callbackProcessor processes the callbacks of a USSD provider with the
+// application route selects for each request, buffering data in the session store
+type callbackProcessor struct {
+	provider  ussd.UssdProvider
+	sessions  session.Store
+	locks     *sessionLocks       // requests of a session are processed one at a time
+	sessionID func(string) string // maps the provider's session ID to the ID in the store
+	// allowDevice whether the device with the MSISDN may use the server, every device may if nil
+	allowDevice func(string) bool
+	route       func(ussdproxy.UssdRequestInterface) ussdproxy.UdcpApplication
+	logger      hclog.Logger
+}
 
-	if response == nil {
-		fmt.Println(fmt.Errorf("failed to process request, got %v response", err))
-		// TODO: should this be a protocol error?
-		s.ussdWriter.WriteEnd(ussdproxy.NewErrorResponse(ussdproxy.ErrorPduType), ctx)
-		// close(done)
-		return
+// process reads the UDCP request from the callback, processes it in the
+// session of the request and writes the application's response
+func (p *callbackProcessor) process(ussdRequest *ussd.Request) *ussd.Response {
+	ussdWriter := p.provider
+	ctx := ussd.NewResponse()
+	if !ussd.AllowsMethod(p.provider, ussdRequest.Method) {
+		ctx.StatusCode = http.StatusMethodNotAllowed
+		return ctx
 	}
 
-	if response.IsErrorPdu() || response.IsReleaseDialoguePdu() {
-		ussdAction = ussdproxy.UssdEnd
+	request, err := p.provider.Read(ussdRequest)
+	if err != nil {
+		p.logger.Warn("failed to parse ussd request", "error", err)
+		if errors.Is(err, ussd.ErrInvalidRequest) {
+			ctx.StatusCode = http.StatusBadRequest
+		}
+		ussdWriter.WriteEnd(ussdproxy.NewProtocolErrorResponse(), ctx)
+		return ctx
+	}
+	ctx.Header.Set("Content-Type", ussdWriter.GetContentType())
+	ctx.UssdRequest = request.UssdRequest()
+	if msisdn := request.UssdRequest().PhoneNumber(); p.allowDevice != nil && !p.allowDevice(msisdn) {
+		p.logger.Warn("rejected request from a device which is not allowed", "msisdn", msisdn)
+		ussdWriter.WriteEnd(ussdproxy.NewErrorResponseWithMessage(ussdproxy.ErrorCodeUnknownMask, "device is not allowed"), ctx)
+		return ctx
 	}
-	if ussdAction == ussdproxy.UssdEnd {
-		s.ussdWriter.WriteEnd(response, ctx)
-	} else {
-		s.ussdWriter.Write(response, ctx)
+	response := p.respond(request)
+	// the session is unlocked before the response is written, writers may send
+	// it to the provider e.g. through Kannel's sendsms interface
+	if _, err := ussd.WriteResponse(ussdWriter, response, ctx); err != nil {
+		p.logger.Error("failed to write response", "session", p.sessionID(request.UssdRequest().SessionID()), "error", err)
 	}
+	return ctx
+}
 
-	// close(done)
+// respond processes the request with the session locked and returns the
+// application's response, or an error response if it could not be processed
+func (p *callbackProcessor) respond(request ussdproxy.UdcpRequest) ussdproxy.UdcpResponse {
+	id := p.sessionID(request.UssdRequest().SessionID())
+	unlock := p.locks.Lock(id)
+	defer unlock()
 
-	// case <-requestDurationCtx.Done():
-	// 	fmt.Println("timeout exceeded for ussd request", request)
-	// 	s.ussdWriter.WriteEnd(ussdproxy.NewErrorResponse(ussdproxy.ReleaseDialogPduType), ctx)
-	// }
+	app := p.route(request.UssdRequest())
+	if request.UssdRequest().Initial() {
+		// the subscriber dialled again, data buffered for a previous dialogue with the same ID is discarded
+		p.deleteSession(id, app)
+	}
+	session, err := p.sessions.GetOrCreateSession(id)
+	if err != nil {
+		p.logger.Error("failed to get session", "session", id, "error", err)
+		return ussdproxy.NewProtocolErrorResponse()
+	}
+	response, err := ussdproxy.ProcessSessionRequest(request, app, session)
+	if err != nil || response == nil {
+		p.logger.Error("failed to process request", "session", id, "error", err)
+		return ussdproxy.NewErrorResponse(ussdproxy.ErrorCodeUnknownMask)
+	}
+	if response.Disposition() != ussdproxy.DispositionContinue {
+		// the dialogue has ended or moved to another service, the provider will not send more requests for the session
+		p.deleteSession(id, app)
+	}
+	return response
+}
 
+// deleteSession removes the session from the store and the application it
+// selected, so the next dialogue with the same ID starts with the default application
+func (p *callbackProcessor) deleteSession(id string, app ussdproxy.UdcpApplication) {
+	if selector, ok := app.(ussdproxy.ApplicationSelector); ok {
+		selector.ClearSelection(id)
+	}
+	if err := p.sessions.DeleteSession(id); err != nil {
+		p.logger.Error("failed to delete session", "session", id, "error", err)
+	}
 }
@@ -1,199 +1,246 @@
 package boltdb
 
-/// Buffer implementation for the protocol-level buffering on the
+/// Store implementation for the protocol-level buffering on the
 /// server side for UDCP sessions
 import (
-	"bytes"
-	"fmt"
-	"log"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/boltdb/bolt"
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
-	"github.com/valyala/bytebufferpool"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+	bolt "go.etcd.io/bbolt"
 )
 
-type session struct {
-	ussdproxy.Session
-	sessionID   string
-	isCommitted bool
-	recvBuffer  *sessionBuffer
-	sendBuffer  *sessionBuffer
-}
+var bucketKeyName = []byte("udcpSessions")
+
+const (
+	// DefaultFlushInterval how often changed buffers are written to the BoltDB file
+	DefaultFlushInterval = 1 * time.Second
+	// DefaultTTL how long a session is kept after its last request, USSD networks
+	// release idle dialogues within minutes so older sessions were abandoned
+	DefaultTTL = 30 * time.Minute
+)
 
-type sessionBuffer struct {
-	ussdproxy.Session
+// Store keeps sessions in memory and persists their buffers to a BoltDB file
+// every DefaultFlushInterval and on Close. Sessions without a request for
+// DefaultTTL are removed from memory and the file
+type Store struct {
+	mu       sync.Mutex
+	db       *bolt.DB
+	sessions map[string]*session.Session
+	lastUsed map[string]time.Time
+	onEvict  []func(sessionID string)
 
-	bucketKeyName []byte
-	dbPath        string
-	id            *string
-	offset        int32 // Offset is the position in the buffer we're at when reading/writing from the buffer
-	// TODO: how to handle concurrent access to boltdb?
-	db   *bolt.DB
-	data *bytebufferpool.ByteBuffer
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
-func getSessionStore(dbPath string) *bolt.DB {
-	db, err := bolt.Open(dbPath, 0600, nil)
+// Open opens (or creates) the BoltDB session store at dbPath
+func Open(dbPath string) (*Store, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return db
-}
-
-// GetOrCreateSession obtains or creates the session from the sessionStore (bolt or redis)
-func GetOrCreateSession(sessionID string) ussdproxy.Session {
-	bucketKeyName := []byte(sessionID)
-	var existingData []byte
-	db := getSessionStore(sessionID)
-	defer db.Close()
-	db.View(func(tx *bolt.Tx) error {
-		var b = tx.Bucket(bucketKeyName)
-		if b == nil {
-			// bucket doesn't exist yet
-			return nil
-		}
-		b = tx.Bucket(bucketKeyName)
-		existingData = b.Get([]byte(sessionID))
-		return nil
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketKeyName)
+		return err
 	})
-	if existingData == nil {
-		db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket(bucketKeyName)
-			var err error
-			if b == nil {
-				b, err = tx.CreateBucket(bucketKeyName)
-				if err != nil {
-					log.Fatalf("Failed to create bucket. %s", err)
-					return err
-				}
-			}
-			b.Put([]byte(sessionID), make([]byte, 0)) // empty session data
-			return nil
-		})
-		return &session{
-			sessionID:  sessionID,
-			recvBuffer: NewEmptySessionBuffer(&sessionID),
-			sendBuffer: NewEmptySessionBuffer(&sessionID),
-		}
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
-	return &session{
-		sessionID:  sessionID,
-		recvBuffer: NewSessionBuffer(&sessionID, existingData, db),
-		sendBuffer: NewSessionBuffer(&sessionID, existingData, db),
+	s := &Store{
+		db:       db,
+		sessions: make(map[string]*session.Session),
+		lastUsed: make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// run flushes the store and evicts expired sessions until the store is closed
+func (s *Store) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			// errors are returned again by Close, which flushes the same buffers
+			_ = s.Flush()
+			_ = s.Evict(now)
+		}
 	}
 }
 
-func (s *session) SessionID() string {
-	return s.sessionID
-}
-func (s *session) RecvBuffer() ussdproxy.SessionBuffer {
-	return s.recvBuffer
-}
-func (s *session) SendBuffer() ussdproxy.SessionBuffer {
-	return s.sendBuffer
-}
-
-func (s *session) IsOpen() bool {
-	return s.isCommitted == false
-}
-
-func (s *session) Reset() {
-	s.recvBuffer.Purge()
-	s.sendBuffer.Purge()
-	s.isCommitted = false
-}
-
-func (s *session) Close() {
-	s.isCommitted = true
+func recvKey(sessionID string) []byte {
+	return []byte(sessionID + "/recv")
 }
 
-func (s *session) Commit() {
-	s.isCommitted = true
+func sendKey(sessionID string) []byte {
+	return []byte(sessionID + "/send")
 }
 
-func NewEmptySessionBuffer(sessionID *string) *sessionBuffer {
-	return &sessionBuffer{
-		id:     sessionID,
-		offset: 0,
-		data:   bytebufferpool.Get(),
-	}
+func usedKey(sessionID string) []byte {
+	return []byte(sessionID + "/used")
 }
 
-func NewSessionBuffer(sessionID *string, data []byte, db *bolt.DB) *sessionBuffer {
-	s := &sessionBuffer{
-		bucketKeyName: []byte("udcpSessions"),
-		dbPath:        "udcp.sessions",
-		id:            sessionID,
-		offset:        0,
-		data:          bytebufferpool.Get(),
-		db:            db,
+// GetOrCreateSession obtains the session from memory or the BoltDB file, creating it if it doesn't exist
+func (s *Store) GetOrCreateSession(sessionID string) (ussdproxy.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed[sessionID] = time.Now()
+	if sess, ok := s.sessions[sessionID]; ok {
+		return sess, nil
 	}
-	s.data.Write(data)
-	return s
-}
-
-func (sb *sessionBuffer) Read() ([]byte, error) {
-	return sb.data.Bytes(), nil
-}
-
-func (sb *sessionBuffer) Write(data []byte) error {
-	defer sb.db.Close()
-	_, err := sb.data.Write(data)
+	var recv, send []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketKeyName)
+		// values are only valid for the life of the transaction so they must be copied
+		recv = append(recv, b.Get(recvKey(sessionID))...)
+		send = append(send, b.Get(sendKey(sessionID))...)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = sb.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(sb.bucketKeyName)
-		b.Put([]byte(*sb.id), sb.data.Bytes())
-		return nil
+	sess := session.Restore(sessionID, recv, send)
+	s.sessions[sessionID] = sess
+	return sess, nil
+}
+
+// DeleteSession removes the session from memory and the BoltDB file
+func (s *Store) DeleteSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+	delete(s.lastUsed, sessionID)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteSession(tx.Bucket(bucketKeyName), sessionID)
 	})
-	return err
 }
 
-func (sb *sessionBuffer) FillWith(buf ussdproxy.SessionBuffer) error {
-	data, err := buf.Read()
-	if err != nil {
-		return err
+func deleteSession(b *bolt.Bucket, sessionID string) error {
+	for _, key := range [][]byte{recvKey(sessionID), sendKey(sessionID), usedKey(sessionID)} {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
 	}
-	sb.data.Set(data)
 	return nil
 }
 
-func (sb *sessionBuffer) ReadAt(p []byte, offset int64) (int, error) {
-	r := bytes.NewReader(sb.data.Bytes())
-	return r.ReadAt(p, offset)
-}
-
-func (sb *sessionBuffer) Set(data []byte) error {
-	defer sb.db.Close()
-	bytebufferpool.Put(sb.data)
-	sb.data = bytebufferpool.Get()
-	sb.data.Write(data)
-	err := sb.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(sb.bucketKeyName)
-		b.Put([]byte(*sb.id), sb.data.Bytes())
+// Evict removes the sessions without a request for DefaultTTL before now from memory
+// and the BoltDB file, including sessions persisted by an earlier run
+func (s *Store) Evict(now time.Time) error {
+	s.mu.Lock()
+	expired := now.Add(-DefaultTTL)
+	var evict []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketKeyName)
+		err := b.ForEach(func(k, v []byte) error {
+			if !strings.HasSuffix(string(k), "/used") {
+				return nil
+			}
+			sessionID := strings.TrimSuffix(string(k), "/used")
+			if _, inMemory := s.lastUsed[sessionID]; !inMemory && len(v) == 8 &&
+				time.Unix(0, int64(binary.BigEndian.Uint64(v))).Before(expired) {
+				evict = append(evict, sessionID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for sessionID, used := range s.lastUsed {
+			if used.Before(expired) {
+				evict = append(evict, sessionID)
+			}
+		}
+		for _, sessionID := range evict {
+			delete(s.sessions, sessionID)
+			delete(s.lastUsed, sessionID)
+			if err := deleteSession(b, sessionID); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-	return err
+	onEvict := s.onEvict
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// called without the lock so the callbacks may use the store
+	for _, sessionID := range evict {
+		for _, fn := range onEvict {
+			fn(sessionID)
+		}
+	}
+	return nil
 }
 
-func (sb *sessionBuffer) Purge() {
-	db := sb.db
-	defer db.Close()
-	db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(sb.bucketKeyName)
-		b.Put([]byte(fmt.Sprintf("prev_%s", *sb.id)),
-			sb.data.Bytes())
-		b.Put([]byte(*sb.id), nil) // empty the session data
+// OnEvict registers fn to be called with the ID of every session removed by Evict
+func (s *Store) OnEvict(fn func(sessionID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvict = append(s.onEvict, fn)
+}
+
+// Flush writes the buffers of sessions that changed since the last flush to the BoltDB file
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketKeyName)
+		for sessionID, sess := range s.sessions {
+			recvBuffer, sendBuffer := sess.Buffers()
+			recvChanged, err := putBuffer(b, recvKey(sessionID), recvBuffer)
+			if err != nil {
+				return err
+			}
+			sendChanged, err := putBuffer(b, sendKey(sessionID), sendBuffer)
+			if err != nil {
+				return err
+			}
+			if recvChanged || sendChanged {
+				// the time of the last request, so the session expires after a restart
+				used := make([]byte, 8)
+				binary.BigEndian.PutUint64(used, uint64(s.lastUsed[sessionID].UnixNano()))
+				if err := b.Put(usedKey(sessionID), used); err != nil {
+					return err
+				}
+			}
+		}
 		return nil
 	})
-	bytebufferpool.Put(sb.data)
 }
 
-func (sb *sessionBuffer) IsEmpty() bool {
-	return sb.data.Len() < 1
+// putBuffer writes the buffer if it changed since the last flush and reports whether it did
+func putBuffer(b *bolt.Bucket, key []byte, buf *session.Buffer) (bool, error) {
+	data, dirty := buf.Snapshot()
+	if !dirty {
+		return false, nil
+	}
+	if len(data) == 0 {
+		return true, b.Delete(key)
+	}
+	return true, b.Put(key, data)
 }
 
-func (sb *sessionBuffer) Length() int {
-	return sb.data.Len()
+// Close stops the periodic flush, flushes all sessions and closes the BoltDB file
+func (s *Store) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+	if err := s.Flush(); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
 }
@@ -0,0 +1,128 @@
+package boltdb_test
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nndi-oss/ussdproxy/pkg/session/boltdb"
+)
+
+func TestBuffersArePersistedAndExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "udcp.sessions")
+	store, err := boltdb.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sess, err := store.GetOrCreateSession("1234")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession: %v", err)
+	}
+	sess.RecvBuffer().Write([]byte("temp:38.29"))
+	// the buffer is written by the periodic flush without closing the store
+	time.Sleep(boltdb.DefaultFlushInterval + 500*time.Millisecond)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	store, err = boltdb.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+	var evicted []string
+	store.OnEvict(func(sessionID string) { evicted = append(evicted, sessionID) })
+	if err := store.Evict(time.Now()); err != nil {
+		t.Fatalf("Evict: %v", err)
+	}
+	sess, _ = store.GetOrCreateSession("1234")
+	if data, _ := sess.RecvBuffer().Read(); string(data) != "temp:38.29" {
+		t.Fatalf("expected the persisted buffer, got %q", data)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("expected no evicted sessions, got %v", evicted)
+	}
+
+	if err := store.Evict(time.Now().Add(boltdb.DefaultTTL + time.Minute)); err != nil {
+		t.Fatalf("Evict: %v", err)
+	}
+	if len(evicted) != 1 || evicted[0] != "1234" {
+		t.Fatalf("expected session 1234 to be evicted, got %v", evicted)
+	}
+	sess, _ = store.GetOrCreateSession("1234")
+	if !sess.RecvBuffer().IsEmpty() {
+		t.Fatal("expected the expired session to be removed")
+	}
+}
+
+func TestSessionsPersistedByAnEarlierRunExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "udcp.sessions")
+	store, err := boltdb.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sess, _ := store.GetOrCreateSession("1234")
+	sess.SendBuffer().Write([]byte("pending"))
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	store, err = boltdb.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+	if err := store.Evict(time.Now().Add(boltdb.DefaultTTL + time.Minute)); err != nil {
+		t.Fatalf("Evict: %v", err)
+	}
+	sess, _ = store.GetOrCreateSession("1234")
+	if !sess.SendBuffer().IsEmpty() {
+		t.Fatal("expected the session of the earlier run to be removed")
+	}
+}
+
+// TestConcurrentFlushAndEvict the periodic flush and eviction run while the
+// buffers of sessions are used, run with -race
+func TestConcurrentFlushAndEvict(t *testing.T) {
+	store, err := boltdb.Open(filepath.Join(t.TempDir(), "udcp.sessions"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				sess, err := store.GetOrCreateSession(id)
+				if err != nil {
+					t.Errorf("GetOrCreateSession: %v", err)
+					return
+				}
+				sess.RecvBuffer().Write([]byte("data"))
+				sess.SendBuffer().Set([]byte("reply"))
+				sess.RecvBuffer().Read()
+				if j%50 == 49 {
+					store.DeleteSession(id)
+				}
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			if err := store.Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+			}
+			if err := store.Evict(time.Now()); err != nil {
+				t.Errorf("Evict: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,110 @@
+package memory
+
+/// Store implementation which keeps UDCP sessions in memory only,
+/// buffered data is lost when the server stops
+import (
+	"sync"
+	"time"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/session"
+)
+
+const (
+	// DefaultTTL how long a session is kept after its last request, USSD networks
+	// release idle dialogues within minutes so older sessions were abandoned
+	DefaultTTL = 30 * time.Minute
+	// DefaultEvictInterval how often GetOrCreateSession evicts expired sessions
+	DefaultEvictInterval = 1 * time.Minute
+)
+
+// Store keeps sessions in memory. Sessions without a request for DefaultTTL
+// are evicted by GetOrCreateSession every DefaultEvictInterval
+type Store struct {
+	mu        sync.Mutex
+	sessions  map[string]*session.Session
+	lastUsed  map[string]time.Time
+	lastEvict time.Time
+	onEvict   []func(sessionID string)
+}
+
+// New creates an empty in-memory session store
+func New() *Store {
+	return &Store{
+		sessions:  make(map[string]*session.Session),
+		lastUsed:  make(map[string]time.Time),
+		lastEvict: time.Now(),
+	}
+}
+
+// GetOrCreateSession obtains the session, creating it if it doesn't exist
+func (s *Store) GetOrCreateSession(sessionID string) (ussdproxy.Session, error) {
+	now := time.Now()
+	s.mu.Lock()
+	evict := now.Sub(s.lastEvict) >= DefaultEvictInterval
+	s.lastUsed[sessionID] = now
+	sess, ok := s.sessions[sessionID]
+	if !ok {
+		sess = session.New(sessionID)
+		s.sessions[sessionID] = sess
+	}
+	s.mu.Unlock()
+	if evict {
+		s.Evict(now)
+	}
+	return sess, nil
+}
+
+// DeleteSession removes the session
+func (s *Store) DeleteSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+	delete(s.lastUsed, sessionID)
+	return nil
+}
+
+// Evict removes the sessions without a request for DefaultTTL before now
+func (s *Store) Evict(now time.Time) error {
+	s.mu.Lock()
+	s.lastEvict = now
+	expired := now.Add(-DefaultTTL)
+	var evicted []string
+	for sessionID, used := range s.lastUsed {
+		if used.Before(expired) {
+			evicted = append(evicted, sessionID)
+			delete(s.sessions, sessionID)
+			delete(s.lastUsed, sessionID)
+		}
+	}
+	onEvict := s.onEvict
+	s.mu.Unlock()
+	// called without the lock so the callbacks may use the store
+	for _, sessionID := range evicted {
+		for _, fn := range onEvict {
+			fn(sessionID)
+		}
+	}
+	return nil
+}
+
+// OnEvict registers fn to be called with the ID of every session removed by Evict
+func (s *Store) OnEvict(fn func(sessionID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvict = append(s.onEvict, fn)
+}
+
+// Flush is a noop, sessions are only kept in memory
+func (s *Store) Flush() error {
+	return nil
+}
+
+// Close removes all sessions
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]*session.Session)
+	s.lastUsed = make(map[string]time.Time)
+	return nil
+}
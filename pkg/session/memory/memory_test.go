@@ -0,0 +1,29 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nndi-oss/ussdproxy/pkg/session/memory"
+)
+
+func TestExpiredSessionsAreEvicted(t *testing.T) {
+	store := memory.New()
+	var evicted []string
+	store.OnEvict(func(sessionID string) { evicted = append(evicted, sessionID) })
+	sess, _ := store.GetOrCreateSession("1234")
+	sess.RecvBuffer().Write([]byte("temp:38.29"))
+
+	store.Evict(time.Now())
+	if len(evicted) != 0 {
+		t.Fatalf("expected no evicted sessions, got %v", evicted)
+	}
+	store.Evict(time.Now().Add(memory.DefaultTTL + time.Minute))
+	if len(evicted) != 1 || evicted[0] != "1234" {
+		t.Fatalf("expected session 1234 to be evicted, got %v", evicted)
+	}
+	sess, _ = store.GetOrCreateSession("1234")
+	if !sess.RecvBuffer().IsEmpty() {
+		t.Fatal("expected the expired session to be removed")
+	}
+}
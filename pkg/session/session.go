@@ -1 +1,174 @@
+// session provides the storage of UDCP sessions and their buffers on the server side
 package session
+
+import (
+	"bytes"
+	"sync"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/valyala/bytebufferpool"
+)
+
+// Store keeps UDCP sessions so that data buffered by a client survives across
+// USSD requests and, for persistent stores, across server restarts
+type Store interface {
+	// GetOrCreateSession obtains the session with the given ID, creating it if it does not exist
+	GetOrCreateSession(sessionID string) (ussdproxy.Session, error)
+	// DeleteSession removes the session and its buffered data once the dialogue has ended
+	DeleteSession(sessionID string) error
+	// Flush persists any buffered session data
+	Flush() error
+	// Close flushes the store and releases any resources held by it
+	Close() error
+}
+
+// EvictionNotifier is implemented by stores which evict sessions that were
+// abandoned without their dialogue ending, so state kept for the sessions
+// outside the store can be removed with them
+type EvictionNotifier interface {
+	// OnEvict registers fn to be called with the ID of every evicted session
+	OnEvict(fn func(sessionID string))
+}
+
+// Session is an in-memory ussdproxy.Session which stores use to hold buffered data
+type Session struct {
+	mu          sync.Mutex
+	sessionID   string
+	isCommitted bool
+	recvBuffer  *Buffer
+	sendBuffer  *Buffer
+}
+
+// New creates an empty session
+func New(sessionID string) *Session {
+	return Restore(sessionID, nil, nil)
+}
+
+// Restore creates a session holding previously persisted buffer data
+func Restore(sessionID string, recv, send []byte) *Session {
+	return &Session{
+		sessionID:  sessionID,
+		recvBuffer: NewBuffer(recv),
+		sendBuffer: NewBuffer(send),
+	}
+}
+
+func (s *Session) SessionID() string {
+	return s.sessionID
+}
+
+func (s *Session) RecvBuffer() ussdproxy.SessionBuffer {
+	return s.recvBuffer
+}
+
+func (s *Session) SendBuffer() ussdproxy.SessionBuffer {
+	return s.sendBuffer
+}
+
+func (s *Session) IsOpen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.isCommitted
+}
+
+func (s *Session) Reset() {
+	s.recvBuffer.Purge()
+	s.sendBuffer.Purge()
+	s.mu.Lock()
+	s.isCommitted = false
+	s.mu.Unlock()
+}
+
+func (s *Session) Close() {
+	s.Commit()
+}
+
+func (s *Session) Commit() {
+	s.mu.Lock()
+	s.isCommitted = true
+	s.mu.Unlock()
+}
+
+// Buffer is an in-memory ussdproxy.SessionBuffer
+type Buffer struct {
+	mu    sync.Mutex
+	data  *bytebufferpool.ByteBuffer
+	dirty bool // whether the buffer changed since it was last persisted
+}
+
+// NewBuffer creates a buffer holding the given data
+func NewBuffer(data []byte) *Buffer {
+	b := &Buffer{
+		data: bytebufferpool.Get(),
+	}
+	b.data.Set(data)
+	return b
+}
+
+func (b *Buffer) Read() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.data.Bytes()...), nil
+}
+
+func (b *Buffer) ReadAt(p []byte, offset int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.NewReader(b.data.Bytes()).ReadAt(p, offset)
+}
+
+func (b *Buffer) Write(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.data.Write(data)
+	b.dirty = true
+	return err
+}
+
+func (b *Buffer) Set(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data.Set(data)
+	b.dirty = true
+	return nil
+}
+
+func (b *Buffer) FillWith(buf ussdproxy.SessionBuffer) error {
+	data, err := buf.Read()
+	if err != nil {
+		return err
+	}
+	return b.Set(data)
+}
+
+func (b *Buffer) Purge() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data.Reset()
+	b.dirty = true
+}
+
+func (b *Buffer) IsEmpty() bool {
+	return b.Length() < 1
+}
+
+func (b *Buffer) Length() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data.Len()
+}
+
+// Snapshot returns a copy of the buffer contents and whether they changed since
+// the last call to Snapshot. Persistent stores use it to write out changed buffers
+func (b *Buffer) Snapshot() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dirty := b.dirty
+	b.dirty = false
+	return append([]byte(nil), b.data.Bytes()...), dirty
+}
+
+// Buffers returns the receive and send buffers of the session
+func (s *Session) Buffers() (recv *Buffer, send *Buffer) {
+	return s.recvBuffer, s.sendBuffer
+}
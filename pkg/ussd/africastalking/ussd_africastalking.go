@@ -3,50 +3,179 @@ package africastalking
 import (
 	"bytes"
 	"fmt"
-	"io"
+	"strings"
+	"sync"
+	"time"
 
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
-	"github.com/valyala/fasthttp"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
 )
 
-type AfricasTalkingUssdHandler struct{}
+const (
+	// MetadataServiceCode is the Metadata key of the service code the subscriber dialled e.g. *384*1234#
+	MetadataServiceCode = "serviceCode"
+	// MetadataNetworkCode is the Metadata key of the code of the subscriber's mobile network e.g. 65001
+	MetadataNetworkCode = "networkCode"
 
-func New() *AfricasTalkingUssdHandler {
-	return &AfricasTalkingUssdHandler{}
+	// DialogueTTL is how long the text of a dialogue is kept after its last request,
+	// Africa's Talking ends sessions long before this
+	DialogueTTL = 5 * time.Minute
+
+	// hopSeparator joins the input of each hop of a dialogue in the text field
+	hopSeparator = "*"
+)
+
+// Config is the configuration of the africastalking provider's options
+type Config struct {
+	// ServiceCodes routes the requests of a service code to an app in udcp.apps e.g.
+	//
+	//	service_codes:
+	//	  "*384*1234#": mqtt
+	ServiceCodes map[string]string `mapstructure:"service_codes"`
+}
+
+// dialogue the text of the last request of a session
+type dialogue struct {
+	text    string
+	hop     string
+	updated time.Time
+}
+
+// AfricasTalkingUssdHandler reads the callbacks of Africa's Talking. It keeps
+// the text of each dialogue in memory to find the latest hop, so the callbacks
+// of a session must reach the same process. Behind more than one replica, route
+// the callbacks by sessionId (sticky sessions), a replica which has not seen
+// the dialogue falls back to the last hop starting with a PDU type
+type AfricasTalkingUssdHandler struct {
+	serviceCodes map[string]string
+
+	mu        sync.Mutex // guards dialogues and sweeper
+	dialogues map[string]*dialogue
+	sweeper   *time.Timer // removes expired dialogues, nil when there are none
 }
 
-func (u *AfricasTalkingUssdHandler) Read(ctx *fasthttp.RequestCtx) (ussdproxy.UdcpRequest, error) {
+func New() *AfricasTalkingUssdHandler {
+	return NewWithConfig(Config{})
+}
 
-	requestData := ctx.FormValue("text")
-	if requestData == nil {
-		requestData = []byte("R;__NODATA__") // if the request is empty, we default to a receive-ready
+// NewWithConfig creates the provider with its options
+func NewWithConfig(cfg Config) *AfricasTalkingUssdHandler {
+	return &AfricasTalkingUssdHandler{
+		serviceCodes: cfg.ServiceCodes,
+		dialogues:    make(map[string]*dialogue),
 	}
+}
 
-	if ctx.FormValue("phoneNumber") == nil || ctx.FormValue("sessionId") == nil {
-		ctx.SetStatusCode(400)
-		return nil, fmt.Errorf("invalid request, got body: %s", string(ctx.Request.Body()))
+func init() {
+	ussd.Register("africastalking", func(options map[string]interface{}) (ussd.UssdProvider, error) {
+		cfg := Config{}
+		if err := ussd.DecodeOptions(options, &cfg); err != nil {
+			return nil, err
+		}
+		return NewWithConfig(cfg), nil
+	})
+}
+
+// Read reads the latest hop of the dialogue. Africa's Talking sends the input
+// of every hop of the dialogue in text, joined with '*', and an empty text
+// when the subscriber dials the service code
+func (u *AfricasTalkingUssdHandler) Read(request *ussd.Request) (ussdproxy.UdcpRequest, error) {
+	phoneNumber, hasPhoneNumber := request.LookupFormValue("phoneNumber")
+	sessionID, hasSessionID := request.LookupFormValue("sessionId")
+	if !hasPhoneNumber || !hasSessionID {
+		return nil, fmt.Errorf("%w, got body: %s", ussd.ErrInvalidRequest, string(request.Body))
 	}
 
+	text := request.FormValue("text")
+	serviceCode := request.FormValue("serviceCode")
 	return parseUssdRequest(&UssdRequest{
-		SessionID:   string(ctx.FormValue("sessionId")),
-		PhoneNumber: string(ctx.FormValue("phoneNumber")),
-		Data:        []byte(requestData),
-		Channel:     string(ctx.FormValue("channel")),
+		SessionID:   sessionID,
+		PhoneNumber: phoneNumber,
+		Data:        []byte(u.latestHop(sessionID, text)),
+		Channel:     request.FormValue("channel"),
+		ServiceCode: serviceCode,
+		NetworkCode: request.FormValue("networkCode"),
+		App:         u.serviceCodes[serviceCode],
+		Initial:     text == "",
 	})
 }
 
+// latestHop the input of the latest hop of the session's dialogue in text
+func (u *AfricasTalkingUssdHandler) latestHop(sessionID, text string) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	previous, ok := u.dialogues[sessionID]
+	hop := text
+	switch {
+	case text == "":
+	case ok && text == previous.text:
+		// the request was retried
+		hop = previous.hop
+	case ok && strings.HasPrefix(text, previous.text+hopSeparator):
+		hop = text[len(previous.text)+len(hopSeparator):]
+	case ok && previous.text == "":
+		// the first hop after dialling the service code
+	default:
+		// the previous text is unknown e.g. the proxy restarted during the dialogue,
+		// the latest hop starts after the last separator followed by a PDU type
+		hop = lastPdu(text)
+	}
+	u.dialogues[sessionID] = &dialogue{text: text, hop: hop, updated: now}
+	if u.sweeper == nil {
+		u.sweeper = time.AfterFunc(DialogueTTL, u.sweep)
+	}
+	return hop
+}
+
+// sweep removes the dialogues which expired, and runs again while there are
+// dialogues left
+func (u *AfricasTalkingUssdHandler) sweep() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	for id, d := range u.dialogues {
+		if now.Sub(d.updated) > DialogueTTL {
+			delete(u.dialogues, id)
+		}
+	}
+	u.sweeper = nil
+	if len(u.dialogues) > 0 {
+		u.sweeper = time.AfterFunc(DialogueTTL, u.sweep)
+	}
+}
+
+// lastPdu the text from the last separator which is followed by a PDU type,
+// the whole text if there is none
+func lastPdu(text string) string {
+	for i := strings.LastIndex(text, hopSeparator); i >= 0; i = strings.LastIndex(text[:i], hopSeparator) {
+		hop := text[i+len(hopSeparator):]
+		if len(hop) >= 2 && ussdproxy.RequestPduType(hop[:2]) != ussdproxy.InvalidPduType {
+			return hop
+		}
+	}
+	return text
+}
+
 func (u *AfricasTalkingUssdHandler) GetContentType() string {
 	return "text/plain; charset=ascii"
 }
 
-func (u *AfricasTalkingUssdHandler) Write(response ussdproxy.UdcpResponse, writer io.Writer) (int, error) {
+func (u *AfricasTalkingUssdHandler) Write(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
 	writer.Write([]byte("CON\n"))
-	return writer.Write(response.Data())
+	return writer.Write(ussdproxy.Encode(response))
 }
 
-func (u *AfricasTalkingUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer io.Writer) (int, error) {
+func (u *AfricasTalkingUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	if writer.UssdRequest != nil {
+		// the session has ended, its text is no longer needed
+		u.mu.Lock()
+		delete(u.dialogues, writer.UssdRequest.SessionID())
+		u.mu.Unlock()
+	}
 	writer.Write([]byte("END\n"))
-	return writer.Write(response.Data())
+	return writer.Write(ussdproxy.Encode(response))
 }
 
 // UssdRequest struct represents a request coming in from the Network
@@ -56,6 +185,10 @@ type UssdRequest struct {
 	Data        []byte `json:"name=text"`
 	SessionID   string `json:"name=sessionId"`
 	Channel     string `json:"name=channel,omitempty"`
+	ServiceCode string `json:"name=serviceCode,omitempty"`
+	NetworkCode string `json:"name=networkCode,omitempty"`
+	App         string `json:"-"` // App the service code is routed to
+	Initial     bool   `json:"-"` // Whether the subscriber dialled the service code
 }
 
 // Bytes Converts the UssdRequest to a byte array with the data is separated by 0x0
@@ -74,13 +207,26 @@ func (u *UssdRequest) Bytes() []byte {
 }
 
 func parseUssdRequest(ussdRequest *UssdRequest) (ussdproxy.UdcpRequest, error) {
-	ussdData := ussdRequest.Data
-	typ := ussdproxy.RequestPduType(string(ussdData[0:2]))
-	if typ == ussdproxy.InvalidPduType {
-		return nil, fmt.Errorf("%v got '%v'", ussdproxy.ErrInvalidHeader, typ)
+	newUssdRequest := ussdproxy.NewUssdRequest
+	if ussdRequest.Initial {
+		newUssdRequest = ussdproxy.NewInitialUssdRequest
+	}
+	request := newUssdRequest(
+		"africastalking",
+		ussdRequest.SessionID,
+		ussdRequest.PhoneNumber,
+		ussdRequest.Channel,
+		ussdRequest.Data,
+	)
+	metadata := request.Metadata()
+	if ussdRequest.ServiceCode != "" {
+		metadata[MetadataServiceCode] = ussdRequest.ServiceCode
+	}
+	if ussdRequest.NetworkCode != "" {
+		metadata[MetadataNetworkCode] = ussdRequest.NetworkCode
+	}
+	if ussdRequest.App != "" {
+		metadata[ussdproxy.MetadataApp] = ussdRequest.App
 	}
-	//len := len(ussdData)
-	moreToSend := typ.HasMoreToSend()
-	// TODO: add a generic NewRequest func to account for the type
-	return ussdproxy.NewDataRequest(ussdData[2:], moreToSend), nil
+	return ussdproxy.ParseUssdRequest(request)
 }
@@ -1 +1,107 @@
 package africastalking_test
+
+import (
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/africastalking"
+)
+
+// callback the form Africa's Talking posts for the text of the dialogue
+func callback(sessionID, text string) *ussd.Request {
+	r := ussd.NewRequest()
+	r.Method = "POST"
+	r.Form.Set("sessionId", sessionID)
+	r.Form.Set("phoneNumber", "265991234567")
+	r.Form.Set("serviceCode", "*384*1234#")
+	r.Form.Set("text", text)
+	return r
+}
+
+func TestReadLatestHop(t *testing.T) {
+	provider := africastalking.New()
+	steps := []struct {
+		text    string
+		pduType ussdproxy.PduType
+		data    string
+		initial bool
+	}{
+		{text: "", initial: true},
+		{text: "D;hello", pduType: ussdproxy.DataLongPduType, data: "hello"},
+		{text: "D;hello*R;", pduType: ussdproxy.ReceiveReadyPduType},
+		// the input may contain the separator
+		{text: "D;hello*R;*D;a*b", pduType: ussdproxy.DataLongPduType, data: "a*b"},
+		// a retried request is the same hop
+		{text: "D;hello*R;*D;a*b", pduType: ussdproxy.DataLongPduType, data: "a*b"},
+		{text: "D;hello*R;*D;a*b*D;*", pduType: ussdproxy.DataLongPduType, data: "*"},
+	}
+	for _, step := range steps {
+		request, err := provider.Read(callback("1234", step.text))
+		if err != nil {
+			t.Fatalf("%q: failed to read the request: %v", step.text, err)
+		}
+		if request.UssdRequest().Initial() != step.initial {
+			t.Errorf("%q: expected initial to be %v", step.text, step.initial)
+		}
+		if step.initial {
+			continue
+		}
+		if request.Header().Type != step.pduType {
+			t.Errorf("%q: expected a %c request, got %s", step.text, step.pduType, request.ToString())
+		}
+		if got := string(request.Data()); got != step.data {
+			t.Errorf("%q: expected the data '%s', got '%s'", step.text, step.data, got)
+		}
+	}
+}
+
+// TestReadUnknownDialogue the latest hop of a dialogue the provider has not
+// seen, e.g. after a restart, starts at the last separator followed by a PDU type
+func TestReadUnknownDialogue(t *testing.T) {
+	testCases := map[string]string{
+		"D;hello":               "hello",
+		"D;hello*D;world":       "world",
+		"D;hello*D;a*b":         "a*b",
+		"D;hello*D;a*R;b":       "b",
+		"D;hello*D;a*b*c*d":     "a*b*c*d",
+		"R;*D;2*3 4*5":          "2*3 4*5",
+		"D;hello*D;x**y":        "x**y",
+		"D;first*D;second*D;*;": "*;",
+	}
+	for text, expected := range testCases {
+		provider := africastalking.New()
+		request, err := provider.Read(callback("1234", text))
+		if err != nil {
+			t.Fatalf("%q: failed to read the request: %v", text, err)
+		}
+		if got := string(request.Data()); got != expected {
+			t.Errorf("%q: expected the data '%s', got '%s'", text, expected, got)
+		}
+	}
+}
+
+func TestWriteEndForgetsTheDialogue(t *testing.T) {
+	provider := africastalking.New()
+	for _, text := range []string{"", "D;a"} {
+		if _, err := provider.Read(callback("1234", text)); err != nil {
+			t.Fatalf("failed to read the request: %v", err)
+		}
+	}
+	request, _ := provider.Read(callback("1234", "D;a*D;b"))
+	writer := ussd.NewResponse()
+	writer.UssdRequest = request.UssdRequest()
+	provider.WriteEnd(ussdproxy.NewUserAbortReleaseDialogueResponse(), writer)
+	if got := writer.Body.String(); got != "END\nX;" {
+		t.Errorf("expected END with the release, got %q", got)
+	}
+
+	// a session ID reused after the end is read without the old text
+	request, err := provider.Read(callback("1234", "D;a*D;b*c"))
+	if err != nil {
+		t.Fatalf("failed to read the request: %v", err)
+	}
+	if got := string(request.Data()); got != "b*c" {
+		t.Errorf("expected the data 'b*c', got '%s'", got)
+	}
+}
@@ -1,7 +1,24 @@
 // ussd implements supported USSD Service Provider functionality for
 // marshalling USSD requests into a UDCP Request and UDCP Responses to USSD
 //
-// We currently support two USSD Service Providers
+// We currently support the following USSD Service Providers
 // * [Africastalking](https://africastalking.com)
 // * TNM vai Truroute - See https://github.com/saulchelewani/ussd
+// * Flares - See https://github.com/saulchelewani/ussd
+// * Aggregators with JSON callbacks e.g. Hubtel, Arkesel or Nalo - See the jsonprovider package
+// * Operators with USSD through a Kannel SMPP connection - See the kannel package
+// * Any aggregator, with the fields and responses in the configuration - See the templateprovider package
+//
+// Providers register themselves with Register so other modules can add
+// aggregators by importing their package, e.g.
+//
+//	func init() {
+//		ussd.Register("myaggregator", func(options map[string]interface{}) (ussd.UssdProvider, error) {
+//			cfg := Config{}
+//			if err := ussd.DecodeOptions(options, &cfg); err != nil {
+//				return nil, err
+//			}
+//			return New(cfg), nil
+//		})
+//	}
 package ussd
@@ -0,0 +1,95 @@
+package ussd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+)
+
+// ErrInvalidRequest is returned (wrapped) by readers when the request is not a
+// valid callback from the provider, servers respond with 400 Bad Request
+var ErrInvalidRequest = errors.New("invalid ussd request")
+
+// Request is a callback from a USSD provider independent of the HTTP server
+// that received it, see RequestFromFastHTTP and RequestFromHTTP
+type Request struct {
+	Method     string
+	Path       string
+	Header     http.Header
+	Query      url.Values // Query string arguments
+	Form       url.Values // Fields of a url-encoded or multipart form body
+	Body       []byte
+	RemoteAddr string // host:port of the client
+}
+
+// NewRequest creates an empty request
+func NewRequest() *Request {
+	return &Request{
+		Header: make(http.Header),
+		Query:  make(url.Values),
+		Form:   make(url.Values),
+	}
+}
+
+// LookupFormValue the first value for the key in the query string or the form
+// body, and whether the key was present at all
+func (r *Request) LookupFormValue(key string) (string, bool) {
+	if values, ok := r.Query[key]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	if values, ok := r.Form[key]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	return "", false
+}
+
+// FormValue the first value for the key in the query string or the form body
+func (r *Request) FormValue(key string) string {
+	value, _ := r.LookupFormValue(key)
+	return value
+}
+
+// RemoteIP the IP address of the client, nil if it is unknown
+func (r *Request) RemoteIP() net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Response is the response to a provider's callback, which is written to the
+// HTTP server that received the callback. Response is an io.Writer of the body
+type Response struct {
+	StatusCode int // default: 200
+	Header     http.Header
+	Body       bytes.Buffer
+	// UssdRequest is the request being answered, writers use it to echo e.g.
+	// the session ID back to the provider. nil if the request could not be read
+	UssdRequest ussdproxy.UssdRequestInterface
+}
+
+// NewResponse creates an empty 200 OK response
+func NewResponse() *Response {
+	return &Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+	}
+}
+
+// Write appends to the body of the response
+func (r *Response) Write(p []byte) (int, error) {
+	return r.Body.Write(p)
+}
+
+// Reset clears the response so a different response can be written
+func (r *Response) Reset() {
+	r.StatusCode = http.StatusOK
+	r.Header = make(http.Header)
+	r.Body.Reset()
+	r.UssdRequest = nil
+}
@@ -0,0 +1,48 @@
+package ussd
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// RequestFromFastHTTP copies the callback received by a fasthttp server, the
+// request remains valid after the handler returns
+func RequestFromFastHTTP(ctx *fasthttp.RequestCtx) *Request {
+	r := NewRequest()
+	r.Method = string(ctx.Method())
+	r.Path = string(ctx.Path())
+	r.RemoteAddr = ctx.RemoteAddr().String()
+	r.Body = append([]byte(nil), ctx.PostBody()...)
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		r.Header.Add(string(key), string(value))
+	})
+	ctx.QueryArgs().VisitAll(func(key, value []byte) {
+		r.Query.Add(string(key), string(value))
+	})
+	ctx.PostArgs().VisitAll(func(key, value []byte) {
+		r.Form.Add(string(key), string(value))
+	})
+	if form, err := ctx.MultipartForm(); err == nil {
+		for key, values := range form.Value {
+			for _, value := range values {
+				r.Form.Add(key, value)
+			}
+		}
+	}
+	return r
+}
+
+// WriteFastHTTP writes the response to a fasthttp request
+func (r *Response) WriteFastHTTP(ctx *fasthttp.RequestCtx) {
+	for key, values := range r.Header {
+		for _, value := range values {
+			ctx.Response.Header.Add(key, value)
+		}
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		ctx.SetContentType(contentType)
+	}
+	if r.StatusCode != 0 {
+		ctx.SetStatusCode(r.StatusCode)
+	}
+	ctx.Write(r.Body.Bytes())
+}
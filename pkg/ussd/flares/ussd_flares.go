@@ -3,32 +3,91 @@ package flares
 import (
 	"bytes"
 	"encoding/xml"
-	"errors"
 	"fmt"
-	"io"
 
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
-	"github.com/valyala/fasthttp"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+const (
+	// RequestTypePull is a request with the input of the subscriber
+	RequestTypePull = "pull"
+	// RequestTypeCleanup is sent when the network ended the session, e.g. the
+	// subscriber cancelled or the session timed out. No response is expected
+	RequestTypeCleanup = "cleanup"
+
+	// FreeflowContinue the response continues the session, the subscriber is prompted for input
+	FreeflowContinue = "FC"
+	// FreeflowBreak the response ends the session
+	FreeflowBreak = "FB"
 )
 
 // FlaresRequest struct represents a request coming in from the Network routed from a Flares services USSD API
 //
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<request type="pull">
+//	  <sessionId>311412344</sessionId>
+//	  <transactionId>4fc0f7a3</transactionId>
+//	  <msisdn>265991234567</msisdn>
+//	  <newRequest>1</newRequest>
+//	  <flowState>FD</flowState>
+//	  <subscriberInput>*1234#</subscriberInput>
+//	</request>
+//
 // See: https://github.com/saulchelewani/ussd/blob/master/src/Http/Flares/FlaresRequest.php
 type FlaresRequest struct {
-	XMLName xml.Name `xml:"request"`
-	Message string   `xml:"subscriberInput"`
-	Session string   `xml:"sessionId"`
-	Msisdn  string   `xml:"msisdn"`
+	XMLName       xml.Name `xml:"request"`
+	Type          string   `xml:"type,attr"` // pull or cleanup
+	Session       string   `xml:"sessionId"`
+	TransactionID string   `xml:"transactionId"`
+	Msisdn        string   `xml:"msisdn"`
+	NewRequest    int      `xml:"newRequest"` // 1 when the subscriber dialled the service code
+	FlowState     string   `xml:"flowState"`
+	Message       string   `xml:"subscriberInput"`
+}
+
+// IsNewRequest whether the subscriber dialled the service code, which starts the session
+func (r *FlaresRequest) IsNewRequest() bool {
+	return r.NewRequest == 1
+}
+
+// IsCleanup whether the network ended the session
+func (r *FlaresRequest) IsCleanup() bool {
+	return r.Type == RequestTypeCleanup
 }
 
 // FlaresResponse XML struct for the response from a Flares services
 //
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<response>
+//	  <sessionId>311412344</sessionId>
+//	  <msisdn>265991234567</msisdn>
+//	  <applicationResponse>D;hello</applicationResponse>
+//	  <freeflow>
+//	    <freeflowState>FC</freeflowState>
+//	    <freeflowCharging>N</freeflowCharging>
+//	    <freeflowChargingAmount>0</freeflowChargingAmount>
+//	  </freeflow>
+//	</response>
+//
 // See: https://github.com/saulchelewani/ussd/blob/master/src/Http/Flares/FlaresResponse.php
 type FlaresResponse struct {
-	XMLName xml.Name `xml:"response"`
-	Message string   `xml:"applicationResponse"`
-	Session string   `xml:"sessionId"`
-	Msisdn  string   `xml:"msisdn"`
+	XMLName  xml.Name       `xml:"response"`
+	Session  string         `xml:"sessionId"`
+	Msisdn   string         `xml:"msisdn"`
+	Message  string         `xml:"applicationResponse"`
+	Freeflow FlaresFreeflow `xml:"freeflow"`
+}
+
+// FlaresFreeflow indicates whether the session continues and what the subscriber is charged
+type FlaresFreeflow struct {
+	State          string `xml:"freeflowState"`          // FC to continue or FB to end the session
+	Charging       string `xml:"freeflowCharging"`       // Y or N
+	ChargingAmount int    `xml:"freeflowChargingAmount"` // Amount charged when charging is Y
+}
+
+func (t *FlaresResponse) IsRelease() bool {
+	return t.Freeflow.State == FreeflowBreak
 }
 
 func (t *FlaresResponse) GetText() string {
@@ -56,28 +115,48 @@ func New() *FlaresUssdHandler {
 	return &FlaresUssdHandler{}
 }
 
-func (u *FlaresUssdHandler) Read(ctx *fasthttp.RequestCtx) (ussdproxy.UdcpRequest, error) {
-	requestData := ctx.Request.Body()
-	if requestData == nil {
-		return nil, errors.New("flares: request was empty or nil")
+func init() {
+	ussd.Register("flares", func(options map[string]interface{}) (ussd.UssdProvider, error) {
+		// the provider has no options
+		if err := ussd.DecodeOptions(options, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return New(), nil
+	})
+}
+
+func (u *FlaresUssdHandler) Read(request *ussd.Request) (ussdproxy.UdcpRequest, error) {
+	requestData := request.Body
+	if len(requestData) == 0 {
+		return nil, fmt.Errorf("%w: flares request was empty", ussd.ErrInvalidRequest)
 	}
 
-	var trRequest *FlaresRequest
-	err := xml.Unmarshal(requestData, &trRequest)
+	var flRequest *FlaresRequest
+	err := xml.Unmarshal(requestData, &flRequest)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ussd.ErrInvalidRequest, err)
 	}
 
-	if trRequest.Msisdn == "" || trRequest.Session == "" {
-		ctx.SetStatusCode(400)
-		return nil, fmt.Errorf("invalid request, got body: %s", string(ctx.Request.Body()))
+	if flRequest.Msisdn == "" || flRequest.Session == "" {
+		return nil, fmt.Errorf("%w, got body: %s", ussd.ErrInvalidRequest, string(request.Body))
+	}
+
+	data := []byte(flRequest.Message)
+	switch flRequest.Type {
+	case "", RequestTypePull:
+	case RequestTypeCleanup:
+		// the session has ended on the network, release the dialogue
+		data = []byte(ussdproxy.ReleaseDialogPduAscii)
+	default:
+		return nil, fmt.Errorf("%w: unknown request type '%s'", ussd.ErrInvalidRequest, flRequest.Type)
 	}
 
 	return parseUssdRequest(&UssdRequest{
-		SessionID:   string(trRequest.Session),
-		PhoneNumber: string(trRequest.Msisdn),
-		Data:        []byte(trRequest.Message),
-		Channel:     string(trRequest.Msisdn),
+		SessionID:   flRequest.Session,
+		PhoneNumber: flRequest.Msisdn,
+		Data:        data,
+		Channel:     flRequest.Msisdn,
+		Initial:     flRequest.IsNewRequest(),
 	})
 }
 
@@ -85,28 +164,33 @@ func (u *FlaresUssdHandler) GetContentType() string {
 	return "text/xml; charset=ascii"
 }
 
-func (u *FlaresUssdHandler) Write(response ussdproxy.UdcpResponse, writer io.Writer) (int, error) {
-	trResponse := &FlaresResponse{
-		Message: string(response.Data()),
-		Msisdn:  "",
-	}
-	data, err := xml.Marshal(trResponse)
-	if err != nil {
-		return -1, err
-	}
-	return writer.Write(data)
+func (u *FlaresUssdHandler) Write(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(FreeflowContinue, response, writer)
 }
 
-func (u *FlaresUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer io.Writer) (int, error) {
-	trResponse := &FlaresResponse{
-		Message: string(response.Data()),
-		Msisdn:  "",
+func (u *FlaresUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(FreeflowBreak, response, writer)
+}
+
+func (u *FlaresUssdHandler) write(freeflowState string, response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	flResponse := &FlaresResponse{
+		Message: string(ussdproxy.Encode(response)),
+		Freeflow: FlaresFreeflow{
+			State:          freeflowState,
+			Charging:       "N",
+			ChargingAmount: 0,
+		},
+	}
+	// the session and msisdn of the request are echoed back
+	if writer.UssdRequest != nil {
+		flResponse.Session = writer.UssdRequest.SessionID()
+		flResponse.Msisdn = writer.UssdRequest.PhoneNumber()
 	}
-	data, err := xml.Marshal(trResponse)
+	data, err := xml.Marshal(flResponse)
 	if err != nil {
 		return -1, err
 	}
-	return writer.Write(data)
+	return writer.Write(append([]byte(xml.Header), data...))
 }
 
 type UssdRequest struct {
@@ -114,6 +198,7 @@ type UssdRequest struct {
 	Data        []byte `json:"name=text"`
 	SessionID   string `json:"name=sessionId"`
 	Channel     string `json:"name=channel,omitempty"`
+	Initial     bool   `json:"-"` // Whether the request starts the session (newRequest 1)
 }
 
 // Bytes Converts the UssdRequest to a byte array with the data is separated by 0x0
@@ -132,13 +217,15 @@ func (u *UssdRequest) Bytes() []byte {
 }
 
 func parseUssdRequest(ussdRequest *UssdRequest) (ussdproxy.UdcpRequest, error) {
-	ussdData := ussdRequest.Data
-	typ := ussdproxy.RequestPduType(string(ussdData[0:2]))
-	if typ == ussdproxy.InvalidPduType {
-		return nil, fmt.Errorf("%v got '%v'", ussdproxy.ErrInvalidHeader, typ)
+	newUssdRequest := ussdproxy.NewUssdRequest
+	if ussdRequest.Initial {
+		newUssdRequest = ussdproxy.NewInitialUssdRequest
 	}
-	//len := len(ussdData)
-	moreToSend := typ.HasMoreToSend()
-	// TODO: add a generic NewRequest func to account for the type
-	return ussdproxy.NewDataRequest(ussdData[2:], moreToSend), nil
+	return ussdproxy.ParseUssdRequest(newUssdRequest(
+		"flares",
+		ussdRequest.SessionID,
+		ussdRequest.PhoneNumber,
+		ussdRequest.Channel,
+		ussdRequest.Data,
+	))
 }
@@ -0,0 +1,118 @@
+package flares_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/flares"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// readRequest reads the request in testdata, which are synthetic requests with
+// the schema of the Flares gateway, see testdata/README.md
+func readRequest(t *testing.T, name string) *ussd.Request {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("testdata", name+".xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := ussd.NewRequest()
+	request.Method = "POST"
+	request.Body = body
+	return request
+}
+
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response does not match %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+func TestReadAndWrite(t *testing.T) {
+	receiveReady := func(ussdproxy.UdcpRequest) ussdproxy.UdcpResponse { return ussdproxy.NewReceiveReadyResponse() }
+	release := func(ussdproxy.UdcpRequest) ussdproxy.UdcpResponse {
+		return ussdproxy.NewUserAbortReleaseDialogueResponse()
+	}
+	tests := []struct {
+		name     string
+		pduType  ussdproxy.PduType
+		data     string
+		initial  bool
+		response func(ussdproxy.UdcpRequest) ussdproxy.UdcpResponse
+	}{
+		{name: "new_request", pduType: ussdproxy.ReceiveReadyPduType, data: ussdproxy.NoDataResponse, initial: true, response: receiveReady},
+		{name: "data", pduType: ussdproxy.DataLongPduType, data: "temperature=23.5", response: func(request ussdproxy.UdcpRequest) ussdproxy.UdcpResponse {
+			return ussdproxy.NewDataResponse(request, []byte("ok"), false)
+		}},
+		{name: "data_more_to_send", pduType: ussdproxy.DataPduWithMtsType, data: "humidity=61", response: receiveReady},
+		{name: "release", pduType: ussdproxy.ReleaseDialogPduType, response: release},
+		{name: "cleanup", pduType: ussdproxy.ReleaseDialogPduType, response: release},
+	}
+
+	provider := flares.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := provider.Read(readRequest(t, tt.name))
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if request.Header().Type != tt.pduType {
+				t.Errorf("Read() type = %v, want %v", request.Header().Type, tt.pduType)
+			}
+			if string(request.Data()) != tt.data {
+				t.Errorf("Read() data = %q, want %q", request.Data(), tt.data)
+			}
+			if got := request.UssdRequest().Initial(); got != tt.initial {
+				t.Errorf("Read() initial = %v, want %v", got, tt.initial)
+			}
+			if got := request.UssdRequest().SessionID(); got != "311412344" {
+				t.Errorf("Read() session = %q, want %q", got, "311412344")
+			}
+			if got := request.UssdRequest().PhoneNumber(); got != "265991234567" {
+				t.Errorf("Read() msisdn = %q, want %q", got, "265991234567")
+			}
+
+			writer := ussd.NewResponse()
+			writer.UssdRequest = request.UssdRequest()
+			if _, err := ussd.WriteResponse(provider, tt.response(request), writer); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			assertGolden(t, tt.name, writer.Body.Bytes())
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	provider := flares.New()
+	for _, name := range []string{"missing_msisdn", "unknown_type"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := provider.Read(readRequest(t, name))
+			if !errors.Is(err, ussd.ErrInvalidRequest) {
+				t.Errorf("Read() error = %v, want %v", err, ussd.ErrInvalidRequest)
+			}
+		})
+	}
+
+	_, err := provider.Read(ussd.NewRequest())
+	if !errors.Is(err, ussd.ErrInvalidRequest) {
+		t.Errorf("Read() of an empty body error = %v, want %v", err, ussd.ErrInvalidRequest)
+	}
+}
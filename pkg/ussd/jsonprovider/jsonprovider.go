@@ -0,0 +1,254 @@
+// jsonprovider is a USSD provider for aggregators with JSON callbacks, e.g.
+// Hubtel, Arkesel or Nalo. The field names of the request and response, and the
+// values which signal the start and end of a session are set in the options of
+// the provider so a new aggregator only needs configuration. The defaults are
+// for callbacks like
+//
+//	{"sessionId": "1234", "msisdn": "233241234567", "userData": "D;hello", "msgType": "1"}
+//
+// which are answered with
+//
+//	{"sessionId": "1234", "msisdn": "233241234567", "message": "...", "continueSession": true}
+//
+// e.g. for Arkesel
+//
+//	ussd:
+//	  providers:
+//	  - name: arkesel
+//	    provider: json
+//	    callback_url: /ussd/callback/arkesel
+//	    options:
+//	      request:
+//	        session_id: sessionID
+//	        data: userData
+//	        type: newSession
+//	        initial_types: ["true"]
+//	      response:
+//	        echo: [sessionID, userID, msisdn]
+//
+// and for Nalo
+//
+//	options:
+//	  request:
+//	    session_id: SESSIONID
+//	    msisdn: MSISDN
+//	    data: USERDATA
+//	    type: MSGTYPE
+//	    initial_types: ["true"]
+//	  response:
+//	    message: MSG
+//	    continue: MSGTYPE
+//	    echo: [USERID, MSISDN]
+//
+// Fields may be nested objects, separate the names with dots e.g. session.id
+//
+// A request starts a new dialogue only when its type is one of initial_types,
+// default 1. Input which is empty, e.g. the subscriber pressed send without
+// typing, continues the dialogue as a Receive Ready PDU
+package jsonprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+// metadataEchoPrefix prefixes the Metadata keys of the request fields echoed in the response
+const metadataEchoPrefix = "json.echo."
+
+// RequestFields names the fields of the callback
+type RequestFields struct {
+	SessionID    string   `mapstructure:"session_id"`    // default: sessionId
+	Msisdn       string   `mapstructure:"msisdn"`        // default: msisdn
+	Data         string   `mapstructure:"data"`          // Input of the subscriber. default: userData
+	Type         string   `mapstructure:"type"`          // Type of the message. default: msgType
+	InitialTypes []string `mapstructure:"initial_types"` // Values of type when the subscriber dialled the service code. default: 1
+	ReleaseTypes []string `mapstructure:"release_types"` // Values of type when the network ended the session e.g. a timeout
+}
+
+// ResponseFields names the fields of the reply and the values of the continue flag
+type ResponseFields struct {
+	Message       string                 `mapstructure:"message"`        // default: message
+	Continue      string                 `mapstructure:"continue"`       // Field with the continue flag. default: continueSession
+	ContinueValue interface{}            `mapstructure:"continue_value"` // default: true
+	EndValue      interface{}            `mapstructure:"end_value"`      // default: false
+	Echo          []string               `mapstructure:"echo"`           // Request fields copied to the reply. default: the session ID and msisdn fields
+	Static        map[string]interface{} `mapstructure:"static"`         // Fields added to every reply as they are
+}
+
+// Config is the configuration of the json provider's options
+type Config struct {
+	Request  RequestFields  `mapstructure:"request"`
+	Response ResponseFields `mapstructure:"response"`
+}
+
+// DefaultConfig the configuration used for options that are not set
+func DefaultConfig() Config {
+	return Config{
+		Request: RequestFields{
+			SessionID:    "sessionId",
+			Msisdn:       "msisdn",
+			Data:         "userData",
+			Type:         "msgType",
+			InitialTypes: []string{"1"},
+		},
+		Response: ResponseFields{
+			Message:       "message",
+			Continue:      "continueSession",
+			ContinueValue: true,
+			EndValue:      false,
+		},
+	}
+}
+
+func init() {
+	ussd.Register("json", func(options map[string]interface{}) (ussd.UssdProvider, error) {
+		cfg := DefaultConfig()
+		if err := ussd.DecodeOptions(options, &cfg); err != nil {
+			return nil, err
+		}
+		return New(cfg), nil
+	})
+}
+
+// JSONUssdHandler reads and writes JSON callbacks with the configured fields
+type JSONUssdHandler struct {
+	config Config
+}
+
+func New(cfg Config) *JSONUssdHandler {
+	if cfg.Response.Echo == nil {
+		cfg.Response.Echo = []string{cfg.Request.SessionID, cfg.Request.Msisdn}
+	}
+	return &JSONUssdHandler{config: cfg}
+}
+
+func (u *JSONUssdHandler) Read(request *ussd.Request) (ussdproxy.UdcpRequest, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(request.Body, &body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ussd.ErrInvalidRequest, err)
+	}
+
+	fields := u.config.Request
+	sessionID, hasSessionID := Lookup(body, fields.SessionID)
+	msisdn, hasMsisdn := Lookup(body, fields.Msisdn)
+	if !hasSessionID || !hasMsisdn {
+		return nil, fmt.Errorf("%w, got body: %s", ussd.ErrInvalidRequest, string(request.Body))
+	}
+	data, _ := Lookup(body, fields.Data)
+	msgType, _ := Lookup(body, fields.Type)
+
+	initial := ussd.ContainsString(fields.InitialTypes, msgType)
+	switch {
+	case ussd.ContainsString(fields.ReleaseTypes, msgType):
+		// the session has ended on the network, release the dialogue
+		data, initial = string(ussdproxy.ReleaseDialogPduAscii), false
+	case data == "" && !initial:
+		// the subscriber sent nothing, ask the application for more data
+		data = string(ussdproxy.ReceiveReadyPduAscii)
+	}
+
+	newUssdRequest := ussdproxy.NewUssdRequest
+	if initial {
+		newUssdRequest = ussdproxy.NewInitialUssdRequest
+	}
+	ussdRequest := newUssdRequest("json", sessionID, msisdn, msisdn, []byte(data))
+	for _, field := range u.config.Response.Echo {
+		if value, ok := lookupValue(body, field); ok {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			ussdRequest.Metadata()[metadataEchoPrefix+field] = string(raw)
+		}
+	}
+	return ussdproxy.ParseUssdRequest(ussdRequest)
+}
+
+func (u *JSONUssdHandler) GetContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (u *JSONUssdHandler) Write(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(u.config.Response.ContinueValue, response, writer)
+}
+
+func (u *JSONUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(u.config.Response.EndValue, response, writer)
+}
+
+func (u *JSONUssdHandler) write(continueValue interface{}, response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	fields := u.config.Response
+	reply := make(map[string]interface{})
+	for field, value := range fields.Static {
+		set(reply, field, value)
+	}
+	if writer.UssdRequest != nil {
+		for _, field := range fields.Echo {
+			if raw, ok := writer.UssdRequest.Metadata()[metadataEchoPrefix+field]; ok {
+				set(reply, field, json.RawMessage(raw))
+			}
+		}
+	}
+	set(reply, fields.Message, string(ussdproxy.Encode(response)))
+	set(reply, fields.Continue, continueValue)
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return -1, err
+	}
+	return writer.Write(data)
+}
+
+// Lookup the value of the field at the dotted path in the JSON document as a
+// string, numbers and booleans are formatted as they appear in JSON
+func Lookup(doc map[string]interface{}, path string) (string, bool) {
+	value, ok := lookupValue(doc, path)
+	if !ok || value == nil {
+		return "", ok
+	}
+	if s, isString := value.(string); isString {
+		return s, true
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(raw), true
+}
+
+func lookupValue(doc map[string]interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+	var value interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// set the field at the dotted path, creating nested objects
+func set(doc map[string]interface{}, path string, value interface{}) {
+	if path == "" {
+		return
+	}
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		child, ok := doc[name].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			doc[name] = child
+		}
+		doc = child
+	}
+	doc[names[len(names)-1]] = value
+}
@@ -0,0 +1,197 @@
+package jsonprovider_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/jsonprovider"
+)
+
+func TestRead(t *testing.T) {
+	arkesel := jsonprovider.DefaultConfig()
+	arkesel.Request.SessionID = "sessionID"
+	arkesel.Request.Type = "newSession"
+	arkesel.Request.InitialTypes = []string{"true"}
+	arkesel.Request.ReleaseTypes = []string{"release"}
+
+	testCases := []struct {
+		name    string
+		config  jsonprovider.Config
+		body    string
+		initial bool
+		data    string
+		invalid bool
+		pduType ussdproxy.PduType
+	}{
+		{
+			name:    "initial type",
+			config:  jsonprovider.DefaultConfig(),
+			body:    `{"sessionId": "1234", "msisdn": "233241234567", "userData": "*123#", "msgType": "1"}`,
+			initial: true,
+			data:    ussdproxy.NoDataResponse,
+		},
+		{
+			name:   "data",
+			config: jsonprovider.DefaultConfig(),
+			body:   `{"sessionId": "1234", "msisdn": "233241234567", "userData": "D;hello", "msgType": "2"}`,
+			data:   "hello",
+		},
+		{
+			name:    "empty input continues the dialogue",
+			config:  jsonprovider.DefaultConfig(),
+			body:    `{"sessionId": "1234", "msisdn": "233241234567", "userData": "", "msgType": "2"}`,
+			pduType: ussdproxy.ReceiveReadyPduType,
+		},
+		{
+			name:    "missing input continues the dialogue",
+			config:  jsonprovider.DefaultConfig(),
+			body:    `{"sessionId": "1234", "msisdn": "233241234567", "msgType": "2"}`,
+			pduType: ussdproxy.ReceiveReadyPduType,
+		},
+		{
+			name:    "boolean type",
+			config:  arkesel,
+			body:    `{"sessionID": "1234", "msisdn": "233241234567", "userData": "", "newSession": true}`,
+			initial: true,
+			data:    ussdproxy.NoDataResponse,
+		},
+		{
+			name:    "release type",
+			config:  arkesel,
+			body:    `{"sessionID": "1234", "msisdn": "233241234567", "userData": "D;hello", "newSession": "release"}`,
+			pduType: ussdproxy.ReleaseDialogPduType,
+		},
+		{
+			name:   "nested fields",
+			config: jsonprovider.Config{Request: jsonprovider.RequestFields{SessionID: "session.id", Msisdn: "session.msisdn", Data: "input"}},
+			body:   `{"session": {"id": 1234, "msisdn": "233241234567"}, "input": "D;hello"}`,
+			data:   "hello",
+		},
+		{
+			name:    "missing session",
+			config:  jsonprovider.DefaultConfig(),
+			body:    `{"msisdn": "233241234567", "userData": "D;hello"}`,
+			invalid: true,
+		},
+		{
+			name:    "invalid json",
+			config:  jsonprovider.DefaultConfig(),
+			body:    `{"sessionId":`,
+			invalid: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := ussd.NewRequest()
+			r.Method = "POST"
+			r.Body = []byte(tc.body)
+			request, err := jsonprovider.New(tc.config).Read(r)
+			if tc.invalid {
+				if err == nil {
+					t.Fatal("expected an invalid request")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read the request: %v", err)
+			}
+			ussdRequest := request.UssdRequest()
+			if ussdRequest.SessionID() != "1234" || ussdRequest.PhoneNumber() != "233241234567" {
+				t.Errorf("expected session 1234 of 233241234567, got %s of %s", ussdRequest.SessionID(), ussdRequest.PhoneNumber())
+			}
+			if ussdRequest.Initial() != tc.initial {
+				t.Errorf("expected initial to be %v", tc.initial)
+			}
+			if tc.pduType != 0 {
+				if request.Header().Type != tc.pduType {
+					t.Errorf("expected a %s request, got %s", string(tc.pduType), request.ToString())
+				}
+				return
+			}
+			if string(request.Data()) != tc.data {
+				t.Errorf("expected data '%s', got '%s'", tc.data, string(request.Data()))
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	nalo := jsonprovider.Config{
+		Request: jsonprovider.RequestFields{SessionID: "SESSIONID", Msisdn: "MSISDN", Data: "USERDATA"},
+		Response: jsonprovider.ResponseFields{
+			Message:       "MSG",
+			Continue:      "MSGTYPE",
+			ContinueValue: true,
+			EndValue:      false,
+			Echo:          []string{"USERID", "MSISDN"},
+			Static:        map[string]interface{}{"meta.version": "1"},
+		},
+	}
+	provider := jsonprovider.New(nalo)
+	r := ussd.NewRequest()
+	r.Method = "POST"
+	r.Body = []byte(`{"SESSIONID": "1234", "USERID": 42, "MSISDN": "233241234567", "USERDATA": "D;hello"}`)
+	request, err := provider.Read(r)
+	if err != nil {
+		t.Fatalf("failed to read the request: %v", err)
+	}
+
+	testCases := map[string]struct {
+		write    func(ussdproxy.UdcpResponse, *ussd.Response) (int, error)
+		response ussdproxy.UdcpResponse
+		expected map[string]interface{}
+	}{
+		"continue": {
+			write:    provider.Write,
+			response: ussdproxy.NewDataResponse(request, []byte("hello"), false),
+			expected: map[string]interface{}{"MSG": "D;hello", "MSGTYPE": true, "USERID": float64(42), "MSISDN": "233241234567", "meta": map[string]interface{}{"version": "1"}},
+		},
+		"end": {
+			write:    provider.WriteEnd,
+			response: ussdproxy.NewUserAbortReleaseDialogueResponse(),
+			expected: map[string]interface{}{"MSG": "X;", "MSGTYPE": false, "USERID": float64(42), "MSISDN": "233241234567", "meta": map[string]interface{}{"version": "1"}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			writer := ussd.NewResponse()
+			writer.UssdRequest = request.UssdRequest()
+			if _, err := tc.write(tc.response, writer); err != nil {
+				t.Fatalf("failed to write the response: %v", err)
+			}
+			var reply map[string]interface{}
+			if err := json.Unmarshal(writer.Body.Bytes(), &reply); err != nil {
+				t.Fatalf("expected a JSON reply, got %s: %v", writer.Body.String(), err)
+			}
+			if !reflect.DeepEqual(reply, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, reply)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(`{"a": {"b": "c", "n": 12, "t": true, "z": null}}`), &doc)
+	testCases := map[string]struct {
+		value string
+		found bool
+	}{
+		"a.b":   {"c", true},
+		"a.n":   {"12", true},
+		"a.t":   {"true", true},
+		"a.z":   {"", true},
+		"a.x":   {"", false},
+		"a.b.c": {"", false},
+		"":      {"", false},
+	}
+	for path, tc := range testCases {
+		value, found := jsonprovider.Lookup(doc, path)
+		if value != tc.value || found != tc.found {
+			t.Errorf("Lookup(%q): expected %q %v, got %q %v", path, tc.value, tc.found, value, found)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// kannel is a USSD provider for operators exposing USSD through a Kannel
+// gateway with an SMPP connection to the USSD centre. Kannel delivers the
+// input of the subscriber to an sms-service get-url, with the SMPP
+// ussd_service_op TLV in the meta-data, e.g.
+//
+//	group = smpp-tlv
+//	name = ussd_service_op
+//	tag = 0x0501
+//	type = integer
+//	length = 1
+//	smsc-id = ussd
+//
+//	group = smpp-tlv
+//	name = its_session_info
+//	tag = 0x1383
+//	type = integer
+//	length = 2
+//	smsc-id = ussd
+//
+//	group = sms-service
+//	keyword = default
+//	catch-all = true
+//	accept-x-kannel-headers = true
+//	omit-empty = true
+//	get-url = "http://localhost:3000/ussd/callback/kannel?from=%p&to=%P&text=%a&smsc=%i&meta-data=%D"
+//
+// The reply is the body of the get-url response with the ussd_service_op in
+// the X-Kannel-Meta-Data header. When sendsms_url is set, replies are sent
+// through Kannel's sendsms interface instead and the get-url response is empty.
+// Requests without the ussd_service_op TLV are rejected
+package kannel
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/secret"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+)
+
+// ussd_service_op values of SMPP 3.4
+const (
+	ServiceOpPSSDIndication = 0  // MO, the subscriber sent a USSD string
+	ServiceOpPSSRIndication = 1  // MO, the subscriber dialled the service code, which starts a dialogue
+	ServiceOpUSSRRequest    = 2  // MT, prompt the subscriber, the dialogue continues
+	ServiceOpUSSNRequest    = 3  // MT, notify the subscriber
+	ServiceOpPSSDResponse   = 16 // MT, response to a PSSD indication
+	ServiceOpPSSRResponse   = 17 // MT, the final response, which ends the dialogue
+	ServiceOpUSSRConfirm    = 18 // MO, the subscriber answered a USSR request
+	ServiceOpUSSNConfirm    = 19 // MO, the subscriber acknowledged a notification
+)
+
+const (
+	// MetaDataHeader is the Kannel reply header with the meta-data of the reply
+	MetaDataHeader = "X-Kannel-Meta-Data"
+
+	metaDataGroup        = "smpp"
+	tlvServiceOp         = "ussd_service_op"
+	tlvSessionInfo       = "its_session_info"
+	metadataKannelPrefix = "kannel."
+)
+
+// Config is the configuration of the kannel provider's options
+type Config struct {
+	SendSmsURL string        `mapstructure:"sendsms_url"` // e.g. http://localhost:13013/cgi-bin/sendsms
+	Username   string        `mapstructure:"username"`    // sendsms username, may reference a secret e.g. env:NAME or file:/path
+	Password   string        `mapstructure:"password"`    // sendsms password, may reference a secret e.g. env:NAME or file:/path
+	SMSC       string        `mapstructure:"smsc"`        // SMSC replies are sent through. default: the SMSC of the request
+	Timeout    time.Duration `mapstructure:"timeout"`     // Timeout of sendsms requests. default: 5s
+}
+
+func init() {
+	ussd.Register("kannel", func(options map[string]interface{}) (ussd.UssdProvider, error) {
+		cfg := Config{}
+		if err := ussd.DecodeOptions(options, &cfg); err != nil {
+			return nil, err
+		}
+		if err := secret.ResolveAll(&cfg.Username, &cfg.Password); err != nil {
+			return nil, err
+		}
+		return New(cfg), nil
+	})
+}
+
+// KannelUssdHandler reads the get-url requests of Kannel and replies with the ussd_service_op of the response
+type KannelUssdHandler struct {
+	config Config
+	client *http.Client
+}
+
+func New(cfg Config) *KannelUssdHandler {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &KannelUssdHandler{
+		config: cfg,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// CallbackMethods Kannel sends GET requests to get-url and POST requests to post-url
+func (u *KannelUssdHandler) CallbackMethods() []string {
+	return []string{"GET", "POST"}
+}
+
+// Read reads the get-url parameters of Kannel, or the X-Kannel headers and
+// text body of a post-url request
+func (u *KannelUssdHandler) Read(request *ussd.Request) (ussdproxy.UdcpRequest, error) {
+	from := param(request, "from", "X-Kannel-From")
+	if from == "" {
+		return nil, fmt.Errorf("%w: from is required, got query: %s", ussd.ErrInvalidRequest, request.Query.Encode())
+	}
+	tlvs := ParseMetaData(param(request, "meta-data", "X-Kannel-Meta-Data"))[metaDataGroup]
+	serviceOp, err := parseServiceOp(tlvs.Get(tlvServiceOp))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ussd.ErrInvalidRequest, err)
+	}
+
+	text, hasText := request.LookupFormValue("text")
+	if !hasText && request.Method == "POST" {
+		text = string(request.Body)
+	}
+	smsc := param(request, "smsc", "X-Kannel-SMSC")
+	// the subscriber is in one dialogue at a time, its_session_info identifies it when the USSD centre sends it
+	sessionID := from
+	if sessionInfo := tlvs.Get(tlvSessionInfo); sessionInfo != "" {
+		sessionID = from + "/" + sessionInfo
+	}
+
+	newUssdRequest := ussdproxy.NewUssdRequest
+	if serviceOp == ServiceOpPSSRIndication {
+		newUssdRequest = ussdproxy.NewInitialUssdRequest
+	}
+	ussdRequest := newUssdRequest("kannel", sessionID, from, smsc, []byte(text))
+	metadata := ussdRequest.Metadata()
+	metadata[metadataKannelPrefix+"to"] = param(request, "to", "X-Kannel-To")
+	metadata[metadataKannelPrefix+"smsc"] = smsc
+	metadata[metadataKannelPrefix+tlvSessionInfo] = tlvs.Get(tlvSessionInfo)
+	return ussdproxy.ParseUssdRequest(ussdRequest)
+}
+
+// param the get-url parameter, or the header post-url sends it in
+func param(request *ussd.Request, name, header string) string {
+	if value, ok := request.LookupFormValue(name); ok {
+		return value
+	}
+	return request.Header.Get(header)
+}
+
+func (u *KannelUssdHandler) GetContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+func (u *KannelUssdHandler) Write(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(ServiceOpUSSRRequest, response, writer)
+}
+
+func (u *KannelUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(ServiceOpPSSRResponse, response, writer)
+}
+
+func (u *KannelUssdHandler) write(serviceOp int, response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	tlvs := url.Values{}
+	tlvs.Set(tlvServiceOp, strconv.Itoa(serviceOp))
+	var metadata map[string]string
+	if writer.UssdRequest != nil {
+		metadata = writer.UssdRequest.Metadata()
+		if sessionInfo := metadata[metadataKannelPrefix+tlvSessionInfo]; sessionInfo != "" {
+			tlvs.Set(tlvSessionInfo, sessionInfo)
+		}
+	}
+	metaData := EncodeMetaData(map[string]url.Values{metaDataGroup: tlvs})
+
+	if u.config.SendSmsURL == "" || writer.UssdRequest == nil {
+		writer.Header.Set(MetaDataHeader, metaData)
+		return writer.Write(ussdproxy.Encode(response))
+	}
+
+	smsc := u.config.SMSC
+	if smsc == "" {
+		smsc = metadata[metadataKannelPrefix+"smsc"]
+	}
+	if err := u.sendSms(writer.UssdRequest.PhoneNumber(), metadata[metadataKannelPrefix+"to"], smsc, metaData, ussdproxy.Encode(response)); err != nil {
+		return -1, err
+	}
+	// Kannel does not reply to empty get-url responses with omit-empty
+	return 0, nil
+}
+
+// sendSms sends the reply through Kannel's sendsms interface
+func (u *KannelUssdHandler) sendSms(to, from, smsc, metaData string, text []byte) error {
+	query := url.Values{}
+	query.Set("username", u.config.Username)
+	query.Set("password", u.config.Password)
+	query.Set("to", to)
+	query.Set("from", from)
+	query.Set("text", string(text))
+	query.Set("meta-data", metaData)
+	if smsc != "" {
+		query.Set("smsc", smsc)
+	}
+	sendSmsURL := u.config.SendSmsURL + "?" + query.Encode()
+	if strings.Contains(u.config.SendSmsURL, "?") {
+		sendSmsURL = u.config.SendSmsURL + "&" + query.Encode()
+	}
+	res, err := u.client.Get(sendSmsURL)
+	if err != nil {
+		return fmt.Errorf("kannel: sendsms failed: %v", err)
+	}
+	defer res.Body.Close()
+	// Kannel answers 202 Accepted when the message was queued
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("kannel: sendsms failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// ParseMetaData parses Kannel meta-data e.g. "?smpp?ussd_service_op=1&its_session_info=0100"
+// into the values of each group
+func ParseMetaData(metaData string) map[string]url.Values {
+	groups := make(map[string]url.Values)
+	// groups are written as ?group?key=value&key=value
+	parts := strings.Split(metaData, "?")
+	for i := 1; i+1 < len(parts); i += 2 {
+		values, err := url.ParseQuery(parts[i+1])
+		if err != nil {
+			continue
+		}
+		groups[parts[i]] = values
+	}
+	return groups
+}
+
+// EncodeMetaData encodes the values of each group as Kannel meta-data
+func EncodeMetaData(groups map[string]url.Values) string {
+	var b strings.Builder
+	for group, values := range groups {
+		b.WriteString("?" + group + "?" + values.Encode())
+	}
+	return b.String()
+}
+
+// parseServiceOp parses the ussd_service_op TLV, which Kannel writes as a
+// decimal for integer TLVs and as the raw byte for octetstring TLVs. The TLV
+// is required, without it a request cannot be told apart from a new dialogue
+func parseServiceOp(value string) (int, error) {
+	if value == "" {
+		return 0, fmt.Errorf("%s is required in the meta-data, see the smpp-tlv groups", tlvServiceOp)
+	}
+	op, err := strconv.Atoi(value)
+	if err == nil {
+		return op, nil
+	}
+	if len(value) == 1 {
+		return int(value[0]), nil
+	}
+	return 0, fmt.Errorf("invalid %s '%s'", tlvServiceOp, value)
+}
@@ -0,0 +1,165 @@
+package kannel_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nndi-oss/ussdproxy/app/echo"
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/server"
+	"github.com/nndi-oss/ussdproxy/pkg/session/memory"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/kannel"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/kannel/kanneltest"
+)
+
+const (
+	msisdn      = "265991234567"
+	serviceCode = "*123#"
+)
+
+// newProxy serves the provider with the echo application for a fake Kannel
+func newProxy(t *testing.T, provider *kannel.KannelUssdHandler) *httptest.Server {
+	t.Helper()
+	proxy := httptest.NewServer(server.NewHandler(provider, memory.New(), echo.NewEchoApplication()))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestGetURLReply(t *testing.T) {
+	proxy := newProxy(t, kannel.New(kannel.Config{}))
+	fake := kanneltest.NewServer(proxy.URL, "", "")
+	defer fake.Close()
+
+	reply, err := fake.Deliver(msisdn, serviceCode, serviceCode, kannel.ServiceOpPSSRIndication, "0100")
+	if err != nil {
+		t.Fatalf("failed to deliver the dialled service code: %v", err)
+	}
+	if reply == nil {
+		t.Fatal("expected a reply in the get-url response")
+	}
+	if reply.ServiceOp != "2" {
+		t.Errorf("expected ussd_service_op 2 (USSR request), got '%s'", reply.ServiceOp)
+	}
+	if got := kannel.ParseMetaData(reply.MetaData)["smpp"].Get("its_session_info"); got != "0100" {
+		t.Errorf("expected its_session_info 0100 to be echoed, got '%s'", got)
+	}
+	if reply.Text != string(ussdproxy.ReceiveReadyPduAscii)+ussdproxy.NoDataResponse {
+		t.Errorf("expected a receive ready response, got '%s'", reply.Text)
+	}
+}
+
+func TestSendSmsReply(t *testing.T) {
+	fake := kanneltest.NewServer("", "ussd", "secret")
+	defer fake.Close()
+	fake.GetURL = newProxy(t, kannel.New(kannel.Config{SendSmsURL: fake.SendSmsURL(), Username: "ussd", Password: "secret"})).URL
+
+	reply, err := fake.Deliver(msisdn, serviceCode, serviceCode, kannel.ServiceOpPSSRIndication, "")
+	if err != nil {
+		t.Fatalf("failed to deliver the dialled service code: %v", err)
+	}
+	if reply != nil {
+		t.Fatalf("expected an empty get-url response, got %+v", reply)
+	}
+	sent := fake.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected a message sent through sendsms, got %d", len(sent))
+	}
+	if sent[0].To != msisdn || sent[0].From != serviceCode || sent[0].SMSC != "ussd" {
+		t.Errorf("expected the reply to %s from %s through ussd, got %+v", msisdn, serviceCode, sent[0])
+	}
+	if sent[0].ServiceOp != "2" {
+		t.Errorf("expected ussd_service_op 2 (USSR request), got '%s'", sent[0].ServiceOp)
+	}
+}
+
+func TestSendSmsRejected(t *testing.T) {
+	fake := kanneltest.NewServer("", "ussd", "secret")
+	defer fake.Close()
+	provider := kannel.New(kannel.Config{SendSmsURL: fake.SendSmsURL(), Username: "ussd", Password: "wrong"})
+
+	response := ussd.NewResponse()
+	response.UssdRequest = ussdproxy.NewUssdRequest("kannel", msisdn, msisdn, "ussd", nil)
+	if _, err := provider.Write(ussdproxy.NewReceiveReadyResponse(), response); err == nil {
+		t.Error("expected an error when sendsms rejects the credentials")
+	}
+	if len(fake.Sent()) != 0 {
+		t.Errorf("expected no messages sent, got %+v", fake.Sent())
+	}
+}
+
+func TestRead(t *testing.T) {
+	provider := kannel.New(kannel.Config{})
+
+	request := ussd.NewRequest()
+	request.Method = "GET"
+	request.Query.Set("from", msisdn)
+	request.Query.Set("text", "D;hello")
+	request.Query.Set("smsc", "ussd")
+	request.Query.Set("meta-data", "?smpp?ussd_service_op=18&its_session_info=0100")
+	udcpRequest, err := provider.Read(request)
+	if err != nil {
+		t.Fatalf("failed to read the get-url request: %v", err)
+	}
+	if udcpRequest.UssdRequest().Initial() {
+		t.Error("expected a USSR confirm not to start a dialogue")
+	}
+	if got := udcpRequest.UssdRequest().SessionID(); got != msisdn+"/0100" {
+		t.Errorf("expected the session of the subscriber's dialogue, got '%s'", got)
+	}
+	if got := string(udcpRequest.Data()); got != "hello" {
+		t.Errorf("expected the data 'hello', got '%s'", got)
+	}
+
+	// post-url sends the parameters in headers and the text in the body
+	request = ussd.NewRequest()
+	request.Method = "POST"
+	request.Header.Set("X-Kannel-From", msisdn)
+	request.Header.Set("X-Kannel-Meta-Data", "?smpp?ussd_service_op=1")
+	request.Body = []byte(serviceCode)
+	udcpRequest, err = provider.Read(request)
+	if err != nil {
+		t.Fatalf("failed to read the post-url request: %v", err)
+	}
+	if !udcpRequest.UssdRequest().Initial() {
+		t.Error("expected a PSSR indication to start a dialogue")
+	}
+
+	request = ussd.NewRequest()
+	request.Method = "GET"
+	request.Query.Set("text", serviceCode)
+	if _, err := provider.Read(request); err == nil {
+		t.Error("expected an error for a request without from")
+	}
+
+	// without ussd_service_op every hop would start a new dialogue
+	request = ussd.NewRequest()
+	request.Method = "GET"
+	request.Query.Set("from", msisdn)
+	request.Query.Set("text", "D;hello")
+	if _, err := provider.Read(request); !errors.Is(err, ussd.ErrInvalidRequest) {
+		t.Errorf("expected an invalid request without ussd_service_op, got %v", err)
+	}
+}
+
+func TestWriteEnd(t *testing.T) {
+	provider := kannel.New(kannel.Config{})
+	response := ussd.NewResponse()
+	if _, err := provider.WriteEnd(ussdproxy.NewUserAbortReleaseDialogueResponse(), response); err != nil {
+		t.Fatalf("failed to write the response: %v", err)
+	}
+	if got := response.Header.Get(kannel.MetaDataHeader); got != "?smpp?ussd_service_op=17" {
+		t.Errorf("expected ussd_service_op 17 (PSSR response), got '%s'", got)
+	}
+}
+
+func TestParseMetaData(t *testing.T) {
+	groups := kannel.ParseMetaData("?smpp?ussd_service_op=18&its_session_info=0100?other?key=value")
+	if got := groups["smpp"].Get("ussd_service_op"); got != "18" {
+		t.Errorf("expected ussd_service_op 18, got '%s'", got)
+	}
+	if got := groups["other"].Get("key"); got != "value" {
+		t.Errorf("expected the other group's key, got '%s'", got)
+	}
+}
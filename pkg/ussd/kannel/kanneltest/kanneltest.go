@@ -0,0 +1,140 @@
+// kanneltest provides a fake Kannel bearerbox for testing the kannel provider
+// without an SMSC. The fake delivers the input of a subscriber to get-url the
+// way Kannel's sms-service does and records the replies sent to its sendsms
+// interface
+package kanneltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/kannel"
+)
+
+// SendSmsPath is the path of the fake's sendsms interface
+const SendSmsPath = "/cgi-bin/sendsms"
+
+// Message is a USSD message sent to the subscriber
+type Message struct {
+	To        string
+	From      string
+	SMSC      string
+	Text      string
+	ServiceOp string // ussd_service_op of the message e.g. 2 to continue the dialogue
+	MetaData  string
+}
+
+// Server is a fake Kannel
+type Server struct {
+	*httptest.Server
+
+	// GetURL is the get-url of the sms-service e.g. the callback URL of ussdproxy
+	GetURL   string
+	Username string
+	Password string
+
+	mu   sync.Mutex // guards sent
+	sent []Message
+}
+
+// NewServer starts a fake Kannel which delivers messages to getURL. It is
+// closed with Close
+func NewServer(getURL, username, password string) *Server {
+	s := &Server{GetURL: getURL, Username: username, Password: password}
+	mux := http.NewServeMux()
+	mux.HandleFunc(SendSmsPath, s.sendSms)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// SendSmsURL the URL of the fake's sendsms interface
+func (s *Server) SendSmsURL() string {
+	return s.URL + SendSmsPath
+}
+
+func (s *Server) sendSms(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("username") != s.Username || query.Get("password") != s.Password {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "Authorization failed for sendsms")
+		return
+	}
+	if query.Get("to") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing receiver number, rejected")
+		return
+	}
+	s.record(Message{
+		To:        query.Get("to"),
+		From:      query.Get("from"),
+		SMSC:      query.Get("smsc"),
+		Text:      query.Get("text"),
+		ServiceOp: kannel.ParseMetaData(query.Get("meta-data"))["smpp"].Get("ussd_service_op"),
+		MetaData:  query.Get("meta-data"),
+	})
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, "0: Accepted for delivery")
+}
+
+func (s *Server) record(message Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, message)
+}
+
+// Sent the messages sent through sendsms or as replies to get-url, in order
+func (s *Server) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.sent...)
+}
+
+// Deliver delivers the text of the subscriber from to the service code to with
+// the ussd_service_op and its_session_info TLVs as the get-url request of the
+// sms-service. A reply in the get-url response is recorded and returned,
+// replies sent through sendsms are only recorded
+func (s *Server) Deliver(from, to, text string, serviceOp int, sessionInfo string) (*Message, error) {
+	tlvs := url.Values{}
+	tlvs.Set("ussd_service_op", fmt.Sprint(serviceOp))
+	if sessionInfo != "" {
+		tlvs.Set("its_session_info", sessionInfo)
+	}
+	query := url.Values{}
+	query.Set("from", from)
+	query.Set("to", to)
+	query.Set("text", text)
+	query.Set("smsc", "ussd")
+	query.Set("meta-data", kannel.EncodeMetaData(map[string]url.Values{"smpp": tlvs}))
+
+	res, err := http.Get(s.GetURL + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get-url failed with status %d: %s", res.StatusCode, body)
+	}
+	// omit-empty, an empty response is not sent to the subscriber
+	if len(body) == 0 {
+		return nil, nil
+	}
+	metaData := res.Header.Get(kannel.MetaDataHeader)
+	reply := Message{
+		To:        from,
+		From:      to,
+		SMSC:      "ussd",
+		Text:      string(body),
+		ServiceOp: kannel.ParseMetaData(metaData)["smpp"].Get("ussd_service_op"),
+		MetaData:  metaData,
+	}
+	s.record(reply)
+	return &reply, nil
+}
@@ -0,0 +1,55 @@
+package ussd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+)
+
+// MaxMultipartMemory is the memory used to parse multipart form bodies received by net/http
+const MaxMultipartMemory = 1 << 20
+
+// RequestFromHTTP reads the callback received by a net/http server. The body is
+// read completely and r.Body is replaced so it can be read again
+func RequestFromHTTP(r *http.Request) (*Request, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	request := NewRequest()
+	request.Method = r.Method
+	request.Path = r.URL.Path
+	request.RemoteAddr = r.RemoteAddr
+	request.Body = body
+	request.Header = r.Header.Clone()
+	request.Query = r.URL.Query()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := r.ParseMultipartForm(MaxMultipartMemory); err != nil && err != http.ErrNotMultipart {
+		return nil, err
+	}
+	// PostForm includes the values of multipart forms
+	for key, values := range r.PostForm {
+		request.Form[key] = append(request.Form[key], values...)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return request, nil
+}
+
+// WriteHTTP writes the response to a net/http response writer
+func (r *Response) WriteHTTP(w http.ResponseWriter) error {
+	for key, values := range r.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	statusCode := r.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	_, err := w.Write(r.Body.Bytes())
+	return err
+}
@@ -0,0 +1,74 @@
+package ussd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Factory creates a provider from the options of its binding in the configuration
+type Factory func(options map[string]interface{}) (UssdProvider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a provider available by name to the configuration, packages
+// of providers call Register from an init function so importing the package
+// is enough to use the provider, including providers from other modules.
+// Register panics if it is called twice with the same name
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("ussd: Register factory is nil for " + name)
+	}
+	if _, exists := factories[name]; exists {
+		panic("ussd: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates the provider registered with the given name
+func New(name string, options map[string]interface{}) (UssdProvider, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider '%s', registered providers are %v", name, Registered())
+	}
+	provider, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider '%s': %v", name, err)
+	}
+	return provider, nil
+}
+
+// Registered the sorted names of the registered providers
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DecodeOptions decodes the options of a provider binding into the provider's
+// configuration struct using its mapstructure tags. Unknown options are an error
+func DecodeOptions(options map[string]interface{}, config interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           config,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(options)
+}
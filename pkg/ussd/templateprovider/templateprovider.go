@@ -0,0 +1,351 @@
+// templateprovider is a USSD provider defined entirely in the configuration,
+// for aggregators which only differ in the names of their fields. The fields of
+// the callback are extracted with expressions of the form source:path where
+// source is one of
+//
+//	form    the query or the form in the body, the default when there is no source e.g. sessionId
+//	query   the query e.g. query:sessionId
+//	header  a header e.g. header:X-Session-Id
+//	json    a dotted path in a JSON body e.g. json:session.id
+//	xpath   an absolute path or //name in an XML body, with a trailing @attribute
+//	        for attributes e.g. xpath:/request/sessionId or xpath:/request/@type
+//
+// The responses are text/template templates executed with the response Message,
+// the SessionID and Msisdn, the additional Fields of the request and the
+// Redirect target of redirect responses. The
+// functions xml and json escape values for XML and JSON bodies, e.g.
+//
+//	ussd:
+//	  providers:
+//	  - name: aggregator
+//	    provider: template
+//	    callback_url: /ussd/callback/aggregator
+//	    options:
+//	      request:
+//	        session_id: json:session.id
+//	        msisdn: json:session.msisdn
+//	        data: json:input
+//	        type: json:session.state
+//	        initial_types: [NEW]
+//	        release_types: [TIMEOUT, ABORT]
+//	        fields:
+//	          operator: header:X-Operator
+//	      response:
+//	        content_type: application/json
+//	        continue:
+//	          body: '{"sessionId": {{json .SessionID}}, "text": {{json .Message}}, "action": "prompt"}'
+//	        end:
+//	          body: '{"sessionId": {{json .SessionID}}, "text": {{json .Message}}, "action": "close"}'
+//
+// The subscriber dialled the service code only when the type is one of the
+// initial_types. Empty data otherwise continues the dialogue as a Receive Ready
+// PDU
+package templateprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/jsonprovider"
+)
+
+// metadataFieldPrefix prefixes the Metadata keys of the additional fields of the request
+const metadataFieldPrefix = "template.field."
+
+// RequestConfig the expressions of the fields of the callback
+type RequestConfig struct {
+	SessionID    string            `mapstructure:"session_id"`    // required
+	Msisdn       string            `mapstructure:"msisdn"`        // required
+	Data         string            `mapstructure:"data"`          // Input of the subscriber
+	Channel      string            `mapstructure:"channel"`       // default: the msisdn
+	Type         string            `mapstructure:"type"`          // Type of the message
+	InitialTypes []string          `mapstructure:"initial_types"` // Values of type when the subscriber dialled the service code
+	ReleaseTypes []string          `mapstructure:"release_types"` // Values of type when the network ended the session e.g. a timeout
+	Fields       map[string]string `mapstructure:"fields"`        // Additional fields available to the response templates as .Fields
+}
+
+// ResponseTemplate the body and status code of a response
+type ResponseTemplate struct {
+	Body       string `mapstructure:"body"`        // required
+	StatusCode int    `mapstructure:"status_code"` // default: 200
+}
+
+// ResponseConfig the responses which continue and end the session
+type ResponseConfig struct {
+	ContentType string           `mapstructure:"content_type"` // default: text/plain; charset=utf-8
+	Continue    ResponseTemplate `mapstructure:"continue"`
+	End         ResponseTemplate `mapstructure:"end"`
+	Redirect    ResponseTemplate `mapstructure:"redirect"` // Hands the session over to .Redirect. default: the end response
+}
+
+// Config is the configuration of the template provider's options
+type Config struct {
+	Request  RequestConfig  `mapstructure:"request"`
+	Response ResponseConfig `mapstructure:"response"`
+}
+
+// TemplateData is the data the response templates are executed with
+type TemplateData struct {
+	Message   string            // The response of the application
+	SessionID string            // Session ID of the request
+	Msisdn    string            // Phone number of the subscriber
+	Fields    map[string]string // Additional fields of the request
+	Redirect  string            // Service the session is handed over to by a redirect response
+}
+
+var templateFuncs = template.FuncMap{
+	"xml": func(value string) (string, error) {
+		var b strings.Builder
+		if err := xml.EscapeText(&b, []byte(value)); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	},
+	"json": func(value interface{}) (string, error) {
+		raw, err := json.Marshal(value)
+		return string(raw), err
+	},
+}
+
+func init() {
+	ussd.Register("template", func(options map[string]interface{}) (ussd.UssdProvider, error) {
+		cfg := Config{}
+		if err := ussd.DecodeOptions(options, &cfg); err != nil {
+			return nil, err
+		}
+		return New(cfg)
+	})
+}
+
+// TemplateUssdHandler reads callbacks with the configured field expressions and
+// writes the configured response templates
+type TemplateUssdHandler struct {
+	config           Config
+	continueTemplate *template.Template
+	endTemplate      *template.Template
+	redirectTemplate *template.Template
+}
+
+// New creates the provider, the configuration is invalid if a required field,
+// expression or template is missing or does not parse
+func New(cfg Config) (*TemplateUssdHandler, error) {
+	if cfg.Request.SessionID == "" || cfg.Request.Msisdn == "" {
+		return nil, fmt.Errorf("request.session_id and request.msisdn are required")
+	}
+	expressions := map[string]string{
+		"session_id": cfg.Request.SessionID,
+		"msisdn":     cfg.Request.Msisdn,
+		"data":       cfg.Request.Data,
+		"channel":    cfg.Request.Channel,
+		"type":       cfg.Request.Type,
+	}
+	for name, expression := range cfg.Request.Fields {
+		expressions["fields."+name] = expression
+	}
+	for name, expression := range expressions {
+		if _, _, err := parseExpression(expression); err != nil {
+			return nil, fmt.Errorf("request.%s: %v", name, err)
+		}
+	}
+
+	continueTemplate, err := parseTemplate("continue", cfg.Response.Continue.Body)
+	if err != nil {
+		return nil, err
+	}
+	endTemplate, err := parseTemplate("end", cfg.Response.End.Body)
+	if err != nil {
+		return nil, err
+	}
+	redirectTemplate := endTemplate
+	if cfg.Response.Redirect.Body != "" {
+		if redirectTemplate, err = parseTemplate("redirect", cfg.Response.Redirect.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.Response.Redirect.StatusCode = cfg.Response.End.StatusCode
+	}
+	if cfg.Response.ContentType == "" {
+		cfg.Response.ContentType = "text/plain; charset=utf-8"
+	}
+	return &TemplateUssdHandler{
+		config:           cfg,
+		continueTemplate: continueTemplate,
+		endTemplate:      endTemplate,
+		redirectTemplate: redirectTemplate,
+	}, nil
+}
+
+func parseTemplate(name, body string) (*template.Template, error) {
+	if body == "" {
+		return nil, fmt.Errorf("response.%s.body is required", name)
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("response.%s.body: %v", name, err)
+	}
+	return t, nil
+}
+
+func (u *TemplateUssdHandler) Read(request *ussd.Request) (ussdproxy.UdcpRequest, error) {
+	e := &extractor{request: request}
+	fields := u.config.Request
+	sessionID, hasSessionID := e.extract(fields.SessionID)
+	msisdn, hasMsisdn := e.extract(fields.Msisdn)
+	if e.err != nil {
+		return nil, fmt.Errorf("%w: %v", ussd.ErrInvalidRequest, e.err)
+	}
+	if !hasSessionID || !hasMsisdn || sessionID == "" {
+		return nil, fmt.Errorf("%w, got body: %s", ussd.ErrInvalidRequest, string(request.Body))
+	}
+	data, _ := e.extract(fields.Data)
+	msgType, _ := e.extract(fields.Type)
+	channel, hasChannel := e.extract(fields.Channel)
+	if !hasChannel {
+		channel = msisdn
+	}
+
+	initial := ussd.ContainsString(fields.InitialTypes, msgType)
+	switch {
+	case ussd.ContainsString(fields.ReleaseTypes, msgType):
+		// the session has ended on the network, release the dialogue
+		data, initial = string(ussdproxy.ReleaseDialogPduAscii), false
+	case data == "" && !initial:
+		// the subscriber sent nothing, ask the application for more data
+		data = string(ussdproxy.ReceiveReadyPduAscii)
+	}
+
+	newUssdRequest := ussdproxy.NewUssdRequest
+	if initial {
+		newUssdRequest = ussdproxy.NewInitialUssdRequest
+	}
+	ussdRequest := newUssdRequest("template", sessionID, msisdn, channel, []byte(data))
+	for name, expression := range fields.Fields {
+		if value, ok := e.extract(expression); ok {
+			ussdRequest.Metadata()[metadataFieldPrefix+name] = value
+		}
+	}
+	return ussdproxy.ParseUssdRequest(ussdRequest)
+}
+
+func (u *TemplateUssdHandler) GetContentType() string {
+	return u.config.Response.ContentType
+}
+
+func (u *TemplateUssdHandler) Write(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(u.continueTemplate, u.config.Response.Continue.StatusCode, response, writer)
+}
+
+func (u *TemplateUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(u.endTemplate, u.config.Response.End.StatusCode, response, writer)
+}
+
+func (u *TemplateUssdHandler) WriteRedirect(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(u.redirectTemplate, u.config.Response.Redirect.StatusCode, response, writer)
+}
+
+func (u *TemplateUssdHandler) write(t *template.Template, statusCode int, response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	data := TemplateData{
+		Message:  string(ussdproxy.Encode(response)),
+		Fields:   make(map[string]string),
+		Redirect: response.RedirectTarget(),
+	}
+	if writer.UssdRequest != nil {
+		data.SessionID = writer.UssdRequest.SessionID()
+		data.Msisdn = writer.UssdRequest.PhoneNumber()
+		for key, value := range writer.UssdRequest.Metadata() {
+			if strings.HasPrefix(key, metadataFieldPrefix) {
+				data.Fields[key[len(metadataFieldPrefix):]] = value
+			}
+		}
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return -1, err
+	}
+	// the status code of a response to an invalid request is kept
+	if statusCode != 0 && writer.StatusCode == http.StatusOK {
+		writer.StatusCode = statusCode
+	}
+	return writer.Write(body.Bytes())
+}
+
+// parseExpression splits the expression into its source and path
+func parseExpression(expression string) (string, string, error) {
+	if expression == "" {
+		return "", "", nil
+	}
+	source, path := "form", expression
+	if i := strings.Index(expression, ":"); i >= 0 {
+		source, path = expression[:i], expression[i+1:]
+	}
+	switch source {
+	case "form", "query", "header", "json":
+	case "xpath":
+		if !strings.HasPrefix(path, "/") {
+			return "", "", fmt.Errorf("xpath '%s' must be absolute or start with //", path)
+		}
+	default:
+		return "", "", fmt.Errorf("unknown source '%s' in '%s'", source, expression)
+	}
+	if path == "" {
+		return "", "", fmt.Errorf("missing path in '%s'", expression)
+	}
+	return source, path, nil
+}
+
+// extractor extracts fields from a request, the body is decoded once
+type extractor struct {
+	request *ussd.Request
+
+	json map[string]interface{}
+	xml  *xmlNode
+	err  error // the body could not be decoded
+}
+
+// extract the value of the expression, false if the field is missing
+func (e *extractor) extract(expression string) (string, bool) {
+	source, path, err := parseExpression(expression)
+	if err != nil || source == "" {
+		return "", false
+	}
+	switch source {
+	case "form":
+		return e.request.LookupFormValue(path)
+	case "query":
+		values, ok := e.request.Query[path]
+		if !ok || len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	case "header":
+		values, ok := e.request.Header[http.CanonicalHeaderKey(path)]
+		if !ok || len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	case "json":
+		if e.json == nil && e.err == nil {
+			e.err = json.Unmarshal(e.request.Body, &e.json)
+		}
+		if e.err != nil {
+			return "", false
+		}
+		return jsonprovider.Lookup(e.json, path)
+	case "xpath":
+		if e.xml == nil && e.err == nil {
+			e.xml, e.err = parseXML(e.request.Body)
+		}
+		if e.err != nil {
+			return "", false
+		}
+		return e.xml.lookup(path)
+	}
+	return "", false
+}
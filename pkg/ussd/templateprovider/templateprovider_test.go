@@ -0,0 +1,199 @@
+package templateprovider_test
+
+import (
+	"net/http"
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/templateprovider"
+)
+
+func TestRead(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   templateprovider.RequestConfig
+		request  func(r *ussd.Request)
+		initial  bool
+		data     string
+		operator string
+		invalid  bool
+		pduType  ussdproxy.PduType
+	}{
+		{
+			name:   "form",
+			config: templateprovider.RequestConfig{SessionID: "sessionId", Msisdn: "query:msisdn", Data: "text"},
+			request: func(r *ussd.Request) {
+				r.Form.Set("sessionId", "1234")
+				r.Query.Set("msisdn", "265991234567")
+				r.Form.Set("text", "D;hello")
+			},
+			data: "hello",
+		},
+		{
+			name:   "form without text continues the dialogue",
+			config: templateprovider.RequestConfig{SessionID: "sessionId", Msisdn: "msisdn", Data: "text"},
+			request: func(r *ussd.Request) {
+				r.Form.Set("sessionId", "1234")
+				r.Form.Set("msisdn", "265991234567")
+			},
+			pduType: ussdproxy.ReceiveReadyPduType,
+		},
+		{
+			name: "json",
+			config: templateprovider.RequestConfig{
+				SessionID:    "json:session.id",
+				Msisdn:       "json:session.msisdn",
+				Data:         "json:input",
+				Type:         "json:session.state",
+				InitialTypes: []string{"NEW"},
+				Fields:       map[string]string{"operator": "header:X-Operator"},
+			},
+			request: func(r *ussd.Request) {
+				r.Header.Set("X-Operator", "airtel")
+				r.Body = []byte(`{"session": {"id": 1234, "msisdn": "265991234567", "state": "NEW"}, "input": "*123#"}`)
+			},
+			initial:  true,
+			data:     ussdproxy.NoDataResponse,
+			operator: "airtel",
+		},
+		{
+			name: "xpath",
+			config: templateprovider.RequestConfig{
+				SessionID:    "xpath://session",
+				Msisdn:       "xpath:/ussd/msisdn",
+				Data:         "xpath:/ussd/input",
+				Type:         "xpath:/ussd/@type",
+				ReleaseTypes: []string{"abort"},
+			},
+			request: func(r *ussd.Request) {
+				r.Body = []byte(`<?xml version="1.0"?><ussd type="abort"><session>1234</session><msisdn>265991234567</msisdn><input></input></ussd>`)
+			},
+			pduType: ussdproxy.ReleaseDialogPduType,
+		},
+		{
+			name:    "missing session",
+			config:  templateprovider.RequestConfig{SessionID: "xpath:/ussd/session", Msisdn: "xpath:/ussd/msisdn"},
+			request: func(r *ussd.Request) { r.Body = []byte(`<ussd><msisdn>265991234567</msisdn></ussd>`) },
+			invalid: true,
+		},
+		{
+			name:    "invalid json",
+			config:  templateprovider.RequestConfig{SessionID: "json:sessionId", Msisdn: "json:msisdn"},
+			request: func(r *ussd.Request) { r.Body = []byte(`{"sessionId":`) },
+			invalid: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			provider, err := templateprovider.New(templateprovider.Config{
+				Request: tc.config,
+				Response: templateprovider.ResponseConfig{
+					Continue: templateprovider.ResponseTemplate{Body: "CON {{.Message}}"},
+					End:      templateprovider.ResponseTemplate{Body: "END {{.Message}}"},
+				},
+			})
+			if err != nil {
+				t.Fatalf("failed to create the provider: %v", err)
+			}
+			r := ussd.NewRequest()
+			r.Method = "POST"
+			tc.request(r)
+			request, err := provider.Read(r)
+			if tc.invalid {
+				if err == nil {
+					t.Fatal("expected an invalid request")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read the request: %v", err)
+			}
+			ussdRequest := request.UssdRequest()
+			if ussdRequest.SessionID() != "1234" || ussdRequest.PhoneNumber() != "265991234567" {
+				t.Errorf("expected session 1234 of 265991234567, got %s of %s", ussdRequest.SessionID(), ussdRequest.PhoneNumber())
+			}
+			if ussdRequest.Initial() != tc.initial {
+				t.Errorf("expected initial to be %v", tc.initial)
+			}
+			if tc.pduType != 0 && request.Header().Type != tc.pduType {
+				t.Errorf("expected a %s request, got %s", string(tc.pduType), request.ToString())
+			}
+			if tc.pduType == 0 && string(request.Data()) != tc.data {
+				t.Errorf("expected data '%s', got '%s'", tc.data, string(request.Data()))
+			}
+			if tc.operator != "" && ussdRequest.Metadata()["template.field.operator"] != tc.operator {
+				t.Errorf("expected the operator field '%s', got %v", tc.operator, ussdRequest.Metadata())
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	provider, err := templateprovider.New(templateprovider.Config{
+		Request: templateprovider.RequestConfig{
+			SessionID: "sessionId",
+			Msisdn:    "msisdn",
+			Fields:    map[string]string{"code": "serviceCode"},
+		},
+		Response: templateprovider.ResponseConfig{
+			ContentType: "text/xml",
+			Continue:    templateprovider.ResponseTemplate{Body: `<r session="{{xml .SessionID}}" code="{{.Fields.code}}">{{xml .Message}}</r>`},
+			End:         templateprovider.ResponseTemplate{Body: `{"msisdn": {{json .Msisdn}}, "text": {{json .Message}}}`, StatusCode: http.StatusCreated},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the provider: %v", err)
+	}
+	r := ussd.NewRequest()
+	r.Method = "POST"
+	r.Form.Set("sessionId", "1&2")
+	r.Form.Set("msisdn", "265991234567")
+	r.Form.Set("serviceCode", "*123#")
+	request, err := provider.Read(r)
+	if err != nil {
+		t.Fatalf("failed to read the request: %v", err)
+	}
+
+	response := ussd.NewResponse()
+	response.UssdRequest = request.UssdRequest()
+	provider.Write(ussdproxy.NewDataResponse(request, []byte("a<b"), false), response)
+	if got, expected := response.Body.String(), `<r session="1&amp;2" code="*123#">D;a&lt;b</r>`; got != expected {
+		t.Errorf("expected the continue body %s, got %s", expected, got)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", response.StatusCode)
+	}
+
+	response = ussd.NewResponse()
+	response.UssdRequest = request.UssdRequest()
+	provider.WriteEnd(ussdproxy.NewDataResponse(request, []byte(`"bye"`), false), response)
+	if got, expected := response.Body.String(), `{"msisdn": "265991234567", "text": "D;\"bye\""}`; got != expected {
+		t.Errorf("expected the end body %s, got %s", expected, got)
+	}
+	if response.StatusCode != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", response.StatusCode)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	responses := templateprovider.ResponseConfig{
+		Continue: templateprovider.ResponseTemplate{Body: "CON {{.Message}}"},
+		End:      templateprovider.ResponseTemplate{Body: "END {{.Message}}"},
+	}
+	testCases := map[string]templateprovider.Config{
+		"missing session": {Request: templateprovider.RequestConfig{Msisdn: "msisdn"}, Response: responses},
+		"unknown source":  {Request: templateprovider.RequestConfig{SessionID: "cookie:id", Msisdn: "msisdn"}, Response: responses},
+		"relative xpath":  {Request: templateprovider.RequestConfig{SessionID: "xpath:session", Msisdn: "msisdn"}, Response: responses},
+		"missing body":    {Request: templateprovider.RequestConfig{SessionID: "id", Msisdn: "msisdn"}},
+		"invalid template": {
+			Request:  templateprovider.RequestConfig{SessionID: "id", Msisdn: "msisdn"},
+			Response: templateprovider.ResponseConfig{Continue: templateprovider.ResponseTemplate{Body: "{{.Message"}, End: responses.End},
+		},
+	}
+	for name, cfg := range testCases {
+		if _, err := templateprovider.New(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
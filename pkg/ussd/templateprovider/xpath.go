@@ -0,0 +1,98 @@
+package templateprovider
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// xmlNode an element of an XML document
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     strings.Builder
+	children []*xmlNode
+}
+
+// parseXML parses the document into a tree of its elements, the root is a
+// node without a name whose only child is the document element
+func parseXML(body []byte) (*xmlNode, error) {
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local, attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				node.attrs[attr.Name.Local] = attr.Value
+			}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.text.Write(t)
+		}
+	}
+	if len(root.children) == 0 {
+		return nil, errors.New("the xml document is empty")
+	}
+	return root, nil
+}
+
+// lookup the text of the element or the value of the attribute at the path, a
+// subset of XPath with absolute paths e.g. /request/sessionId, paths starting
+// anywhere in the document e.g. //sessionId and a trailing attribute e.g. /request/@type
+func (n *xmlNode) lookup(path string) (string, bool) {
+	nodes := []*xmlNode{n}
+	if strings.HasPrefix(path, "//") {
+		nodes = n.descendants()
+		path = path[2:]
+	} else {
+		path = path[1:]
+	}
+	steps := strings.Split(path, "/")
+	for i, step := range steps {
+		if strings.HasPrefix(step, "@") && i == len(steps)-1 {
+			for _, node := range nodes {
+				if value, ok := node.attrs[step[1:]]; ok {
+					return value, true
+				}
+			}
+			return "", false
+		}
+		var matches []*xmlNode
+		for _, node := range nodes {
+			for _, child := range node.children {
+				if step == "*" || child.name == step {
+					matches = append(matches, child)
+				}
+			}
+		}
+		nodes = matches
+	}
+	if len(nodes) == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(nodes[0].text.String()), true
+}
+
+// descendants the node and all the elements below it, in document order
+func (n *xmlNode) descendants() []*xmlNode {
+	nodes := []*xmlNode{n}
+	for _, child := range n.children {
+		nodes = append(nodes, child.descendants()...)
+	}
+	return nodes
+}
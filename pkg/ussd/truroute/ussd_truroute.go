@@ -3,12 +3,10 @@ package truroute
 import (
 	"bytes"
 	"encoding/xml"
-	"errors"
 	"fmt"
-	"io"
 
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
-	"github.com/valyala/fasthttp"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
 )
 
 const (
@@ -44,6 +42,22 @@ type TruRouteResponsePremium struct {
 	Ref  string `xml:"ref"`
 }
 
+// PremiumResponse is a UdcpResponse the subscriber is billed for, see WithPremium
+type PremiumResponse struct {
+	ussdproxy.UdcpResponse
+	Premium TruRouteResponsePremium
+}
+
+// WithPremium attaches premium billing to a response of an application, the
+// TruRoute writer sends the cost and reference with the response. Other
+// providers write the response as usual
+func WithPremium(response ussdproxy.UdcpResponse, cost int, ref string) *PremiumResponse {
+	return &PremiumResponse{
+		UdcpResponse: response,
+		Premium:      TruRouteResponsePremium{Cost: cost, Ref: ref},
+	}
+}
+
 func (t *TruRouteResponse) isResponse() bool {
 	return t.Type == ussdContinueResponseCode
 }
@@ -77,21 +91,30 @@ func New() *TrurouteUssdHandler {
 	return &TrurouteUssdHandler{}
 }
 
-func (u *TrurouteUssdHandler) Read(ctx *fasthttp.RequestCtx) (ussdproxy.UdcpRequest, error) {
-	requestData := ctx.Request.Body()
-	if requestData == nil {
-		return nil, errors.New("truroute: request was empty or nil")
+func init() {
+	ussd.Register("truroute", func(options map[string]interface{}) (ussd.UssdProvider, error) {
+		// the provider has no options
+		if err := ussd.DecodeOptions(options, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return New(), nil
+	})
+}
+
+func (u *TrurouteUssdHandler) Read(request *ussd.Request) (ussdproxy.UdcpRequest, error) {
+	requestData := request.Body
+	if len(requestData) == 0 {
+		return nil, fmt.Errorf("%w: truroute request was empty", ussd.ErrInvalidRequest)
 	}
 
 	var trRequest *TruRouteRequest
 	err := xml.Unmarshal(requestData, &trRequest)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ussd.ErrInvalidRequest, err)
 	}
 
 	if trRequest.Msisdn == "" || trRequest.Session == "" {
-		ctx.SetStatusCode(400)
-		return nil, fmt.Errorf("invalid request, got body: %s", string(ctx.Request.Body()))
+		return nil, fmt.Errorf("%w, got body: %s", ussd.ErrInvalidRequest, string(request.Body))
 	}
 
 	return parseUssdRequest(&UssdRequest{
@@ -99,6 +122,7 @@ func (u *TrurouteUssdHandler) Read(ctx *fasthttp.RequestCtx) (ussdproxy.UdcpRequ
 		PhoneNumber: string(trRequest.Msisdn),
 		Data:        []byte(trRequest.Message),
 		Channel:     string(trRequest.Msisdn),
+		Initial:     trRequest.Type == ussdInitialRequestCode,
 	})
 }
 
@@ -106,27 +130,27 @@ func (u *TrurouteUssdHandler) GetContentType() string {
 	return "text/xml; charset=ascii"
 }
 
-func (u *TrurouteUssdHandler) Write(response ussdproxy.UdcpResponse, writer io.Writer) (int, error) {
-	trResponse := &TruRouteResponse{
-		Type:    ussdContinueResponseCode,
-		Message: string(response.Data()),
-		Premium: TruRouteResponsePremium{Cost: 0, Ref: ""},
-		Msisdn:  "",
-	}
-	data, err := xml.Marshal(trResponse)
-	if err != nil {
-		return -1, err
-	}
-	return writer.Write(data)
+func (u *TrurouteUssdHandler) Write(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(ussdContinueResponseCode, response, writer)
+}
+
+func (u *TrurouteUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
+	return u.write(ussdReleaseResponseCode, response, writer)
 }
 
-func (u *TrurouteUssdHandler) WriteEnd(response ussdproxy.UdcpResponse, writer io.Writer) (int, error) {
+func (u *TrurouteUssdHandler) write(responseType int, response ussdproxy.UdcpResponse, writer *ussd.Response) (int, error) {
 	trResponse := &TruRouteResponse{
-		Type:    ussdContinueResponseCode,
-		Message: string(response.Data()),
+		Type:    responseType,
+		Message: string(ussdproxy.Encode(response)),
 		Premium: TruRouteResponsePremium{Cost: 0, Ref: ""},
 		Msisdn:  "",
 	}
+	if premium, ok := response.(*PremiumResponse); ok {
+		trResponse.Premium = premium.Premium
+	}
+	if writer.UssdRequest != nil {
+		trResponse.Msisdn = writer.UssdRequest.PhoneNumber()
+	}
 	data, err := xml.Marshal(trResponse)
 	if err != nil {
 		return -1, err
@@ -139,6 +163,7 @@ type UssdRequest struct {
 	Data        []byte `json:"name=text"`
 	SessionID   string `json:"name=sessionId"`
 	Channel     string `json:"name=channel,omitempty"`
+	Initial     bool   `json:"-"` // Whether the request starts the dialogue (type 1)
 }
 
 // Bytes Converts the UssdRequest to a byte array with the data is separated by 0x0
@@ -157,13 +182,16 @@ func (u *UssdRequest) Bytes() []byte {
 }
 
 func parseUssdRequest(ussdRequest *UssdRequest) (ussdproxy.UdcpRequest, error) {
-	ussdData := ussdRequest.Data
-	typ := ussdproxy.RequestPduType(string(ussdData[0:2]))
-	if typ == ussdproxy.InvalidPduType {
-		return nil, fmt.Errorf("%v got '%v'", ussdproxy.ErrInvalidHeader, typ)
+	newUssdRequest := ussdproxy.NewUssdRequest
+	if ussdRequest.Initial {
+		// the subscriber dialled the service code, which opens a fresh session
+		newUssdRequest = ussdproxy.NewInitialUssdRequest
 	}
-	//len := len(ussdData)
-	moreToSend := typ.HasMoreToSend()
-	// TODO: add a generic NewRequest func to account for the type
-	return ussdproxy.NewDataRequest(ussdData[2:], moreToSend), nil
+	return ussdproxy.ParseUssdRequest(newUssdRequest(
+		"truroute",
+		ussdRequest.SessionID,
+		ussdRequest.PhoneNumber,
+		ussdRequest.Channel,
+		ussdRequest.Data,
+	))
 }
@@ -1 +1,104 @@
 package truroute_test
+
+import (
+	"encoding/xml"
+	"testing"
+
+	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd"
+	"github.com/nndi-oss/ussdproxy/pkg/ussd/truroute"
+)
+
+const msisdn = "265991234567"
+
+// readRequest reads a TruRoute request of the given type
+func readRequest(t *testing.T, provider *truroute.TrurouteUssdHandler, requestType int, message string) ussdproxy.UdcpRequest {
+	t.Helper()
+	body, err := xml.Marshal(&truroute.TruRouteRequest{Type: requestType, Message: message, Session: "1234", Msisdn: msisdn})
+	if err != nil {
+		t.Fatalf("failed to encode the request: %v", err)
+	}
+	r := ussd.NewRequest()
+	r.Method = "POST"
+	r.Body = body
+	request, err := provider.Read(r)
+	if err != nil {
+		t.Fatalf("failed to read the request: %v", err)
+	}
+	return request
+}
+
+func TestRead(t *testing.T) {
+	provider := truroute.New()
+	if request := readRequest(t, provider, 1, "*1234#"); !request.UssdRequest().Initial() {
+		t.Error("expected type 1 to start a dialogue")
+	}
+	request := readRequest(t, provider, 2, "D;hello")
+	if request.UssdRequest().Initial() {
+		t.Error("expected type 2 to continue the dialogue")
+	}
+	if got := string(request.Data()); got != "hello" {
+		t.Errorf("expected the data 'hello', got '%s'", got)
+	}
+
+	r := ussd.NewRequest()
+	r.Method = "POST"
+	r.Body = []byte(`<ussd><type>2</type><msg>D;hello</msg></ussd>`)
+	if _, err := provider.Read(r); err == nil {
+		t.Error("expected an error for a request without a session")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	provider := truroute.New()
+	request := readRequest(t, provider, 2, "D;hello")
+
+	testCases := map[string]struct {
+		response ussdproxy.UdcpResponse
+		expected truroute.TruRouteResponse
+	}{
+		"continue": {
+			response: ussdproxy.NewDataResponse(request, []byte("hello"), false),
+			expected: truroute.TruRouteResponse{Type: 2, Message: "D;hello", Msisdn: msisdn},
+		},
+		"release": {
+			response: ussdproxy.NewUserAbortReleaseDialogueResponse(),
+			expected: truroute.TruRouteResponse{Type: 3, Message: "X;", Msisdn: msisdn},
+		},
+		"premium": {
+			response: truroute.WithPremium(ussdproxy.NewDataResponse(request, []byte("paid"), false), 50, "ref-1"),
+			expected: truroute.TruRouteResponse{
+				Type:    2,
+				Message: "D;paid",
+				Premium: truroute.TruRouteResponsePremium{Cost: 50, Ref: "ref-1"},
+				Msisdn:  msisdn,
+			},
+		},
+		"premium release": {
+			response: truroute.WithPremium(ussdproxy.NewUserAbortReleaseDialogueResponse(), 10, "ref-2"),
+			expected: truroute.TruRouteResponse{
+				Type:    3,
+				Message: "X;",
+				Premium: truroute.TruRouteResponsePremium{Cost: 10, Ref: "ref-2"},
+				Msisdn:  msisdn,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			writer := ussd.NewResponse()
+			writer.UssdRequest = request.UssdRequest()
+			if _, err := ussd.WriteResponse(provider, tc.response, writer); err != nil {
+				t.Fatalf("failed to write the response: %v", err)
+			}
+			var reply truroute.TruRouteResponse
+			if err := xml.Unmarshal(writer.Body.Bytes(), &reply); err != nil {
+				t.Fatalf("expected an XML reply, got %s: %v", writer.Body.String(), err)
+			}
+			reply.XMLName = xml.Name{}
+			if reply != tc.expected {
+				t.Errorf("expected %+v, got %+v\n%s", tc.expected, reply, writer.Body.String())
+			}
+		})
+	}
+}
@@ -1,10 +1,7 @@
 package ussd
 
 import (
-	"io"
-
 	ussdproxy "github.com/nndi-oss/ussdproxy/lib"
-	"github.com/valyala/fasthttp"
 )
 
 type UssdProvider interface {
@@ -12,20 +9,76 @@ type UssdProvider interface {
 	UssdResponseWriter
 }
 
-// UssdResponseWriter writes a ussd response to the given io.Writer
+// UssdResponseWriter writes a ussd response to the given Response
 // The ussd response is written out in the format the the connected
 // system supports
 type UssdResponseWriter interface {
 	GetContentType() string
 
-	// Write writes a UdcpResponse to the given io.Writer
-	Write(ussdproxy.UdcpResponse, io.Writer) (int, error)
+	// Write writes a UdcpResponse which continues the dialogue to the given Response
+	Write(ussdproxy.UdcpResponse, *Response) (int, error)
+
+	// WriteEnd writes a UdcpResponse which ends the dialogue to the given Response
+	WriteEnd(ussdproxy.UdcpResponse, *Response) (int, error)
+}
+
+// RedirectWriter is implemented by writers of providers which can hand the
+// session over to another service, see ussdproxy.NewRedirectResponse
+type RedirectWriter interface {
+	// WriteRedirect writes a UdcpResponse which hands the session over to its RedirectTarget
+	WriteRedirect(ussdproxy.UdcpResponse, *Response) (int, error)
+}
 
-	WriteEnd(ussdproxy.UdcpResponse, io.Writer) (int, error)
+// WriteResponse writes the response with the method of the writer for the
+// response's disposition. Redirects end the dialogue with writers which do not
+// implement RedirectWriter
+func WriteResponse(writer UssdResponseWriter, response ussdproxy.UdcpResponse, w *Response) (int, error) {
+	switch response.Disposition() {
+	case ussdproxy.DispositionContinue:
+		return writer.Write(response, w)
+	case ussdproxy.DispositionRedirect:
+		if redirectWriter, ok := writer.(RedirectWriter); ok {
+			return redirectWriter.WriteRedirect(response, w)
+		}
+	}
+	return writer.WriteEnd(response, w)
 }
 
 type UssdRequestReader interface {
-	// Read reads a UdcpRequest from the given request context
-	Read(ctx *fasthttp.RequestCtx) (ussdproxy.UdcpRequest, error)
-	// TODO?: Read(io.Reader) (ussdproxy.UdcpRequest, error)
+	// Read reads a UdcpRequest from the given request. Requests which are not
+	// valid callbacks from the provider return an error wrapping ErrInvalidRequest
+	Read(request *Request) (ussdproxy.UdcpRequest, error)
+}
+
+// CallbackMethods is implemented by providers whose callbacks are not POST
+// requests, e.g. Kannel's get-url. Callbacks of other providers must be POST
+// requests
+type CallbackMethods interface {
+	// CallbackMethods the HTTP methods of the provider's callbacks
+	CallbackMethods() []string
+}
+
+// AllowsMethod whether the provider's callbacks may use the HTTP method
+func AllowsMethod(provider UssdProvider, method string) bool {
+	methods, ok := provider.(CallbackMethods)
+	if !ok {
+		return method == "POST"
+	}
+	for _, m := range methods.CallbackMethods() {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsString whether the value is one of the values, e.g. one of the
+// initial or release types of a provider's configuration
+func ContainsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }